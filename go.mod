@@ -15,9 +15,13 @@ require (
 	github.com/go-redis/redis/v7 v7.2.0
 	github.com/go-stack/stack v1.8.0 // indirect
 	github.com/gofrs/uuid v3.2.0+incompatible
+	github.com/golang/protobuf v1.3.2
+	github.com/klauspost/compress v1.10.10
 	github.com/opentracing/opentracing-go v1.1.0
+	github.com/rcrowley/go-metrics v0.0.0-20200313005456-10cdbea86bc0
 	github.com/reddit/jwt-go/v3 v3.2.2
 	github.com/sony/gobreaker v0.4.1
+	github.com/xdg/scram v0.0.0-20180814205039-7eeb5667e42c
 	github.com/yuin/gopher-lua v0.0.0-20191220021717-ab39c6098bdb // indirect
 	go.uber.org/zap v1.15.0
 	golang.org/x/lint v0.0.0-20200302205851-738671d3881b // indirect
@@ -0,0 +1,51 @@
+package kafkabp
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/reddit/baseplate.go/errorsbp"
+)
+
+// CloseAll shuts down a service that both consumes and produces messages, in
+// the order that doesn't lose any message:
+//
+// 1. Shut down consumer via Consumer.Shutdown, so no new messages are received
+// and the in-flight ones are handled according to its DrainPolicy.
+//
+// 2. Close the producers (for example the sarama.AsyncProducer or
+// sarama.SyncProducer the handlers publish with) in order, which flushes the
+// messages the handlers produced.
+//
+// ctx is the deadline shared by all the steps. The producers are still closed
+// when the consumer fails to shut down in time. When ctx is done before all
+// the producers are closed, CloseAll returns without waiting for them, with an
+// error wrapping ctx.Err().
+//
+// The errors of all the steps are returned combined.
+func CloseAll(ctx context.Context, consumer Consumer, producers ...io.Closer) error {
+	var batch errorsbp.Batch
+	if consumer != nil {
+		batch.Add(consumer.Shutdown(ctx))
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		var batch errorsbp.Batch
+		for _, p := range producers {
+			batch.Add(p.Close())
+		}
+		closed <- batch.Compile()
+	}()
+	select {
+	case err := <-closed:
+		batch.Add(err)
+	case <-ctx.Done():
+		batch.Add(fmt.Errorf(
+			"kafkabp: CloseAll interrupted before the producers were closed: %w",
+			ctx.Err(),
+		))
+	}
+	return batch.Compile()
+}
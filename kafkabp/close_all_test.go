@@ -0,0 +1,91 @@
+package kafkabp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// closeRecorder records the order the consumer and producers are closed in.
+type closeRecorder struct {
+	lock   sync.Mutex
+	closed []string
+}
+
+func (r *closeRecorder) record(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closed = append(r.closed, name)
+}
+
+type recordingConsumer struct {
+	Consumer
+
+	recorder *closeRecorder
+	err      error
+}
+
+func (c recordingConsumer) Shutdown(context.Context) error {
+	c.recorder.record("consumer")
+	return c.err
+}
+
+type recordingProducer struct {
+	name     string
+	recorder *closeRecorder
+	err      error
+	block    chan struct{}
+}
+
+func (p recordingProducer) Close() error {
+	if p.block != nil {
+		<-p.block
+	}
+	p.recorder.record(p.name)
+	return p.err
+}
+
+func TestCloseAll(t *testing.T) {
+	t.Run("order", func(t *testing.T) {
+		recorder := &closeRecorder{}
+		consumerErr := errors.New("consumer error")
+		producerErr := errors.New("producer error")
+		err := CloseAll(
+			context.Background(),
+			recordingConsumer{recorder: recorder, err: consumerErr},
+			recordingProducer{name: "producer-1", recorder: recorder},
+			recordingProducer{name: "producer-2", recorder: recorder, err: producerErr},
+		)
+		if !errors.Is(err, consumerErr) || !errors.Is(err, producerErr) {
+			t.Errorf("expected errors %v and %v combined, got %v", consumerErr, producerErr, err)
+		}
+		expected := []string{"consumer", "producer-1", "producer-2"}
+		if len(recorder.closed) != len(expected) {
+			t.Fatalf("expected %v closed, got %v", expected, recorder.closed)
+		}
+		for i, name := range expected {
+			if recorder.closed[i] != name {
+				t.Errorf("expected %v closed, got %v", expected, recorder.closed)
+				break
+			}
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		recorder := &closeRecorder{}
+		block := make(chan struct{})
+		defer close(block)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		err := CloseAll(
+			ctx,
+			recordingConsumer{recorder: recorder},
+			recordingProducer{name: "producer", recorder: recorder, block: block},
+		)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected error %v, got %v", context.DeadlineExceeded, err)
+		}
+	})
+}
@@ -1,10 +1,50 @@
 package kafkabp
 
 import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
 	"github.com/Shopify/sarama"
+	metrics "github.com/rcrowley/go-metrics"
+
+	"github.com/reddit/baseplate.go/edgecontext"
 	"github.com/reddit/baseplate.go/log"
+	"github.com/reddit/baseplate.go/randbp"
 )
 
+// DefaultMetricsPrefix is the prefix of the names of the metrics reported by
+// the consumers when ConsumerConfig.MetricsPrefix is empty.
+const DefaultMetricsPrefix = "kafka.consumer"
+
+// Default values of the consumer reset retries, see
+// ConsumerConfig.ResetMaxAttempts and ConsumerConfig.ResetMaxBackoff.
+const (
+	DefaultResetMaxAttempts = 10
+	DefaultResetMaxBackoff  = 30 * time.Second
+)
+
+// DefaultReplayIdleTimeout is the default of ConsumerConfig.ReplayIdleTimeout.
+const DefaultReplayIdleTimeout = 10 * time.Second
+
+// Default values of the metadata request retries, see
+// ConsumerConfig.MetadataRetryMax and ConsumerConfig.MetadataRetryBackoff.
+//
+// Together they tolerate about 5 seconds of broker unavailability when
+// creating the consumers, instead of sarama's defaults of 3 retries 250ms
+// apart.
+const (
+	DefaultMetadataRetryMax     = 10
+	DefaultMetadataRetryBackoff = 500 * time.Millisecond
+)
+
+// DefaultLogger is the logger used by the consumers when
+// ConsumerConfig.Logger is nil, which logs to the global zap logger at warn
+// level.
+var DefaultLogger = log.ZapWrapper(log.WarnLevel)
+
 // ConsumerConfig can be used to configure a kafkabp Consumer.
 //
 // Can be deserialized from YAML.
@@ -18,64 +58,745 @@ import (
 //   topic: sample-topic
 //   clientID: myclient
 //   offset: oldest
+//   payloadCodec: gzip
+//   maxWaitTime: 1s
 type ConsumerConfig struct {
 	// Required. Brokers specifies a slice of broker addresses.
 	Brokers []string `yaml:"brokers"`
 
-	// Required. Topic is used to specify the topic to consume.
+	// Required unless Topics is set. Topic is used to specify the topic to
+	// consume.
 	Topic string `yaml:"topic"`
 
+	// Optional. Topics specifies additional topics to consume with the same
+	// ConsumeMessageFunc and lifecycle, on top of Topic. The messages of all
+	// topics are handled the same way, with their spans and metrics tagged with
+	// the topic they come from.
+	//
+	// The consumer created by NewConsumer consumes every partition of every
+	// topic, and applies the options that take partitions (StartOffsets, Seek,
+	// PausePartition, etc.) to the partition of every topic. When consuming
+	// multiple topics, Seek is not supported, and Offsets and HighWaterMarks
+	// return nil.
+	Topics []string `yaml:"topics"`
+
+	// Optional. Defaults to 1. The minimum number of partitions every topic is
+	// expected to have. NewConsumer returns ErrTooFewPartitions when a topic
+	// has fewer partitions, for example because the topic name is wrong or its
+	// metadata is not ready yet, instead of creating a consumer that silently
+	// consumes nothing.
+	MinPartitions int `yaml:"minPartitions"`
+
 	// Required. ClientID is a user-provided string sent with every request to
 	// the brokers for logging, debugging, and auditing purposes. The default
 	// Consumer implementation in this library expects every Consumer to have a
 	// unique ClientID.
 	//
 	// The Kubernetes pod ID is usually a good candidate for this unique ID.
+	//
+	// Can be left empty when GenerateClientID is true.
 	ClientID string `yaml:"clientID"`
 
+	// Optional. Defaults to false. When true and ClientID is empty, a unique
+	// ClientID is generated with DefaultClientID instead of failing with
+	// ErrClientIDEmpty.
+	GenerateClientID bool `yaml:"generateClientID"`
+
 	// Optional. Defaults to "oldest". Valid values are "oldest" and "newest".
 	Offset string `yaml:"offset"`
 
-	// Optional. If non-nil, will be used to log errors. At present, this only
-	// pertains to logging errors closing the existing consumer when calling
-	// consumer.reset().
+	// Optional. If non-empty, the partitions in it start consuming at the
+	// given offsets instead of Offset, which is useful for replaying and
+	// debugging. The offsets are clamped into the range of the offsets
+	// currently available in each partition.
+	StartOffsets map[int32]int64 `yaml:"startOffsets"`
+
+	// Optional. If non-zero, the partitions not in StartOffsets start
+	// consuming at the first message produced at or after StartTime, or at the
+	// newest offset if there's none.
+	StartTime time.Time `yaml:"startTime"`
+
+	// Optional. Defaults to sarama's default version. The kafka version of the
+	// brokers (e.g. "2.8.0"), which determines the protocol features
+	// available to the consumer. Notably the message headers (used by
+	// PriorityHeader, EdgeContextImpl, and the tracing and idempotency
+	// headers) require at least "0.11.0", and the group consumer requires at
+	// least "0.10.2": it rejects an older Version with ErrVersionTooOld, and
+	// uses "0.10.2" when Version is unset and sarama's default is older.
+	Version string `yaml:"version"`
+
+	// Optional. SASL authentication with the brokers, disabled by default.
+	SASL SASLConfig `yaml:"sasl"`
+
+	// Optional. TLS connections to the brokers, disabled by default.
+	TLS TLSConfig `yaml:"tls"`
+
+	// Optional. Defaults to "none". Valid values are "none", "gzip", and "zstd".
+	//
+	// PayloadCodec is the application layer compression applied to the message
+	// values by the upstream producers. When set, msg.Value is decompressed
+	// before it's passed to the ConsumeMessageFunc. This is different from the
+	// Kafka transport compression, which is handled transparently by sarama.
+	//
+	// The producers can compress the values with EncodePayload. Empty values,
+	// like the tombstones of compacted topics, are passed through unchanged.
+	PayloadCodec string `yaml:"payloadCodec"`
+
+	// Optional. Defaults to 0 (unlimited). The maximum number of messages per
+	// second handled from each partition, applied independently to every
+	// partition so one hot partition can't starve the others.
+	//
+	// When the consumer is shut down, the buffered messages still waiting on
+	// the rate limit are skipped without being handled.
+	PerPartitionRateLimit float64 `yaml:"perPartitionRateLimit"`
+
+	// Optional. Defaults to 0 (unlimited). The maximum number of messages per
+	// second handled across all partitions, with bursts of at most
+	// RateLimitBurst messages (treated as 1 when <=0), on top of
+	// PerPartitionRateLimit. It can be changed at runtime via
+	// Consumer.SetRateLimit, for example to throttle the replay of a backlog
+	// during incident recovery.
+	//
+	// When the consumer is shut down, the buffered messages still waiting on
+	// the rate limit are skipped without being handled.
+	RateLimit      float64 `yaml:"rateLimit"`
+	RateLimitBurst int     `yaml:"rateLimitBurst"`
+
+	// Optional. Defaults to sarama's default (250ms). The maximum time the
+	// broker waits for at least Consumer.Fetch.Min bytes to become available
+	// before returning an empty fetch response. Must be at least 1ms when set.
+	//
+	// Increasing it reduces the request rate against the brokers on mostly idle
+	// topics, at the cost of the latency of the first message after an idle
+	// period.
+	MaxWaitTime time.Duration `yaml:"maxWaitTime"`
+
+	// Optional. Defaults to sarama's defaults (1 byte, 1MB and 0 (unlimited)
+	// respectively). The minimum, default, and maximum number of bytes to fetch
+	// from the brokers in each request, mapped onto sarama's
+	// Consumer.Fetch.Min, Consumer.Fetch.Default, and Consumer.Fetch.Max.
+	//
+	// The ones set must satisfy FetchMin <= FetchDefault <= FetchMax.
+	FetchMin     int32 `yaml:"fetchMin"`
+	FetchDefault int32 `yaml:"fetchDefault"`
+	FetchMax     int32 `yaml:"fetchMax"`
+
+	// Optional. If non-nil, will be used to create the partition consumers
+	// instead of DefaultPartitionConsumerFactory.
+	PartitionConsumerFactory PartitionConsumerFactory `yaml:"-"`
+
+	// Optional. Defaults to false. When true, the consumer panics (after
+	// logging via Logger) if a partition ever delivers a message with an offset
+	// not greater than the previously delivered one.
+	//
+	// This is a debugging aid guarding the per-partition ordering guarantee of
+	// the consumer, and should not be turned on in production.
+	StrictOffsetAssert bool `yaml:"strictOffsetAssert"`
+
+	// Optional. If non-empty, the integer value of this message header is used
+	// as the priority of the message (0 when it's missing or not an integer).
+	// Messages buffered in memory for the same partition are handled highest
+	// priority first, and in offset order among the same priority.
+	//
+	// This can't change the order Kafka delivers the messages, only the local
+	// handling order among the messages already buffered, so it's best-effort.
+	// The committed offset still only advances in offset order.
+	// StrictOffsetAssert is ignored when it's set.
+	PriorityHeader string `yaml:"priorityHeader"`
+
+	// Optional. Defaults to DefaultPriorityBufferSize. The maximum number of
+	// messages per partition buffered for reordering when PriorityHeader is
+	// set.
+	PriorityBufferSize int `yaml:"priorityBufferSize"`
+
+	// Optional. Defaults to 1. The maximum number of messages from the same
+	// partition that are handled concurrently.
+	//
+	// With the default of 1, messages from the same partition are handled
+	// strictly in order. With larger values, messages could be handled out of
+	// order, so it should only be used for idempotent workloads. In either case
+	// the committed offset (used to resume consuming after a rebalance) only
+	// advances past a message after all the messages before it in the same
+	// partition are handled. The handlers wrapped by WithPartitionState are
+	// still called one at a time and in order.
+	MaxConcurrentPerPartition int `yaml:"maxConcurrentPerPartition"`
+
+	// Optional. Defaults to DefaultResetMaxAttempts. The maximum number of
+	// attempts, including the first one, to recreate the consumer after a
+	// rebalance when it fails with transient errors (e.g. the brokers are
+	// unreachable), with exponential backoff and jitter between the attempts.
+	// When all the attempts fail, Consume returns the error, after which
+	// IsHealthy returns false. Set it to 1 to disable the retries.
+	ResetMaxAttempts int `yaml:"resetMaxAttempts"`
+
+	// Optional. Defaults to DefaultResetMaxBackoff. The cap of the backoff
+	// between the attempts to recreate the consumer, see ResetMaxAttempts.
+	ResetMaxBackoff time.Duration `yaml:"resetMaxBackoff"`
+
+	// Optional. Defaults to sarama's defaults (30s and 5). The timeout of
+	// connecting to a broker, and the maximum number of requests sent to a
+	// broker before blocking.
+	DialTimeout     time.Duration `yaml:"dialTimeout"`
+	MaxOpenRequests int           `yaml:"maxOpenRequests"`
+
+	// Optional. Defaults to false. Only used by the consumer created by
+	// NewConsumer. When true, the consumer is a one-shot consumer for
+	// backfills: it captures the high water mark of every partition when
+	// created, and Consume returns nil, after closing the consumer, once the
+	// messages of all the partitions up to it are handled, instead of tailing
+	// the topics forever.
+	//
+	// It's usually combined with Offset "oldest", StartOffsets or StartTime.
+	// The messages produced after the consumer is created are only handled if
+	// they were already fetched when the last partition caught up, see
+	// DrainPolicy.
+	ReplayUntilCaughtUp bool `yaml:"replayUntilCaughtUp"`
+
+	// Optional. Defaults to DefaultReplayIdleTimeout. Only used with
+	// ReplayUntilCaughtUp. A partition is also caught up when its high water
+	// mark reached the captured one, and no message was delivered for this
+	// long while none is being handled. That happens when the last offsets
+	// before the captured high water mark are never delivered, for example the
+	// transaction markers of transactional topics.
+	ReplayIdleTimeout time.Duration `yaml:"replayIdleTimeout"`
+
+	// Optional. Defaults to DefaultMetadataRetryMax and
+	// DefaultMetadataRetryBackoff. The number of times the metadata requests
+	// are retried, and the backoff between the retries, when the brokers are
+	// unavailable or the cluster is in the middle of a leader election, for
+	// example when creating the consumer while the brokers are briefly
+	// unavailable.
+	MetadataRetryMax     int           `yaml:"metadataRetryMax"`
+	MetadataRetryBackoff time.Duration `yaml:"metadataRetryBackoff"`
+
+	// Optional. If non-nil, will be used as the MetricRegistry of the sarama
+	// config, which records sarama's low level broker interaction metrics.
+	// Use RunSaramaMetricsReporter to report them via metricsbp.
+	MetricRegistry metrics.Registry `yaml:"-"`
+
+	// Optional. Defaults to DefaultMetricsPrefix. The prefix of the names of
+	// the metrics reported by the consumer, to tell apart the metrics of
+	// multiple consumers in the same process.
+	MetricsPrefix string `yaml:"metricsPrefix"`
+
+	// Optional. Defaults to false. When true, the "topic" tag is omitted from
+	// the metrics reported by the consumer.
+	DisableMetricsTopicTag bool `yaml:"disableMetricsTopicTag"`
+
+	// Optional. Defaults to 0 (disabled). When positive, the "topic" tag of
+	// the metrics reported by the consumer is the topic name hashed into one of
+	// this many buckets ("bucket-<n>") instead of the topic name itself, to cap
+	// the cardinality of the metrics.
+	MetricsTopicBuckets int `yaml:"metricsTopicBuckets"`
+
+	// Optional. If non-nil, the tags it returns for a message (as key-value
+	// pairs, e.g. []string{"event_type", "click"}) are added to the
+	// messages.processed and messages.failed metrics of that message, for
+	// example to split the throughput by an event type derived from the key or
+	// a header.
+	//
+	// Every distinct tag value creates new time series, so it must only return
+	// values from a small, bounded set. Never return raw keys, IDs or other
+	// unbounded values.
+	MetricsTags func(m *sarama.ConsumerMessage) []string `yaml:"-"`
+
+	// Optional. Defaults to 0 (disabled). When positive, IsHealthy also
+	// returns false when no message was handled successfully within it,
+	// counting from the creation of the consumer.
+	//
+	// Leave it disabled for low-volume topics where long gaps between the
+	// messages are expected.
+	StalenessThreshold time.Duration `yaml:"stalenessThreshold"`
+
+	// Optional. Defaults to 0 (disabled). When positive, a hex preview of up to
+	// this many bytes from the beginning of msg.Value is logged along with the
+	// topic, partition, and offset whenever the ConsumeMessageFunc returns an
+	// error.
+	//
+	// It's disabled by default to avoid logging sensitive data by accident.
+	LogFailedPayloadBytes int `yaml:"logFailedPayloadBytes"`
+
+	// Optional. If non-nil, the edge request context carried in the
+	// HeaderEdgeRequest header of each message is parsed with it and attached
+	// to the context passed to the ConsumeMessageFunc.
+	//
+	// Producers can set that header with AttachEdgeRequestContext.
+	EdgeContextImpl *edgecontext.Impl `yaml:"-"`
+
+	// Optional. If non-nil, messages received after Close or Shutdown is
+	// called are passed to it, as received and without tracing, instead of the
+	// ConsumeMessageFunc, for example to persist them for the next startup.
+	//
+	// Errors returned by it are passed to the ConsumeErrorFunc. Those messages
+	// never advance the committed offset.
+	OnShutdownMessage ConsumeMessageFunc `yaml:"-"`
+
+	// Optional. Defaults to DrainAll. Decides whether the messages already
+	// fetched but not handled yet when Close or Shutdown is called are still
+	// handled, see DrainPolicy for its interaction with the committed offsets.
+	// Ignored by the consumer created by NewConsumer when OnShutdownMessage is
+	// set.
+	//
+	// Shutdown still stops waiting when its context is done, regardless of
+	// DrainPolicy.
+	DrainPolicy DrainPolicy `yaml:"-"`
+
+	// Optional. Only used by the group consumer. If non-nil, OnRebalanceStart
+	// is called with the partitions claimed by the consumer before they are
+	// released, when the group session ends because of a rebalance or Close,
+	// and OnRebalanceEnd is called with the partitions newly claimed after
+	// every rebalance, including the first one when joining the group.
+	//
+	// They are called from the goroutine managing the group session, so they
+	// should return quickly.
+	OnRebalanceStart func(claims map[string][]int32) `yaml:"-"`
+	OnRebalanceEnd   func(event RebalanceEvent)      `yaml:"-"`
+
+	// Optional. Defaults to true. When set to false, messages are handled
+	// without starting a span, and SpanStarter and TraceHeaderKeys are
+	// ignored.
+	Tracing *bool `yaml:"tracing"`
+
+	// Optional. Defaults to true. When true, the errors of the partition
+	// consumers are passed to the ConsumeErrorFunc. They are drained from sarama
+	// by a dedicated goroutine and buffered, so a slow ConsumeErrorFunc never
+	// blocks sarama (the errors overflowing the buffer are dropped and counted
+	// by the kafka.errors.dropped counter instead).
+	//
+	// Setting it to false stops sarama from returning the errors at all, which
+	// saves the overhead for very high volume topics. It comes with risks: the
+	// errors are only logged by sarama.Logger (which discards them by default),
+	// the ConsumeErrorFunc and the kafka.errors counter never see them, and a
+	// partition whose offset goes out of range (e.g. deleted by log retention)
+	// silently stops being consumed instead of being reset to Offset, as that
+	// relies on receiving the error.
+	ReturnErrors *bool `yaml:"returnErrors"`
+
+	// Optional. Defaults to BaseplateSpanStarter. Starts the span every
+	// message is handled within.
+	SpanStarter SpanStarter `yaml:"-"`
+
+	// Optional. Defaults to the baseplate conventions. The names of the
+	// headers the default SpanStarter reads the tracing context from, for
+	// producers following other conventions. See also
+	// AttachTracingHeadersWithKeys.
+	TraceHeaderKeys TraceHeaderKeys `yaml:"traceHeaderKeys"`
+
+	// Optional. Defaults to DefaultLogger. Used to log the errors and warnings
+	// of the consumer, for example errors closing the existing consumer when
+	// the partitions are rebalanced.
 	Logger log.Wrapper `yaml:"-"`
 }
 
 // NewSaramaConfig instantiates a sarama.Config with sane consumer defaults
 // from sarama.NewConfig(), overwritten by values parsed from cfg.
+//
+// All the problems found in cfg are returned together in a *ConfigError.
 func (cfg *ConsumerConfig) NewSaramaConfig() (*sarama.Config, error) {
+	c, errs := cfg.newSaramaConfig()
+	if len(errs) > 0 {
+		return nil, &ConfigError{Errors: errs}
+	}
+	return c, nil
+}
+
+// Validate returns all the problems found in cfg together in a *ConfigError,
+// or nil if cfg is valid.
+//
+// It runs the same checks as NewSaramaConfig, NewConsumer and
+// NewGroupConsumer, without connecting to the brokers, so it can be used to
+// validate configs at startup.
+func (cfg *ConsumerConfig) Validate() error {
+	_, err := cfg.NewSaramaConfig()
+	return err
+}
+
+// newSaramaConfig is NewSaramaConfig returning all the problems found in cfg.
+// The returned sarama.Config must not be used when any is found.
+func (cfg *ConsumerConfig) newSaramaConfig() (*sarama.Config, []error) {
+	var errs []error
+
 	// Validate input parameters.
 	if len(cfg.Brokers) == 0 {
-		return nil, ErrBrokersEmpty
+		errs = append(errs, ErrBrokersEmpty)
+	}
+
+	if len(cfg.topics()) == 0 {
+		errs = append(errs, ErrTopicEmpty)
+	}
+	for _, topic := range cfg.Topics {
+		if topic == "" {
+			errs = append(errs, ErrTopicEmpty)
+			break
+		}
+	}
+
+	clientID := cfg.ClientID
+	if clientID == "" && cfg.GenerateClientID {
+		clientID = DefaultClientID("")
+	}
+	if clientID == "" {
+		errs = append(errs, ErrClientIDEmpty)
+	}
+
+	if cfg.MinPartitions < 0 {
+		errs = append(errs, ErrMinPartitionsInvalid)
+	}
+
+	offset, err := parseOffset(cfg.Offset)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	var version sarama.KafkaVersion
+	if cfg.Version != "" {
+		var err error
+		version, err = sarama.ParseKafkaVersion(cfg.Version)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: %q: %v", ErrVersionInvalid, cfg.Version, err))
+		}
+	}
+
+	for _, offset := range cfg.StartOffsets {
+		if offset < 0 {
+			errs = append(errs, ErrStartOffsetInvalid)
+			break
+		}
+	}
+
+	if err := validatePayloadCodec(cfg.PayloadCodec); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.MaxConcurrentPerPartition < 0 {
+		errs = append(errs, ErrMaxConcurrentPerPartitionInvalid)
+	}
+
+	if cfg.PerPartitionRateLimit < 0 || cfg.RateLimit < 0 || cfg.RateLimitBurst < 0 {
+		errs = append(errs, ErrRateLimitInvalid)
+	}
+
+	if cfg.ResetMaxAttempts < 0 {
+		errs = append(errs, ErrResetMaxAttemptsInvalid)
 	}
 
-	if cfg.Topic == "" {
-		return nil, ErrTopicEmpty
+	if cfg.DialTimeout < 0 || cfg.MaxOpenRequests < 0 || cfg.MetadataRetryMax < 0 || cfg.MetadataRetryBackoff < 0 {
+		errs = append(errs, ErrNetworkConfigInvalid)
 	}
 
-	if cfg.ClientID == "" {
-		return nil, ErrClientIDEmpty
+	if cfg.MaxWaitTime != 0 && cfg.MaxWaitTime < time.Millisecond {
+		errs = append(errs, ErrMaxWaitTimeInvalid)
 	}
 
-	var offset int64
-	switch cfg.Offset {
+	if err := cfg.validateFetchSizes(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.PriorityBufferSize < 0 {
+		errs = append(errs, ErrPriorityBufferSizeInvalid)
+	}
+
+	if cfg.MetricsTopicBuckets < 0 {
+		errs = append(errs, ErrMetricsTopicBucketsInvalid)
+	}
+
+	c := sarama.NewConfig()
+
+	c.ClientID = clientID
+	c.Consumer.Offsets.Initial = offset
+
+	if cfg.Version != "" {
+		c.Version = version
+	}
+
+	if err := cfg.SASL.apply(c); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := cfg.TLS.apply(c); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.DialTimeout != 0 {
+		c.Net.DialTimeout = cfg.DialTimeout
+	}
+	if cfg.MaxOpenRequests != 0 {
+		c.Net.MaxOpenRequests = cfg.MaxOpenRequests
+	}
+
+	c.Metadata.Retry.Max = DefaultMetadataRetryMax
+	if cfg.MetadataRetryMax != 0 {
+		c.Metadata.Retry.Max = cfg.MetadataRetryMax
+	}
+	c.Metadata.Retry.Backoff = DefaultMetadataRetryBackoff
+	if cfg.MetadataRetryBackoff != 0 {
+		c.Metadata.Retry.Backoff = cfg.MetadataRetryBackoff
+	}
+
+	if cfg.MaxWaitTime != 0 {
+		c.Consumer.MaxWaitTime = cfg.MaxWaitTime
+	}
+
+	if cfg.FetchMin != 0 {
+		c.Consumer.Fetch.Min = cfg.FetchMin
+	}
+	if cfg.FetchDefault != 0 {
+		c.Consumer.Fetch.Default = cfg.FetchDefault
+	}
+	if cfg.FetchMax != 0 {
+		c.Consumer.Fetch.Max = cfg.FetchMax
+	}
+
+	if cfg.MetricRegistry != nil {
+		c.MetricRegistry = cfg.MetricRegistry
+	}
+
+	// Return any errors that occurred while consuming on the Errors channel.
+	c.Consumer.Return.Errors = cfg.returnErrors()
+
+	return c, errs
+}
+
+// validateFetchSizes returns ErrFetchSizeInvalid if any fetch size is
+// negative, or the ones set are out of order.
+func (cfg *ConsumerConfig) validateFetchSizes() error {
+	if cfg.FetchMin < 0 || cfg.FetchDefault < 0 || cfg.FetchMax < 0 {
+		return ErrFetchSizeInvalid
+	}
+	// Check every pair of the set sizes, in ascending order.
+	var set []int32
+	for _, size := range []int32{cfg.FetchMin, cfg.FetchDefault, cfg.FetchMax} {
+		if size != 0 {
+			set = append(set, size)
+		}
+	}
+	for i := 1; i < len(set); i++ {
+		if set[i] < set[i-1] {
+			return ErrFetchSizeInvalid
+		}
+	}
+	return nil
+}
+
+// DefaultClientID returns a ClientID unique to the process, composed of prefix
+// (when non-empty), the hostname (which is the pod name on Kubernetes), and a
+// random suffix, separated by "-".
+//
+// It only contains the characters allowed in a ClientID by sarama, as long as
+// prefix does.
+func DefaultClientID(prefix string) string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "unknown"
+	}
+	hostname = invalidClientIDChars.ReplaceAllString(hostname, "_")
+	id := hostname + "-" + strconv.FormatUint(uint64(randbp.R.Uint32()), 16)
+	if prefix != "" {
+		id = prefix + "-" + id
+	}
+	return id
+}
+
+// invalidClientIDChars matches the characters not allowed in a ClientID by
+// sarama.
+var invalidClientIDChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// parseOffset returns the sarama offset (sarama.OffsetOldest or
+// sarama.OffsetNewest) for the Offset value of ConsumerConfig.
+//
+// It's the only place the Offset values are interpreted, and returns an error
+// wrapping ErrOffsetInvalid for values other than OffsetOldest, OffsetNewest
+// and empty.
+func parseOffset(offset string) (int64, error) {
+	switch offset {
 	case "", OffsetOldest:
 		// OffsetOldest is the "true" default case (in that it will be reached if
 		// an offset isn't specified).
-		offset = sarama.OffsetOldest
+		return sarama.OffsetOldest, nil
 	case OffsetNewest:
-		offset = sarama.OffsetNewest
+		return sarama.OffsetNewest, nil
 	default:
-		return nil, ErrOffsetInvalid
+		return 0, fmt.Errorf("%w: %q", ErrOffsetInvalid, offset)
 	}
+}
 
-	c := sarama.NewConfig()
+// tracing returns whether the messages should be handled within a span,
+// according to cfg.Tracing.
+func (cfg ConsumerConfig) tracing() bool {
+	return cfg.Tracing == nil || *cfg.Tracing
+}
 
-	c.Consumer.Offsets.Initial = offset
+// returnErrors returns whether sarama should return the errors while
+// consuming, according to cfg.ReturnErrors.
+func (cfg ConsumerConfig) returnErrors() bool {
+	return cfg.ReturnErrors == nil || *cfg.ReturnErrors
+}
 
-	// Return any errors that occurred while consuming on the Errors channel.
-	c.Consumer.Return.Errors = true
+// topics returns Topic and Topics combined, without duplicates.
+func (cfg ConsumerConfig) topics() []string {
+	topics := make([]string, 0, len(cfg.Topics)+1)
+	seen := make(map[string]bool, len(cfg.Topics)+1)
+	for _, topic := range append([]string{cfg.Topic}, cfg.Topics...) {
+		if topic == "" || seen[topic] {
+			continue
+		}
+		seen[topic] = true
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// GroupConsumerConfig can be used to configure a kafkabp group Consumer
+// created by NewGroupConsumer.
+//
+// Can be deserialized from YAML.
+//
+// Example:
+//
+// kafka:
+//   brokers:
+//     - 127.0.0.1:9090
+//     - 127.0.0.2:9090
+//   topic: sample-topic
+//   clientID: myclient
+//   groupID: mygroup
+//   offset: oldest
+//   assignmentStrategy: sticky
+//   sessionTimeout: 30s
+//   heartbeatInterval: 5s
+//   commitInterval: 500ms
+//
+// The options in ConsumerConfig that apply to individual partition consumers
+// (StartOffsets, StartTime, PartitionConsumerFactory, StrictOffsetAssert,
+// MaxConcurrentPerPartition, PerPartitionRateLimit, PriorityHeader,
+// PriorityBufferSize and OnShutdownMessage) are ignored by the group consumer,
+// and so is MinPartitions.
+type GroupConsumerConfig struct {
+	ConsumerConfig `yaml:",inline"`
+
+	// Required. GroupID is the consumer group to join. The partitions of Topic
+	// are split among all the consumers in the same group.
+	GroupID string `yaml:"groupID"`
+
+	// Optional. Defaults to false, where every message is marked as consumed
+	// after the ConsumeMessageFunc returns nil, the same as with
+	// NewConsumerGroupHandler. When true, only the messages the
+	// ConsumeMessageFunc passes to MarkMessage are marked, so the unmarked
+	// ones are redelivered after a crash or rebalance.
+	//
+	// In either case, marking a message also marks all the messages before it
+	// in the same partition, so a failed message is only redelivered if no
+	// later message of its partition is marked before the crash or rebalance.
+	ManualCommit bool `yaml:"manualCommit"`
+
+	// Optional. Defaults to "range". Valid values are "range", "roundrobin",
+	// and "sticky".
+	//
+	// AssignmentStrategy is how the partitions are assigned to the consumers
+	// in the group. "sticky" preserves the previous assignments as much as
+	// possible, which minimizes the partition movement during rolling deploys.
+	AssignmentStrategy string `yaml:"assignmentStrategy"`
+
+	// Optional. Defaults to sarama's default (10s). The consumer is removed
+	// from the group, and its partitions are reassigned, when the group
+	// coordinator receives no heartbeat from it within SessionTimeout.
+	SessionTimeout time.Duration `yaml:"sessionTimeout"`
+
+	// Optional. Defaults to sarama's default (3s). The interval between the
+	// heartbeats sent to the group coordinator. Must be less than a third of
+	// SessionTimeout.
+	HeartbeatInterval time.Duration `yaml:"heartbeatInterval"`
+
+	// Optional. Defaults to sarama's default (1s). The interval the offsets of
+	// the messages marked as consumed are committed at. Must be positive when
+	// set, unless DisableAutoCommit is true.
+	//
+	// A shorter interval reduces the messages processed again after a crash,
+	// at the cost of more commit requests.
+	CommitInterval time.Duration `yaml:"commitInterval"`
+
+	// Optional. Defaults to false. When true, the offsets of the messages
+	// marked as consumed are not committed periodically, but only when the
+	// group session ends (on rebalances and Close), or when CommitMarked is
+	// called with ManualCommit enabled.
+	DisableAutoCommit bool `yaml:"disableAutoCommit"`
+}
+
+// Validate returns all the problems found in cfg, including the embedded
+// ConsumerConfig, together in a *ConfigError, or nil if cfg is valid.
+//
+// It runs the same checks as NewSaramaConfig and NewGroupConsumer, without
+// connecting to the brokers.
+func (cfg *GroupConsumerConfig) Validate() error {
+	_, err := cfg.NewSaramaConfig()
+	return err
+}
+
+// NewSaramaConfig instantiates a sarama.Config with sane group consumer
+// defaults from ConsumerConfig.NewSaramaConfig, overwritten by values parsed
+// from cfg.
+//
+// All the problems found in cfg, including the embedded ConsumerConfig, are
+// returned together in a *ConfigError.
+func (cfg *GroupConsumerConfig) NewSaramaConfig() (*sarama.Config, error) {
+	c, errs := cfg.ConsumerConfig.newSaramaConfig()
+
+	if cfg.GroupID == "" {
+		errs = append(errs, ErrGroupIDEmpty)
+	}
+
+	switch cfg.AssignmentStrategy {
+	case "", AssignmentStrategyRange:
+		c.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRange
+	case AssignmentStrategyRoundRobin:
+		c.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	case AssignmentStrategySticky:
+		c.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategySticky
+	default:
+		errs = append(errs, ErrAssignmentStrategyInvalid)
+	}
+
+	if cfg.SessionTimeout != 0 {
+		c.Consumer.Group.Session.Timeout = cfg.SessionTimeout
+	}
+	if cfg.HeartbeatInterval != 0 {
+		c.Consumer.Group.Heartbeat.Interval = cfg.HeartbeatInterval
+	}
+	if c.Consumer.Group.Heartbeat.Interval*3 >= c.Consumer.Group.Session.Timeout {
+		errs = append(errs, fmt.Errorf(
+			"%w: got HeartbeatInterval %v and SessionTimeout %v",
+			ErrHeartbeatIntervalInvalid,
+			c.Consumer.Group.Heartbeat.Interval,
+			c.Consumer.Group.Session.Timeout,
+		))
+	}
+
+	if cfg.DisableAutoCommit {
+		c.Consumer.Offsets.AutoCommit.Enable = false
+	} else if cfg.CommitInterval < 0 {
+		errs = append(errs, ErrCommitIntervalInvalid)
+	} else if cfg.CommitInterval != 0 {
+		c.Consumer.Offsets.AutoCommit.Interval = cfg.CommitInterval
+	}
+
+	// Consumer groups require at least kafka 0.10.2. An explicit Version is
+	// never raised silently, as it should match the brokers.
+	if cfg.Version != "" {
+		if version, err := sarama.ParseKafkaVersion(cfg.Version); err == nil && !version.IsAtLeast(sarama.V0_10_2_0) {
+			errs = append(errs, fmt.Errorf(
+				"%w: got %q, expected at least %q",
+				ErrVersionTooOld,
+				cfg.Version,
+				sarama.V0_10_2_0.String(),
+			))
+		}
+	} else if !c.Version.IsAtLeast(sarama.V0_10_2_0) {
+		c.Version = sarama.V0_10_2_0
+	}
+
+	if len(errs) > 0 {
+		return nil, &ConfigError{Errors: errs}
+	}
 
 	return c, nil
 }
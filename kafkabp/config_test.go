@@ -2,7 +2,12 @@ package kafkabp
 
 import (
 	"errors"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
 )
 
 func TestConfig(t *testing.T) {
@@ -29,9 +34,21 @@ func TestConfig(t *testing.T) {
 		t.Errorf("expected error %v, got %v", ErrTopicEmpty, err)
 	}
 
+	// Config with an empty topic in Topics should not create a new consumer
+	// and throw ErrTopicEmpty
+	cfg.Topics = []string{"test-topic", ""}
+	sc, err = cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrTopicEmpty) {
+		t.Errorf("expected error %v, got %v", ErrTopicEmpty, err)
+	}
+
 	// Config with no ClientID should not create a new consumer and throw
 	// ErrClientIDEmpty
 	cfg.Topic = "test-topic"
+	cfg.Topics = nil
 	sc, err = cfg.NewSaramaConfig()
 	if sc != nil {
 		t.Errorf("expected config to be nil, got %v", sc)
@@ -40,9 +57,21 @@ func TestConfig(t *testing.T) {
 		t.Errorf("expected error %v, got %v", ErrClientIDEmpty, err)
 	}
 
+	// Config with negative MinPartitions should not create a new consumer and
+	// throw ErrMinPartitionsInvalid
+	cfg.ClientID = "i am unique"
+	cfg.MinPartitions = -1
+	sc, err = cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrMinPartitionsInvalid) {
+		t.Errorf("expected error %v, got %v", ErrMinPartitionsInvalid, err)
+	}
+
 	// Config with invalid Offset should not create a new consumer and throw
 	// ErrOffsetInvalid
-	cfg.ClientID = "i am unique"
+	cfg.MinPartitions = 0
 	cfg.Offset = "fanciest"
 	sc, err = cfg.NewSaramaConfig()
 	if sc != nil {
@@ -51,4 +80,400 @@ func TestConfig(t *testing.T) {
 	if !errors.Is(err, ErrOffsetInvalid) {
 		t.Errorf("expected error %v, got %v", ErrOffsetInvalid, err)
 	}
+
+	// Config with invalid PayloadCodec should not create a new consumer and
+	// throw ErrPayloadCodecInvalid
+	cfg.Offset = OffsetNewest
+	cfg.PayloadCodec = "fanciest"
+	sc, err = cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrPayloadCodecInvalid) {
+		t.Errorf("expected error %v, got %v", ErrPayloadCodecInvalid, err)
+	}
+
+	// Config with negative MaxConcurrentPerPartition should not create a new
+	// consumer and throw ErrMaxConcurrentPerPartitionInvalid
+	cfg.PayloadCodec = PayloadCodecGzip
+	cfg.MaxConcurrentPerPartition = -1
+	sc, err = cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrMaxConcurrentPerPartitionInvalid) {
+		t.Errorf("expected error %v, got %v", ErrMaxConcurrentPerPartitionInvalid, err)
+	}
+
+	// Config with negative PerPartitionRateLimit should not create a new
+	// consumer and throw ErrRateLimitInvalid
+	cfg.MaxConcurrentPerPartition = 0
+	cfg.PerPartitionRateLimit = -1
+	sc, err = cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrRateLimitInvalid) {
+		t.Errorf("expected error %v, got %v", ErrRateLimitInvalid, err)
+	}
+
+	// Config with negative RateLimit should not create a new consumer and
+	// throw ErrRateLimitInvalid
+	cfg.PerPartitionRateLimit = 0
+	cfg.RateLimit = -1
+	sc, err = cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrRateLimitInvalid) {
+		t.Errorf("expected error %v, got %v", ErrRateLimitInvalid, err)
+	}
+
+	// Config with MaxWaitTime less than 1ms should not create a new consumer
+	// and throw ErrMaxWaitTimeInvalid
+	cfg.RateLimit = 0
+	cfg.MaxWaitTime = time.Microsecond
+	sc, err = cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrMaxWaitTimeInvalid) {
+		t.Errorf("expected error %v, got %v", ErrMaxWaitTimeInvalid, err)
+	}
+
+	// Valid config should map MaxWaitTime onto the sarama config
+	cfg.MaxWaitTime = time.Second
+	sc, err = cfg.NewSaramaConfig()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sc.Consumer.MaxWaitTime != cfg.MaxWaitTime {
+		t.Errorf("expected MaxWaitTime %v, got %v", cfg.MaxWaitTime, sc.Consumer.MaxWaitTime)
+	}
+
+	// Valid config without the network options should use the default metadata
+	// retries
+	if sc.Metadata.Retry.Max != DefaultMetadataRetryMax {
+		t.Errorf("expected Metadata.Retry.Max %d, got %d", DefaultMetadataRetryMax, sc.Metadata.Retry.Max)
+	}
+	if sc.Metadata.Retry.Backoff != DefaultMetadataRetryBackoff {
+		t.Errorf("expected Metadata.Retry.Backoff %v, got %v", DefaultMetadataRetryBackoff, sc.Metadata.Retry.Backoff)
+	}
+
+	// Config with negative DialTimeout should not create a new consumer and
+	// throw ErrNetworkConfigInvalid
+	cfg.DialTimeout = -time.Second
+	sc, err = cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrNetworkConfigInvalid) {
+		t.Errorf("expected error %v, got %v", ErrNetworkConfigInvalid, err)
+	}
+
+	// Valid config should map the network options onto the sarama config
+	cfg.DialTimeout = 5 * time.Second
+	cfg.MaxOpenRequests = 1
+	cfg.MetadataRetryMax = 20
+	cfg.MetadataRetryBackoff = time.Second
+	sc, err = cfg.NewSaramaConfig()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sc.Net.DialTimeout != cfg.DialTimeout {
+		t.Errorf("expected Net.DialTimeout %v, got %v", cfg.DialTimeout, sc.Net.DialTimeout)
+	}
+	if sc.Net.MaxOpenRequests != cfg.MaxOpenRequests {
+		t.Errorf("expected Net.MaxOpenRequests %d, got %d", cfg.MaxOpenRequests, sc.Net.MaxOpenRequests)
+	}
+	if sc.Metadata.Retry.Max != cfg.MetadataRetryMax {
+		t.Errorf("expected Metadata.Retry.Max %d, got %d", cfg.MetadataRetryMax, sc.Metadata.Retry.Max)
+	}
+	if sc.Metadata.Retry.Backoff != cfg.MetadataRetryBackoff {
+		t.Errorf("expected Metadata.Retry.Backoff %v, got %v", cfg.MetadataRetryBackoff, sc.Metadata.Retry.Backoff)
+	}
+
+	// Config with negative PriorityBufferSize should not create a new consumer
+	// and throw ErrPriorityBufferSizeInvalid
+	cfg.PriorityBufferSize = -1
+	sc, err = cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrPriorityBufferSizeInvalid) {
+		t.Errorf("expected error %v, got %v", ErrPriorityBufferSizeInvalid, err)
+	}
+
+	// Config with negative MetricsTopicBuckets should not create a new consumer
+	// and throw ErrMetricsTopicBucketsInvalid
+	cfg.PriorityBufferSize = 0
+	cfg.MetricsTopicBuckets = -1
+	sc, err = cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrMetricsTopicBucketsInvalid) {
+		t.Errorf("expected error %v, got %v", ErrMetricsTopicBucketsInvalid, err)
+	}
+
+	// Config with negative StartOffsets should not create a new consumer and
+	// throw ErrStartOffsetInvalid
+	cfg.MetricsTopicBuckets = 0
+	cfg.StartOffsets = map[int32]int64{0: -1}
+	sc, err = cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrStartOffsetInvalid) {
+		t.Errorf("expected error %v, got %v", ErrStartOffsetInvalid, err)
+	}
+
+	// Config with out of order fetch sizes should not create a new consumer
+	// and throw ErrFetchSizeInvalid
+	cfg.StartOffsets = nil
+	cfg.FetchMin = 1024
+	cfg.FetchMax = 512
+	sc, err = cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrFetchSizeInvalid) {
+		t.Errorf("expected error %v, got %v", ErrFetchSizeInvalid, err)
+	}
+
+	// Valid config should map the fetch sizes onto the sarama config
+	cfg.FetchDefault = 4096
+	cfg.FetchMax = 8192
+	sc, err = cfg.NewSaramaConfig()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sc.Consumer.Fetch.Min != cfg.FetchMin ||
+		sc.Consumer.Fetch.Default != cfg.FetchDefault ||
+		sc.Consumer.Fetch.Max != cfg.FetchMax {
+		t.Errorf(
+			"expected fetch sizes %d/%d/%d, got %d/%d/%d",
+			cfg.FetchMin,
+			cfg.FetchDefault,
+			cfg.FetchMax,
+			sc.Consumer.Fetch.Min,
+			sc.Consumer.Fetch.Default,
+			sc.Consumer.Fetch.Max,
+		)
+	}
+
+	// Config with invalid Version should not create a new consumer and throw
+	// ErrVersionInvalid
+	cfg.Version = "foo"
+	sc, err = cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrVersionInvalid) {
+		t.Errorf("expected error %v, got %v", ErrVersionInvalid, err)
+	}
+
+	// Valid config should map Version onto the sarama config
+	cfg.Version = "2.6.0"
+	sc, err = cfg.NewSaramaConfig()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sc.Version != sarama.V2_6_0_0 {
+		t.Errorf("expected version %v, got %v", sarama.V2_6_0_0, sc.Version)
+	}
+}
+
+func TestConfigTopics(t *testing.T) {
+	cfg := ConsumerConfig{
+		Topic:  "topic-1",
+		Topics: []string{"topic-2", "topic-1", "topic-3"},
+	}
+	topics := cfg.topics()
+	if len(topics) != 3 || topics[0] != "topic-1" || topics[1] != "topic-2" || topics[2] != "topic-3" {
+		t.Errorf("expected topics [topic-1 topic-2 topic-3], got %v", topics)
+	}
+
+	cfg.Topic = ""
+	if topics := cfg.topics(); len(topics) != 3 || topics[0] != "topic-2" {
+		t.Errorf("expected topics [topic-2 topic-1 topic-3], got %v", topics)
+	}
+}
+
+func TestConfigOffset(t *testing.T) {
+	for _, c := range []struct {
+		offset   string
+		expected int64
+		err      error
+	}{
+		{offset: OffsetOldest, expected: sarama.OffsetOldest},
+		{offset: OffsetNewest, expected: sarama.OffsetNewest},
+		{offset: "", expected: sarama.OffsetOldest},
+		{offset: "fanciest", err: ErrOffsetInvalid},
+	} {
+		t.Run(c.offset, func(t *testing.T) {
+			cfg := ConsumerConfig{
+				Brokers:  []string{"127.0.0.1:9090"},
+				Topic:    "test-topic",
+				ClientID: "i am unique",
+				Offset:   c.offset,
+			}
+			sc, err := cfg.NewSaramaConfig()
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Errorf("expected error %v, got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if sc.Consumer.Offsets.Initial != c.expected {
+				t.Errorf("expected initial offset %d, got %d", c.expected, sc.Consumer.Offsets.Initial)
+			}
+		})
+	}
+}
+
+func TestConfigError(t *testing.T) {
+	cfg := GroupConsumerConfig{
+		ConsumerConfig: ConsumerConfig{
+			Brokers:             []string{"127.0.0.1:9090"},
+			Offset:              "fanciest",
+			MetricsTopicBuckets: -1,
+		},
+	}
+	sc, err := cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		t.Fatalf("expected a *ConfigError, got %v", err)
+	}
+	expected := []error{
+		ErrTopicEmpty,
+		ErrClientIDEmpty,
+		ErrOffsetInvalid,
+		ErrMetricsTopicBucketsInvalid,
+		ErrGroupIDEmpty,
+	}
+	if len(configErr.Errors) != len(expected) {
+		t.Errorf("expected %d errors, got %v", len(expected), configErr.Errors)
+	}
+	for _, target := range expected {
+		if !errors.Is(err, target) {
+			t.Errorf("expected error %v to be included, got %v", target, err)
+		}
+	}
+	if errors.Is(err, ErrBrokersEmpty) {
+		t.Errorf("expected error %v to not be included, got %v", ErrBrokersEmpty, err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := GroupConsumerConfig{
+		ConsumerConfig: ConsumerConfig{
+			Brokers:  []string{"127.0.0.1:9090"},
+			Topic:    "test-topic",
+			ClientID: "i am unique",
+		},
+		GroupID: "test-group",
+	}
+	if err := cfg.ConsumerConfig.Validate(); err != nil {
+		t.Errorf("expected consumer config to be valid, got %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected group consumer config to be valid, got %v", err)
+	}
+
+	cfg.GroupID = ""
+	if err := cfg.ConsumerConfig.Validate(); err != nil {
+		t.Errorf("expected consumer config to be valid, got %v", err)
+	}
+	var configErr *ConfigError
+	if err := cfg.Validate(); !errors.As(err, &configErr) || !errors.Is(err, ErrGroupIDEmpty) {
+		t.Errorf("expected a *ConfigError with %v, got %v", ErrGroupIDEmpty, err)
+	}
+
+	cfg.ClientID = ""
+	if err := cfg.ConsumerConfig.Validate(); !errors.Is(err, ErrClientIDEmpty) {
+		t.Errorf("expected error %v, got %v", ErrClientIDEmpty, err)
+	}
+}
+
+func TestDefaultClientID(t *testing.T) {
+	validID := regexp.MustCompile(`\A[A-Za-z0-9._-]+\z`)
+
+	id := DefaultClientID("my-service")
+	if !strings.HasPrefix(id, "my-service-") {
+		t.Errorf("expected client id prefixed with %q, got %q", "my-service-", id)
+	}
+	if !validID.MatchString(id) {
+		t.Errorf("expected a valid client id, got %q", id)
+	}
+	if other := DefaultClientID("my-service"); other == id {
+		t.Errorf("expected unique client ids, got %q twice", id)
+	}
+	if id := DefaultClientID(""); strings.HasPrefix(id, "-") || !validID.MatchString(id) {
+		t.Errorf("expected a valid client id without prefix, got %q", id)
+	}
+}
+
+func TestConfigGenerateClientID(t *testing.T) {
+	cfg := ConsumerConfig{
+		Brokers: []string{"127.0.0.1:9090"},
+		Topic:   "test-topic",
+	}
+	if _, err := cfg.NewSaramaConfig(); !errors.Is(err, ErrClientIDEmpty) {
+		t.Errorf("expected error %v, got %v", ErrClientIDEmpty, err)
+	}
+
+	cfg.GenerateClientID = true
+	sc, err := cfg.NewSaramaConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sc.ClientID == "" || sc.ClientID == sarama.NewConfig().ClientID {
+		t.Errorf("expected a generated client id, got %q", sc.ClientID)
+	}
+
+	cfg.ClientID = "explicit-id"
+	sc, err = cfg.NewSaramaConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sc.ClientID != cfg.ClientID {
+		t.Errorf("expected client id %q, got %q", cfg.ClientID, sc.ClientID)
+	}
+}
+
+func TestConfigReturnErrors(t *testing.T) {
+	enabled, disabled := true, false
+	for _, c := range []struct {
+		label        string
+		returnErrors *bool
+		expected     bool
+	}{
+		{label: "default", returnErrors: nil, expected: true},
+		{label: "true", returnErrors: &enabled, expected: true},
+		{label: "false", returnErrors: &disabled, expected: false},
+	} {
+		t.Run(c.label, func(t *testing.T) {
+			cfg := ConsumerConfig{
+				Brokers:      []string{"127.0.0.1:9090"},
+				Topic:        "test-topic",
+				ClientID:     "test-client",
+				ReturnErrors: c.returnErrors,
+			}
+			sc, err := cfg.NewSaramaConfig()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if sc.Consumer.Return.Errors != c.expected {
+				t.Errorf("expected Consumer.Return.Errors %v, got %v", c.expected, sc.Consumer.Return.Errors)
+			}
+		})
+	}
 }
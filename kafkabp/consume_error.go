@@ -0,0 +1,74 @@
+package kafkabp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/Shopify/sarama"
+)
+
+// ConsumeError is the error passed to the ConsumeErrorFunc for the errors
+// returned by the partition consumers.
+type ConsumeError struct {
+	Topic     string
+	Partition int32
+
+	// The underlying error.
+	Err error
+
+	// Retriable is true if the error is transient, and the partition
+	// consumers recover from it on their own.
+	Retriable bool
+}
+
+func (e ConsumeError) Error() string {
+	return fmt.Sprintf(
+		"kafkabp: error consuming topic %q partition %d: %v",
+		e.Topic,
+		e.Partition,
+		e.Err,
+	)
+}
+
+// Unwrap returns the underlying error.
+func (e ConsumeError) Unwrap() error {
+	return e.Err
+}
+
+// newConsumeError classifies the error returned by a partition consumer.
+func newConsumeError(err *sarama.ConsumerError) ConsumeError {
+	return ConsumeError{
+		Topic:     err.Topic,
+		Partition: err.Partition,
+		Err:       err.Err,
+		Retriable: isRetriable(err.Err),
+	}
+}
+
+// retriableKErrors are the kafka errors sarama's partition consumers retry or
+// redispatch on their own.
+var retriableKErrors = map[sarama.KError]bool{
+	sarama.ErrUnknownTopicOrPartition: true,
+	sarama.ErrLeaderNotAvailable:      true,
+	sarama.ErrNotLeaderForPartition:   true,
+	sarama.ErrRequestTimedOut:         true,
+	sarama.ErrReplicaNotAvailable:     true,
+	sarama.ErrNetworkException:        true,
+	sarama.ErrNotEnoughReplicas:       true,
+	sarama.ErrKafkaStorageError:       true,
+	sarama.ErrFencedLeaderEpoch:       true,
+	sarama.ErrUnknownLeaderEpoch:      true,
+}
+
+func isRetriable(err error) bool {
+	var kerr sarama.KError
+	if errors.As(err, &kerr) {
+		return retriableKErrors[kerr]
+	}
+	var netErr net.Error
+	return errors.Is(err, sarama.ErrOutOfBrokers) ||
+		errors.Is(err, sarama.ErrNotConnected) ||
+		errors.Is(err, ErrTooFewPartitions) ||
+		errors.As(err, &netErr)
+}
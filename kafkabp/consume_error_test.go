@@ -0,0 +1,51 @@
+package kafkabp
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestConsumeErrorRetriable(t *testing.T) {
+	for _, c := range []struct {
+		err       error
+		retriable bool
+	}{
+		{
+			err:       sarama.ErrNotLeaderForPartition,
+			retriable: true,
+		},
+		{
+			err:       fmt.Errorf("wrapped: %w", sarama.ErrRequestTimedOut),
+			retriable: true,
+		},
+		{
+			err:       sarama.ErrOutOfBrokers,
+			retriable: true,
+		},
+		{
+			err:       sarama.ErrTopicAuthorizationFailed,
+			retriable: false,
+		},
+		{
+			err:       errors.New("foo"),
+			retriable: false,
+		},
+	} {
+		t.Run(c.err.Error(), func(t *testing.T) {
+			err := newConsumeError(&sarama.ConsumerError{
+				Topic:     "topic",
+				Partition: 1,
+				Err:       c.err,
+			})
+			if err.Retriable != c.retriable {
+				t.Errorf("expected Retriable %v, got %v", c.retriable, err.Retriable)
+			}
+			if !errors.Is(err, c.err) {
+				t.Errorf("expected %v to wrap %v", err, c.err)
+			}
+		})
+	}
+}
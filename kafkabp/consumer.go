@@ -2,34 +2,156 @@ package kafkabp
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"io"
+	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/Shopify/sarama"
 
 	"github.com/reddit/baseplate.go/metricsbp"
-	"github.com/reddit/baseplate.go/tracing"
 )
 
 // ConsumeMessageFunc is a function type for consuming consumer messages.
 type ConsumeMessageFunc func(ctx context.Context, msg *sarama.ConsumerMessage) error
 
 // ConsumeErrorFunc is a function type for consuming consumer errors.
+//
+// The errors returned by the partition consumers are passed as ConsumeError.
 type ConsumeErrorFunc func(err error)
 
+// ConsumeErrorContextFunc is a ConsumeErrorFunc that also receives a context,
+// used by Consumer.ConsumeWithErrorContext.
+//
+// For the errors of a topic and partition, ctx carries them (see
+// TopicPartitionFromContext). For the errors handling a message, ctx is the
+// one the message is handled with, including its span when tracing is
+// enabled.
+type ConsumeErrorContextFunc func(ctx context.Context, err error)
+
+// IgnoreErrorContext adapts a ConsumeErrorFunc into a ConsumeErrorContextFunc
+// ignoring the context.
+func IgnoreErrorContext(fn ConsumeErrorFunc) ConsumeErrorContextFunc {
+	return func(_ context.Context, err error) {
+		fn(err)
+	}
+}
+
+type topicPartitionKey struct{}
+
+// withTopicPartition returns ctx carrying topic and partition, see
+// TopicPartitionFromContext.
+func withTopicPartition(ctx context.Context, topic string, partition int32) context.Context {
+	return context.WithValue(ctx, topicPartitionKey{}, topicPartition{
+		topic:     topic,
+		partition: partition,
+	})
+}
+
+// TopicPartitionFromContext returns the topic and partition of the message or
+// error the context passed to a ConsumeMessageFunc or ConsumeErrorContextFunc
+// by the consumers belongs to.
+//
+// ok is false when ctx carries none, for example for the errors of the whole
+// consumer group.
+func TopicPartitionFromContext(ctx context.Context) (topic string, partition int32, ok bool) {
+	tp, ok := ctx.Value(topicPartitionKey{}).(topicPartition)
+	return tp.topic, tp.partition, ok
+}
+
+// PartitionConsumerFactory is a function type for creating the
+// sarama.PartitionConsumer used to consume a single partition of a topic.
+//
+// It's a seam mainly meant for tests (e.g. to inject failures into specific
+// partitions), the default implementation just calls
+// consumer.ConsumePartition.
+type PartitionConsumerFactory func(
+	consumer sarama.Consumer,
+	topic string,
+	partition int32,
+	offset int64,
+) (sarama.PartitionConsumer, error)
+
+// DefaultPartitionConsumerFactory is the PartitionConsumerFactory used when
+// ConsumerConfig.PartitionConsumerFactory is nil.
+func DefaultPartitionConsumerFactory(
+	consumer sarama.Consumer,
+	topic string,
+	partition int32,
+	offset int64,
+) (sarama.PartitionConsumer, error) {
+	return consumer.ConsumePartition(topic, partition, offset)
+}
+
 // consumer is an instance of a Kafka consumer.
 type consumer struct {
 	cfg ConsumerConfig
 	sc  *sarama.Config
+	// The client shared with other consumers, see NewConsumerFromClient. nil
+	// when the consumer creates its own clients. It's never closed by the
+	// consumer.
+	client sarama.Client
+
+	consumer   atomic.Value // sarama.Consumer
+	partitions atomic.Value // []int32
 
-	consumer           atomic.Value // sarama.Consumer
-	partitions         atomic.Value // []int32
-	partitionConsumers atomic.Value // []sarama.PartitionConsumer
+	// Serializes closing the stored sarama consumer with replacing it, and
+	// whether the stored one is already closed, see closeConsumer.
+	consumerLock   sync.Mutex
+	consumerClosed bool
 
 	closed          int64
 	consumeReturned int64
 	offset          int64
+	// The time Close or Shutdown was called, in nanoseconds since EPOCH.
+	shutdownAt int64
+	// The time the last message was handled successfully, in nanoseconds
+	// since EPOCH.
+	lastMessage int64
+	// The number of partitions assigned, and whether it was reported before,
+	// see reportPartitions.
+	assignedPartitions int64
+	partitionsReported int64
+
+	// Used as the last message time by HealthyWithin before any message is
+	// handled.
+	created time.Time
+
+	partitionsLock sync.Mutex
+	// The committed (or initially the resolved start) offset of each partition,
+	// used as the starting offset when the partition consumers are created.
+	offsets map[int32]int64
+	// The current partition consumer of each partition.
+	partitionStates map[int32]*partitionState
+	// The high water mark of each partition, only used by the group consumer,
+	// where there's no partition consumer to get it from.
+	highWaterMarks map[int32]int64
+	// The offset each partition not caught up yet is consumed up to, only set
+	// when cfg.ReplayUntilCaughtUp is, see trackReplayLocked.
+	replayTargets map[int32]int64
+
+	// The result of the first Shutdown call.
+	shutdownResult firstResult
+
+	// Canceled when the consumer is shut down, see lifecycle.
+	lifecycleOnce   sync.Once
+	lifecycleCtx    context.Context
+	lifecycleCancel context.CancelFunc
+
+	// Gates message handling while paused.
+	pauser pauser
+	// The rate limit across all partitions, shared by the consumers of all
+	// topics.
+	limiter *rateLimiter
+
+	// Used to create the sarama consumer on every reset, sarama.NewConsumer (or
+	// sarama.NewConsumerFromClient with client) if nil. Only overridden in
+	// tests.
+	newSaramaConsumer func(addrs []string, config *sarama.Config) (sarama.Consumer, error)
 
 	wg sync.WaitGroup
 }
@@ -40,99 +162,512 @@ type Consumer interface {
 
 	Consume(ConsumeMessageFunc, ConsumeErrorFunc) error
 
-	// IsHealthy returns false after Consume returns.
+	// ConsumeContext is like Consume, but also closes the consumer and
+	// returns when ctx is done.
+	ConsumeContext(context.Context, ConsumeMessageFunc, ConsumeErrorFunc) error
+
+	// ConsumeWithErrorContext is like ConsumeContext, but the errors are
+	// passed to a ConsumeErrorContextFunc along with their context.
+	ConsumeWithErrorContext(context.Context, ConsumeMessageFunc, ConsumeErrorContextFunc) error
+
+	// Seek repositions a partition to offset at runtime, by recreating the
+	// partition consumer of that partition.
+	Seek(partition int32, offset int64) error
+
+	// Shutdown stops consuming, waits for in-flight messages to be handled
+	// until ctx is done, then closes the consumer.
+	//
+	// Calling it (or Close) again returns the result of the first call.
+	Shutdown(ctx context.Context) error
+
+	// CloseWithTimeout is Shutdown with a context timing out after timeout.
+	CloseWithTimeout(timeout time.Duration) error
+
+	// SetRateLimit changes the maximum number of messages per second handled
+	// across all partitions, and its burst, at runtime. See
+	// ConsumerConfig.RateLimit.
+	SetRateLimit(rate float64, burst int)
+
+	// Pause stops handling messages from all partitions until Resume is
+	// called, without giving up the partitions.
+	Pause()
+
+	// Resume resumes handling messages from all partitions, including the ones
+	// paused by PausePartition.
+	Resume()
+
+	// PausePartition stops handling messages from partition until
+	// ResumePartition or Resume is called.
+	PausePartition(partition int32)
+
+	// ResumePartition resumes handling messages from partition, unless the
+	// whole consumer is paused.
+	ResumePartition(partition int32)
+
+	// IsPaused returns true if the consumer or any of its partitions is paused.
+	IsPaused() bool
+
+	// Offsets returns the next offset to be consumed of each partition
+	// consumed, which are negative (sarama.OffsetOldest or
+	// sarama.OffsetNewest) for the partitions no message was handled from yet.
+	Offsets() map[int32]int64
+
+	// HighWaterMarks returns the offset of the next message to be produced to
+	// each partition consumed, as last reported by the brokers. The lag of a
+	// partition is its high water mark minus its offset from Offsets.
+	HighWaterMarks() map[int32]int64
+
+	// IsHealthy returns false after Consume returns, or when no message was
+	// handled successfully within ConsumerConfig.StalenessThreshold.
 	IsHealthy() bool
+
+	// LastMessageTime returns the time the last message was handled
+	// successfully, or zero time if none was.
+	LastMessageTime() time.Time
+
+	// HealthyWithin returns false if no message was handled successfully
+	// within d, counting from the creation of the consumer.
+	HealthyWithin(d time.Duration) bool
 }
 
-// NewConsumer creates a new Kafka consumer. Unlike a group consumer (which
-// delivers every message exactly once by having one ClientID assigned to every
-// consumer in the group), this consumer is used for consuming some
+// NewConsumer creates a new Kafka consumer. Unlike a group consumer (see
+// NewGroupConsumer, which delivers every message exactly once by splitting the
+// partitions among every consumer in the group), this consumer is used for consuming some
 // configuration or data by all running consumer instances. This is why the
 // ClientID provided to NewConsumer's ConsumerConfig must be unique.
+//
+// When cfg specifies multiple topics (see ConsumerConfig.Topics), a consumer is
+// created for every topic, and they are all consumed and closed together.
 func NewConsumer(cfg ConsumerConfig) (Consumer, error) {
 	sc, err := cfg.NewSaramaConfig()
 	if err != nil {
 		return nil, err
 	}
+	return newConsumer(cfg, sc, nil)
+}
+
+// NewConsumerFromClient creates a new Kafka consumer like NewConsumer, but
+// using client to connect to the brokers instead of opening its own
+// connections, so a single client can be shared by multiple consumers (and
+// the producers created with sarama.NewSyncProducerFromClient or
+// sarama.NewAsyncProducerFromClient).
+//
+// The caller keeps the ownership of client: closing the consumer doesn't close
+// client, which must be closed by the caller after all the consumers and
+// producers using it are closed.
+//
+// cfg is still validated the same way as in NewConsumer, and its options
+// handled by kafkabp (e.g. Offset, StartOffsets, MaxConcurrentPerPartition)
+// apply as usual. But sarama uses client.Config() instead of the sarama.Config
+// derived from cfg, so the options mapped onto the sarama.Config (e.g. the
+// fetch sizes, timeouts, TLS, SASL and ReturnErrors) only apply if client was
+// created with them, for example:
+//
+//     sc, err := cfg.NewSaramaConfig()
+//     // handle err
+//     client, err := sarama.NewClient(cfg.Brokers, sc)
+//     // handle err
+//     consumer, err := kafkabp.NewConsumerFromClient(client, cfg)
+//
+// Group consumers can't be created from a shared client, as sarama's consumer
+// groups can't share clients.
+func NewConsumerFromClient(client sarama.Client, cfg ConsumerConfig) (Consumer, error) {
+	sc, err := cfg.NewSaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	if client.Closed() {
+		return nil, sarama.ErrClosedClient
+	}
+	return newConsumer(cfg, sc, client)
+}
+
+// newConsumer creates the consumer of every topic in cfg, using client when
+// it's non-nil.
+func newConsumer(cfg ConsumerConfig, sc *sarama.Config, client sarama.Client) (Consumer, error) {
+	if cfg.Logger == nil {
+		cfg.Logger = DefaultLogger
+	}
+
+	limiter := newRateLimiter(cfg.RateLimit, cfg.RateLimitBurst)
+	topics := cfg.topics()
+	if len(topics) == 1 {
+		return newTopicConsumer(cfg, topics[0], sc, client, limiter)
+	}
+
+	mc := &multiTopicConsumer{
+		consumers: make([]*consumer, 0, len(topics)),
+	}
+	for _, topic := range topics {
+		kc, err := newTopicConsumer(cfg, topic, sc, client, limiter)
+		if err != nil {
+			if closeErr := mc.Close(); closeErr != nil {
+				cfg.Logger.Log(context.Background(), "kafkabp.NewConsumer: Error closing the consumers:"+closeErr.Error())
+			}
+			return nil, err
+		}
+		mc.consumers = append(mc.consumers, kc)
+	}
+	return mc, nil
+}
 
+// newTopicConsumer creates the consumer of a single topic.
+func newTopicConsumer(cfg ConsumerConfig, topic string, sc *sarama.Config, client sarama.Client, limiter *rateLimiter) (*consumer, error) {
+	cfg.Topic = topic
+	cfg.Topics = nil
 	kc := &consumer{
-		cfg:    cfg,
-		sc:     sc,
-		offset: sc.Consumer.Offsets.Initial,
+		cfg:     cfg,
+		sc:      sc,
+		client:  client,
+		offset:  sc.Consumer.Offsets.Initial,
+		created: time.Now(),
+		limiter: limiter,
+	}
+
+	if err := kc.initStartOffsets(); err != nil {
+		return nil, err
+	}
+	if err := kc.initReplayTargets(); err != nil {
+		return nil, err
 	}
 
 	// Initialize Sarama consumer and set atomic values.
-	if err := kc.reset(); err != nil {
+	if err := kc.reset(RetryPolicy{}); err != nil {
 		return nil, err
 	}
 
 	return kc, nil
 }
 
+// newClient returns the client to query the brokers with: the shared client
+// when the consumer is created by NewConsumerFromClient, or a new one
+// otherwise. release must be called when done with the client, which closes
+// it only if it's a new one.
+func (kc *consumer) newClient(caller string) (client sarama.Client, release func(), err error) {
+	if kc.client != nil {
+		return kc.client, func() {}, nil
+	}
+
+	client, err = sarama.NewClient(kc.cfg.Brokers, kc.sc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, func() {
+		if err := client.Close(); err != nil {
+			kc.cfg.Logger.Log(context.Background(), "kafkabp.consumer."+caller+": Error closing the client:"+err.Error())
+		}
+	}, nil
+}
+
+// lifecycle returns a context that is canceled when the consumer is shut down.
+func (kc *consumer) lifecycle() context.Context {
+	kc.lifecycleOnce.Do(func() {
+		kc.lifecycleCtx, kc.lifecycleCancel = context.WithCancel(context.Background())
+	})
+	return kc.lifecycleCtx
+}
+
 func (kc *consumer) getConsumer() sarama.Consumer {
 	c, _ := kc.consumer.Load().(sarama.Consumer)
 	return c
 }
 
+// SaramaConsumerAccessor is implemented by the Consumer returned by
+// NewConsumer for a single topic, to access the underlying sarama.Consumer for
+// advanced operations not covered by this package, for example:
+//
+//     if accessor, ok := consumer.(kafkabp.SaramaConsumerAccessor); ok {
+//         partitions, err := accessor.SaramaConsumer().Partitions(topic)
+//         // ...
+//     }
+type SaramaConsumerAccessor interface {
+	// SaramaConsumer returns the sarama.Consumer currently used.
+	//
+	// It's an escape hatch and unsafe to keep using: the sarama.Consumer is
+	// replaced and closed on every rebalance, and when the consumer is closed.
+	// It should be called again before every use, and never closed directly.
+	SaramaConsumer() sarama.Consumer
+}
+
+var _ SaramaConsumerAccessor = (*consumer)(nil)
+
+// SaramaConsumer implements SaramaConsumerAccessor.
+func (kc *consumer) SaramaConsumer() sarama.Consumer {
+	return kc.getConsumer()
+}
+
 func (kc *consumer) getPartitions() []int32 {
 	p, _ := kc.partitions.Load().([]int32)
 	return p
 }
 
 func (kc *consumer) getPartitionConsumers() []sarama.PartitionConsumer {
-	pc, _ := kc.partitionConsumers.Load().([]sarama.PartitionConsumer)
-	return pc
+	kc.partitionsLock.Lock()
+	defer kc.partitionsLock.Unlock()
+	pcs := make([]sarama.PartitionConsumer, 0, len(kc.partitionStates))
+	for _, state := range kc.partitionStates {
+		pcs = append(pcs, state.pc)
+	}
+	return pcs
 }
 
 // reset recreates the consumer and assigns partitions.
-func (kc *consumer) reset() error {
-	if c := kc.getConsumer(); c != nil {
-		if err := c.Close(); err != nil {
-			kc.cfg.Logger.Log(context.Background(), "kafkabp.consumer.reset: Error closing the consumer:"+err.Error())
+//
+// Creating the consumer is retried according to policy when it fails with a
+// transient error, until the consumer is closed.
+func (kc *consumer) reset(policy RetryPolicy) error {
+	if err := kc.closeConsumer(); err != nil {
+		kc.cfg.Logger.Log(context.Background(), "kafkabp.consumer.reset: Error closing the consumer:"+err.Error())
+	}
+
+	for n := 0; ; n++ {
+		err := kc.rebalance()
+		if err == nil {
+			return nil
+		}
+		if n+1 >= policy.MaxAttempts || !isRetriable(err) {
+			kc.cfg.Logger.Log(context.Background(), fmt.Sprintf(
+				"kafkabp.consumer.reset: Giving up resetting the consumer after %d attempt(s): %v",
+				n+1,
+				err,
+			))
+			return err
+		}
+
+		metricsbp.M.Counter(kc.metricName("reset.retry")).With(kc.topicTags(kc.cfg.Topic)...).Add(1)
+		timer := time.NewTimer(policy.delay(n))
+		select {
+		case <-timer.C:
+		case <-kc.lifecycle().Done():
+			// The consumer is closed, stop retrying.
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// checkPartitions returns ErrTooFewPartitions if the topic has fewer than
+// cfg.MinPartitions partitions, or none.
+func (kc *consumer) checkPartitions(partitions []int32) error {
+	min := kc.cfg.MinPartitions
+	if min < 1 {
+		min = 1
+	}
+	if len(partitions) < min {
+		return fmt.Errorf(
+			"%w: topic %q has %d partition(s), expected at least %d",
+			ErrTooFewPartitions,
+			kc.cfg.Topic,
+			len(partitions),
+			min,
+		)
+	}
+	return nil
+}
+
+// rebalance creates a new sarama consumer and assigns partitions.
+func (kc *consumer) rebalance() error {
+	newSaramaConsumer := kc.newSaramaConsumer
+	if newSaramaConsumer == nil {
+		newSaramaConsumer = sarama.NewConsumer
+		if kc.client != nil {
+			newSaramaConsumer = func([]string, *sarama.Config) (sarama.Consumer, error) {
+				// The consumer created from the client doesn't close it.
+				return sarama.NewConsumerFromClient(kc.client)
+			}
 		}
 	}
 
 	rebalance := func() error {
-		c, err := sarama.NewConsumer(kc.cfg.Brokers, kc.sc)
+		c, err := newSaramaConsumer(kc.cfg.Brokers, kc.sc)
 		if err != nil {
 			return err
 		}
 
 		partitions, err := c.Partitions(kc.cfg.Topic)
+		if err == nil {
+			err = kc.checkPartitions(partitions)
+		}
 		if err != nil {
+			if closeErr := c.Close(); closeErr != nil {
+				kc.cfg.Logger.Log(context.Background(), "kafkabp.consumer.rebalance: Error closing the consumer:"+closeErr.Error())
+			}
 			return err
 		}
 
+		kc.consumerLock.Lock()
 		kc.consumer.Store(c)
+		kc.consumerClosed = false
+		kc.consumerLock.Unlock()
 		kc.partitions.Store(partitions)
+		kc.reportPartitions(len(partitions))
 		return nil
 	}
 
 	err := rebalance()
 	if err != nil {
-		metricsbp.M.Counter("kafka.consumer.rebalance.failure").Add(1)
+		metricsbp.M.Counter(kc.metricName("rebalance.failure")).With(kc.topicTags(kc.cfg.Topic)...).Add(1)
 		return err
 	}
 
-	metricsbp.M.Counter("kafka.consumer.rebalance.success").Add(1)
+	metricsbp.M.Counter(kc.metricName("rebalance.success")).With(kc.topicTags(kc.cfg.Topic)...).Add(1)
 	return nil
 }
 
+// resetRetryPolicy returns the RetryPolicy used to reset the consumer after
+// rebalances.
+func (kc *consumer) resetRetryPolicy() RetryPolicy {
+	maxAttempts := kc.cfg.ResetMaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = DefaultResetMaxAttempts
+	}
+	maxBackoff := kc.cfg.ResetMaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultResetMaxBackoff
+	}
+	jitter := 100 * time.Millisecond
+	if jitter > maxBackoff {
+		jitter = maxBackoff
+	}
+	return RetryPolicy{
+		MaxAttempts:  maxAttempts,
+		InitialDelay: 100 * time.Millisecond,
+		MaxDelay:     maxBackoff,
+		MaxJitter:    jitter,
+	}
+}
+
+// reportPartitions reports the number of partitions assigned to the consumer
+// after a rebalance, and counts the changes of it between rebalances.
+func (kc *consumer) reportPartitions(n int) {
+	tags := kc.topicTags(kc.cfg.Topic)
+	metricsbp.M.Gauge(kc.metricName("partitions.active")).With(tags...).Set(float64(n))
+
+	prev := atomic.SwapInt64(&kc.assignedPartitions, int64(n))
+	if atomic.SwapInt64(&kc.partitionsReported, 1) != 0 && prev != int64(n) {
+		metricsbp.M.Counter(kc.metricName("partitions.changed")).With(tags...).Add(1)
+	}
+}
+
 // Close closes all partition consumers first, then the parent consumer.
+//
+// It's the same as Shutdown with a background context, which means it could
+// block indefinitely if the ConsumeMessageFunc hangs.
 func (kc *consumer) Close() error {
-	// Return early if closing is already in progress
+	return kc.Shutdown(context.Background())
+}
+
+// Shutdown shuts down the consumer in the following order:
+//
+// 1. Stop accepting new messages by closing all partition consumers.
+//
+// 2. Wait for in-flight (and already buffered) messages to be handled, until
+// ctx is done.
+//
+// 3. Close the parent consumer.
+//
+// If ctx is done before all the in-flight messages are handled, it still closes
+// the parent consumer, counts it in the kafka.consumer.shutdown.forced metric,
+// and returns an error wrapping ctx.Err().
+//
+// The calls following the first one (including Close and CloseWithTimeout)
+// wait for it to finish, until their ctx is done, and return its result.
+func (kc *consumer) Shutdown(ctx context.Context) error {
 	if !atomic.CompareAndSwapInt64(&kc.closed, 0, 1) {
-		return nil
+		// Closing is already in progress, return its result.
+		return kc.shutdownResult.wait(ctx)
 	}
+	return kc.shutdownResult.set(kc.shutdown(ctx))
+}
+
+// shutdown implements Shutdown, after it's called for the first time.
+func (kc *consumer) shutdown(ctx context.Context) error {
+	kc.markShutdown()
+
+	// interrupt anything waiting on the consumer's lifecycle
+	kc.lifecycle()
+	kc.lifecycleCancel()
 
 	partitionConsumers := kc.getPartitionConsumers()
 	for _, pc := range partitionConsumers {
 		// leaves room to drain pc's message and error channels
 		pc.AsyncClose()
 	}
+
 	// wait for the Consume function to return
-	kc.wg.Wait()
-	return kc.getConsumer().Close()
+	drained := make(chan struct{})
+	go func() {
+		kc.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		metricsbp.M.Counter(kc.metricName("shutdown.forced")).With(kc.topicTags(kc.cfg.Topic)...).Add(1)
+		err := fmt.Errorf(
+			"kafkabp: shutdown interrupted before in-flight messages were drained: %w",
+			ctx.Err(),
+		)
+		if closeErr := kc.closeConsumer(); closeErr != nil {
+			kc.cfg.Logger.Log(context.Background(), "kafkabp.consumer.Shutdown: Error closing the consumer:"+closeErr.Error())
+		}
+		return err
+	}
+	return kc.closeConsumer()
+}
+
+// closeConsumer closes the stored sarama consumer, unless there's none or it's
+// already closed, for example by reset before it failed to create a new one.
+func (kc *consumer) closeConsumer() error {
+	kc.consumerLock.Lock()
+	defer kc.consumerLock.Unlock()
+	c := kc.getConsumer()
+	if c == nil || kc.consumerClosed {
+		return nil
+	}
+	kc.consumerClosed = true
+	return c.Close()
+}
+
+// CloseWithTimeout is the same as Shutdown with a context timing out after
+// timeout, which returns an error wrapping context.DeadlineExceeded when the
+// in-flight messages are not handled in time.
+func (kc *consumer) CloseWithTimeout(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return kc.Shutdown(ctx)
+}
+
+// firstResult is the result of the first Shutdown call, returned by the
+// following calls.
+type firstResult struct {
+	initOnce sync.Once
+	done     chan struct{}
+	err      error
+}
+
+func (r *firstResult) doneChan() chan struct{} {
+	r.initOnce.Do(func() {
+		r.done = make(chan struct{})
+	})
+	return r.done
+}
+
+// set sets the result to err, and returns err.
+func (r *firstResult) set(err error) error {
+	r.err = err
+	close(r.doneChan())
+	return err
+}
+
+// wait returns the result once it's set, or ctx.Err() if ctx is done first.
+func (r *firstResult) wait(ctx context.Context) error {
+	select {
+	case <-r.doneChan():
+		return r.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // Consume consumes Kafka messages and errors from each partition's consumer.
@@ -142,62 +677,92 @@ func (kc *consumer) Consume(
 	messagesFunc ConsumeMessageFunc,
 	errorsFunc ConsumeErrorFunc,
 ) error {
+	return kc.ConsumeContext(context.Background(), messagesFunc, errorsFunc)
+}
+
+// ConsumeContext is like Consume, but also closes the consumer when ctx is
+// done, and returns after the in-flight messages are handled.
+func (kc *consumer) ConsumeContext(
+	ctx context.Context,
+	messagesFunc ConsumeMessageFunc,
+	errorsFunc ConsumeErrorFunc,
+) error {
+	return kc.ConsumeWithErrorContext(ctx, messagesFunc, IgnoreErrorContext(errorsFunc))
+}
+
+// ConsumeWithErrorContext is like ConsumeContext, but the errors are passed to
+// errorsFunc along with their context.
+func (kc *consumer) ConsumeWithErrorContext(
+	ctx context.Context,
+	messagesFunc ConsumeMessageFunc,
+	errorsFunc ConsumeErrorContextFunc,
+) error {
+	returned := make(chan struct{})
+	defer close(returned)
+	go func() {
+		select {
+		case <-returned:
+		case <-ctx.Done():
+			if err := kc.Close(); err != nil {
+				kc.cfg.Logger.Log(context.Background(), "kafkabp.consumer.ConsumeContext: Error closing the consumer:"+err.Error())
+			}
+		}
+	}()
+
 	defer atomic.StoreInt64(&kc.consumeReturned, 1)
+	if kc.replayCaughtUp() {
+		// Nothing to replay.
+		return kc.Close()
+	}
 	kc.wg.Add(1)
 	defer kc.wg.Done()
 
+	factory := kc.cfg.PartitionConsumerFactory
+	if factory == nil {
+		factory = DefaultPartitionConsumerFactory
+	}
+
+	queue := newErrorQueue(errorsFunc, func(error) {
+		metricsbp.M.Counter(kc.metricName("kafka.errors.dropped")).With(kc.topicTags(kc.cfg.Topic)...).Add(1)
+	})
+	defer queue.stop()
+
 	// Sarama could close the channels (and cause the goroutines to finish) in
 	// two cases, where we want different behavior:
 	//   - in case of partition rebalance: restart goroutines
 	//   - in case of call to Close/AsyncClose: exit
 	var wg sync.WaitGroup
 	for {
+		// Close was called while resetting the consumer, so exit.
+		if atomic.LoadInt64(&kc.closed) != 0 {
+			return nil
+		}
+
 		// create a partition consumer for each partition
 		consumer := kc.getConsumer()
 		partitions := kc.getPartitions()
-		partitionConsumers := make([]sarama.PartitionConsumer, 0, len(partitions))
+		kc.prunePartitionStates(partitions)
 
 		for _, p := range partitions {
-			partitionConsumer, err := consumer.ConsumePartition(kc.cfg.Topic, p, kc.offset)
+			partitionConsumer, generation, err := kc.openPartition(consumer, factory, p)
 			if err != nil {
 				return err
 			}
-			partitionConsumers = append(partitionConsumers, partitionConsumer) // for closing individual partitions when Close() is called
 
-			// consume partition consumer messages
 			wg.Add(1)
-			go func(pc sarama.PartitionConsumer) {
+			go func(pc sarama.PartitionConsumer, partition int32, generation int64) {
 				defer wg.Done()
-				for m := range pc.Messages() {
-					// Wrap in anonymous function for easier defer.
-					func() {
-						ctx := context.Background()
-						var err error
-						var span *tracing.Span
-						spanName := "consumer." + kc.cfg.Topic
-						ctx, span = tracing.StartTopLevelServerSpan(ctx, spanName)
-						defer func() {
-							span.FinishWithOptions(tracing.FinishOptions{
-								Ctx: ctx,
-								Err: err,
-							}.Convert())
-						}()
-
-						err = messagesFunc(ctx, m)
-					}()
-				}
-			}(partitionConsumer)
-
-			// consume partition consumer errors
-			wg.Add(1)
-			go func(pc sarama.PartitionConsumer) {
-				defer wg.Done()
-				for err := range pc.Errors() {
-					errorsFunc(err)
-				}
-			}(partitionConsumer)
+				kc.consumePartition(
+					consumer,
+					factory,
+					pc,
+					partition,
+					generation,
+					messagesFunc,
+					queue,
+				)
+			}(partitionConsumer, p, generation)
 		}
-		kc.partitionConsumers.Store(partitionConsumers)
 
 		wg.Wait()
 
@@ -210,13 +775,627 @@ func (kc *consumer) Consume(
 		// Close was not called, so we've gotten here because Sarama closed the
 		// message channel due to a partition rebalance. Reset the consumer and
 		// restart the goroutines.
-		if err := kc.reset(); err != nil {
+		if err := kc.reset(kc.resetRetryPolicy()); err != nil {
+			if atomic.LoadInt64(&kc.closed) != 0 {
+				return nil
+			}
 			return err
 		}
 	}
 }
 
+// partitionState is the state of a partition being consumed.
+type partitionState struct {
+	pc sarama.PartitionConsumer
+
+	// Incremented on every Seek, so that messages (and commits) from the
+	// partition consumer before Seek can be told apart and discarded.
+	generation int64
+}
+
+// openPartition creates a new partition consumer for partition, starting at
+// its resume offset, and registers it as the current one of the partition.
+func (kc *consumer) openPartition(
+	consumer sarama.Consumer,
+	factory PartitionConsumerFactory,
+	partition int32,
+) (pc sarama.PartitionConsumer, generation int64, err error) {
+	kc.partitionsLock.Lock()
+	defer kc.partitionsLock.Unlock()
+
+	pc, err = factory(consumer, kc.cfg.Topic, partition, kc.resumeOffsetLocked(partition))
+	if err != nil {
+		return nil, 0, err
+	}
+	if kc.partitionStates == nil {
+		kc.partitionStates = make(map[int32]*partitionState)
+	}
+	state, ok := kc.partitionStates[partition]
+	if !ok {
+		state = &partitionState{}
+		kc.partitionStates[partition] = state
+	}
+	state.pc = pc
+	if atomic.LoadInt64(&kc.closed) != 0 {
+		// Shutdown could have already closed the other partition consumers
+		// before this one is added.
+		pc.AsyncClose()
+	}
+	return pc, state.generation, nil
+}
+
+// reopenPartition recreates the partition consumer for partition if it was
+// closed by Seek, in which case it returns the new partition consumer and its
+// generation.
+//
+// It returns nil partition consumer if the previous one was closed for any
+// other reason (rebalance or Close).
+func (kc *consumer) reopenPartition(
+	consumer sarama.Consumer,
+	factory PartitionConsumerFactory,
+	partition int32,
+	generation int64,
+) (sarama.PartitionConsumer, int64, error) {
+	if atomic.LoadInt64(&kc.closed) != 0 || kc.partitionGeneration(partition) == generation {
+		return nil, generation, nil
+	}
+	return kc.openPartition(consumer, factory, partition)
+}
+
+// prunePartitionStates forgets the partition consumers of the partitions no
+// longer assigned after a rebalance.
+func (kc *consumer) prunePartitionStates(partitions []int32) {
+	assigned := make(map[int32]bool, len(partitions))
+	for _, p := range partitions {
+		assigned[p] = true
+	}
+
+	kc.partitionsLock.Lock()
+	defer kc.partitionsLock.Unlock()
+	for p := range kc.partitionStates {
+		if !assigned[p] {
+			delete(kc.partitionStates, p)
+		}
+	}
+}
+
+func (kc *consumer) partitionGeneration(partition int32) int64 {
+	kc.partitionsLock.Lock()
+	defer kc.partitionsLock.Unlock()
+	if state, ok := kc.partitionStates[partition]; ok {
+		return state.generation
+	}
+	return 0
+}
+
+// Seek repositions partition to offset at runtime.
+//
+// It recreates the partition consumer of the partition at offset, without
+// restarting the consumers of other partitions. Messages already buffered from
+// the previous partition consumer are discarded without being handled, and
+// in-flight messages from the previous partition consumer no longer advance
+// the committed offset of the partition. offset could also be
+// sarama.OffsetOldest or sarama.OffsetNewest.
+//
+// It returns ErrPartitionNotConsumed if the partition is not currently being
+// consumed.
+func (kc *consumer) Seek(partition int32, offset int64) error {
+	kc.partitionsLock.Lock()
+	state, ok := kc.partitionStates[partition]
+	if !ok {
+		kc.partitionsLock.Unlock()
+		return ErrPartitionNotConsumed
+	}
+	state.generation++
+	if kc.offsets == nil {
+		kc.offsets = make(map[int32]int64)
+	}
+	kc.offsets[partition] = offset
+	pc := state.pc
+	kc.partitionsLock.Unlock()
+
+	// Closing the channels of the partition consumer makes consumePartition
+	// recreate it at the new offset.
+	pc.AsyncClose()
+	return nil
+}
+
+// recoverOffsetOutOfRange recreates the partition consumer of partition at
+// kc.offset (the configured ConsumerConfig.Offset), after pc stopped because
+// its offset is no longer available, e.g. deleted by log retention.
+//
+// It's a no-op if Seek was called on the partition after generation.
+func (kc *consumer) recoverOffsetOutOfRange(pc sarama.PartitionConsumer, partition int32, generation int64) {
+	kc.partitionsLock.Lock()
+	state, ok := kc.partitionStates[partition]
+	if !ok || state.generation != generation {
+		kc.partitionsLock.Unlock()
+		return
+	}
+	state.generation++
+	if kc.offsets == nil {
+		kc.offsets = make(map[int32]int64)
+	}
+	kc.offsets[partition] = kc.offset
+	kc.partitionsLock.Unlock()
+
+	metricsbp.M.Counter(kc.metricName("offset.reset")).With(kc.topicTags(kc.cfg.Topic)...).Add(1)
+	kc.cfg.Logger.Log(context.Background(), fmt.Sprintf(
+		"kafkabp.consumer: topic %q partition %d offset out of range, resetting to %d",
+		kc.cfg.Topic,
+		partition,
+		kc.offset,
+	))
+
+	// sarama already stops the partition consumer on ErrOffsetOutOfRange, but
+	// close it anyway in case it hasn't.
+	pc.AsyncClose()
+}
+
+// consumePartition consumes all the messages and errors from a partition,
+// until its partition consumer is closed by rebalance or Close.
+//
+// When the partition consumer is closed by Seek, or stopped by
+// ErrOffsetOutOfRange, it's recreated and the consuming continues. The other
+// errors from the partition consumer are passed to the ConsumeErrorContextFunc
+// as ConsumeError, and like all the other errors of the partition, via queue.
+func (kc *consumer) consumePartition(
+	consumer sarama.Consumer,
+	factory PartitionConsumerFactory,
+	pc sarama.PartitionConsumer,
+	partition int32,
+	generation int64,
+	messagesFunc ConsumeMessageFunc,
+	queue *errorQueue,
+) {
+	for pc != nil {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func(pc sarama.PartitionConsumer, generation int64) {
+			defer wg.Done()
+			for err := range pc.Errors() {
+				if errors.Is(err.Err, sarama.ErrOffsetOutOfRange) {
+					kc.recoverOffsetOutOfRange(pc, partition, generation)
+					continue
+				}
+				metricsbp.M.Counter(kc.metricName("kafka.errors")).With(kc.topicTags(err.Topic)...).Add(1)
+				queue.add(withTopicPartition(context.Background(), err.Topic, err.Partition), newConsumeError(err))
+			}
+		}(pc, generation)
+		kc.consumeMessages(pc, partition, generation, messagesFunc, queue.add)
+		wg.Wait()
+
+		var err error
+		pc, generation, err = kc.reopenPartition(consumer, factory, partition, generation)
+		if err != nil {
+			queue.add(withTopicPartition(context.Background(), kc.cfg.Topic, partition), err)
+			return
+		}
+	}
+}
+
+// consumeMessages consumes all the messages from a single partition consumer
+// until its messages channel is closed.
+//
+// When cfg.MaxConcurrentPerPartition > 1, up to that many messages are handled
+// concurrently, and the committed offset of the partition only advances past a
+// message after all the messages before it are also handled. The messages still
+// carry the order they are handled in, see WithPartitionState.
+func (kc *consumer) consumeMessages(
+	pc sarama.PartitionConsumer,
+	partition int32,
+	generation int64,
+	messagesFunc ConsumeMessageFunc,
+	errorsFunc ConsumeErrorContextFunc,
+) {
+	concurrency := kc.cfg.MaxConcurrentPerPartition
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	seq := newOffsetSequencer()
+
+	var limiter *rateLimiter
+	if kc.cfg.PerPartitionRateLimit > 0 {
+		limiter = newRateLimiter(kc.cfg.PerPartitionRateLimit, 1)
+	}
+
+	messages := pc.Messages()
+	dispatch := seq.dispatch
+	strictOffsetAssert := kc.cfg.StrictOffsetAssert
+	if kc.cfg.PriorityHeader != "" {
+		size := kc.cfg.PriorityBufferSize
+		if size <= 0 {
+			size = DefaultPriorityBufferSize
+		}
+		// The messages are dispatched in the order they are delivered, before
+		// they are reordered.
+		messages = prioritizeMessages(
+			messages,
+			kc.cfg.PriorityHeader,
+			size,
+			func(m *sarama.ConsumerMessage) {
+				seq.dispatch(m.Offset)
+			},
+		)
+		dispatch = func(int64) {}
+		strictOffsetAssert = false
+	}
+
+	// previous is closed once the previous message is handled, for
+	// WithPartitionState to keep the messages in order when they are handled
+	// concurrently.
+	previous := make(chan struct{})
+	close(previous)
+
+	// idle fires when the partition could be caught up without the remaining
+	// messages being delivered, see checkReplayIdle.
+	var idle <-chan time.Time
+	var idleTimer *time.Timer
+	if kc.replaying(partition) {
+		idleTimer = time.NewTimer(kc.replayIdleTimeout())
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+	lastDelivered := time.Now()
+
+	lastOffset := int64(-1)
+	for {
+		var m *sarama.ConsumerMessage
+		var ok bool
+		select {
+		case m, ok = <-messages:
+		case <-idle:
+			if wait := kc.checkReplayIdle(pc, partition, seq, lastDelivered); wait > 0 {
+				idleTimer.Reset(wait)
+			} else {
+				idle = nil
+			}
+			continue
+		}
+		if !ok {
+			break
+		}
+		lastDelivered = time.Now()
+
+		if kc.partitionGeneration(m.Partition) != generation {
+			// Seek was called, discard the buffered messages.
+			continue
+		}
+
+		if strictOffsetAssert {
+			if m.Offset <= lastOffset {
+				msg := fmt.Sprintf(
+					"kafkabp.consumer: StrictOffsetAssert: topic %q partition %d delivered offset %d after offset %d",
+					m.Topic,
+					m.Partition,
+					m.Offset,
+					lastOffset,
+				)
+				kc.cfg.Logger.Log(context.Background(), msg)
+				panic(msg)
+			}
+			lastOffset = m.Offset
+		}
+
+		if kc.cfg.OnShutdownMessage != nil && atomic.LoadInt64(&kc.closed) != 0 {
+			// Message arrived in the shutdown window.
+			ctx := withTopicPartition(context.Background(), m.Topic, m.Partition)
+			if err := kc.cfg.OnShutdownMessage(ctx, m); err != nil {
+				errorsFunc(ctx, err)
+			}
+			continue
+		}
+
+		if kc.discardOnShutdown() {
+			continue
+		}
+
+		if err := kc.pauser.wait(kc.lifecycle(), m.Partition); err != nil {
+			// The consumer is shutting down while paused, skip the remaining
+			// buffered messages.
+			continue
+		}
+
+		if limiter != nil {
+			waited, err := limiter.wait(kc.lifecycle())
+			if waited {
+				metricsbp.M.Counter(kc.metricName("ratelimited.count")).With(
+					"partition", strconv.FormatInt(int64(m.Partition), 10),
+				).Add(1)
+			}
+			if err != nil {
+				// The consumer is shutting down, skip the remaining buffered
+				// messages.
+				continue
+			}
+		}
+
+		if err := kc.waitRateLimit(kc.lifecycle()); err != nil {
+			// The consumer is shutting down, skip the remaining buffered
+			// messages.
+			continue
+		}
+
+		dispatch(m.Offset)
+		if concurrency <= 1 {
+			kc.handleMessage(m, messagesFunc, errorsFunc)
+			kc.commit(m.Partition, generation, seq, m.Offset)
+			continue
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		done := make(chan struct{})
+		go func(m *sarama.ConsumerMessage, previous <-chan struct{}) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			kc.handleMessage(
+				m,
+				func(ctx context.Context, m *sarama.ConsumerMessage) error {
+					return messagesFunc(withPreviousMessage(ctx, previous), m)
+				},
+				errorsFunc,
+			)
+			close(done)
+			kc.commit(m.Partition, generation, seq, m.Offset)
+		}(m, previous)
+		previous = done
+	}
+	wg.Wait()
+}
+
+// markShutdown records the time the consumer is shut down at, for
+// discardOnShutdown.
+func (kc *consumer) markShutdown() {
+	atomic.StoreInt64(&kc.shutdownAt, time.Now().UnixNano())
+}
+
+// discardOnShutdown returns true if the consumer is shut down, and
+// cfg.DrainPolicy says the buffered messages should no longer be handled.
+func (kc *consumer) discardOnShutdown() bool {
+	ns := atomic.LoadInt64(&kc.shutdownAt)
+	if ns == 0 || kc.cfg.DrainPolicy.drains(time.Unix(0, ns)) {
+		return false
+	}
+	metricsbp.M.Counter(kc.metricName("shutdown.discarded")).With(kc.topicTags(kc.cfg.Topic)...).Add(1)
+	return true
+}
+
+// handleMessage calls messagesFunc for a single message, wrapped in a span
+// unless cfg.Tracing is disabled, and returns its error, or the error decoding
+// the payload.
+//
+// The payload decode errors are counted and logged the same as the errors of
+// messagesFunc, and also passed to errorsFunc, which is the add method of the
+// errorQueue of the consumer.
+func (kc *consumer) handleMessage(
+	m *sarama.ConsumerMessage,
+	messagesFunc ConsumeMessageFunc,
+	errorsFunc ConsumeErrorContextFunc,
+) error {
+	handle := ConsumeMessageFunc(func(ctx context.Context, m *sarama.ConsumerMessage) error {
+		tags := kc.topicTags(m.Topic)
+		timer := metricsbp.NewTimer(metricsbp.M.Timing(kc.metricName("message.duration")).With(tags...))
+		value, err := decodePayload(kc.cfg.PayloadCodec, m.Value)
+		if err == nil {
+			m.Value = value
+			ctx = kc.edgeContextFromMessage(ctx, m)
+			err = messagesFunc(ctx, m)
+		} else {
+			// The message never reaches messagesFunc, so report it as an error
+			// as well, besides counting it as a failed message below.
+			errorsFunc(ctx, err)
+		}
+		timer.ObserveDuration()
+		messageTags := kc.messageTags(m, tags)
+		metricsbp.M.Counter(kc.metricName("messages.processed")).With(messageTags...).Add(1)
+		if err != nil {
+			metricsbp.M.Counter(kc.metricName("messages.failed")).With(messageTags...).Add(1)
+			metricsbp.M.Counter(kc.metricName("handler.errors")).With(tags...).Add(1)
+			kc.logFailedPayload(ctx, m, err)
+		} else {
+			atomic.StoreInt64(&kc.lastMessage, time.Now().UnixNano())
+		}
+		return err
+	})
+	if kc.cfg.tracing() {
+		starter := kc.cfg.SpanStarter
+		if starter == nil {
+			starter = BaseplateSpanStarter{HeaderKeys: kc.cfg.TraceHeaderKeys}
+		}
+		handle = SpanMiddleware(starter)(handle)
+	}
+	return handle(withTopicPartition(context.Background(), m.Topic, m.Partition), m)
+}
+
+// metricName returns the name of the metric reported by the consumer, prefixed
+// by cfg.MetricsPrefix.
+func (kc *consumer) metricName(name string) string {
+	prefix := kc.cfg.MetricsPrefix
+	if prefix == "" {
+		prefix = DefaultMetricsPrefix
+	}
+	return prefix + "." + name
+}
+
+// topicTags returns the tags to report for topic on the consumer's metrics,
+// according to cfg.DisableMetricsTopicTag and cfg.MetricsTopicBuckets.
+func (kc *consumer) topicTags(topic string) []string {
+	if kc.cfg.DisableMetricsTopicTag {
+		return nil
+	}
+	if n := kc.cfg.MetricsTopicBuckets; n > 0 {
+		h := fnv.New32a()
+		h.Write([]byte(topic))
+		topic = "bucket-" + strconv.FormatUint(uint64(h.Sum32()%uint32(n)), 10)
+	}
+	return []string{"topic", topic}
+}
+
+// messageTags returns tags with the tags of m from cfg.MetricsTags appended,
+// if set.
+func (kc *consumer) messageTags(m *sarama.ConsumerMessage, tags []string) []string {
+	if kc.cfg.MetricsTags == nil {
+		return tags
+	}
+	extra := kc.cfg.MetricsTags(m)
+	merged := make([]string, 0, len(tags)+len(extra))
+	merged = append(merged, tags...)
+	return append(merged, extra...)
+}
+
+// logFailedPayload logs a hex preview of the first cfg.LogFailedPayloadBytes
+// bytes of the value of a message the ConsumeMessageFunc failed to handle.
+func (kc *consumer) logFailedPayload(ctx context.Context, m *sarama.ConsumerMessage, err error) {
+	n := kc.cfg.LogFailedPayloadBytes
+	if n <= 0 {
+		return
+	}
+	if n > len(m.Value) {
+		n = len(m.Value)
+	}
+	kc.cfg.Logger.Log(ctx, fmt.Sprintf(
+		"kafkabp.consumer: Error handling message: topic=%q partition=%d offset=%d size=%d payload[:%d]=%x: %v",
+		m.Topic,
+		m.Partition,
+		m.Offset,
+		len(m.Value),
+		n,
+		m.Value[:n],
+		err,
+	))
+}
+
+// commit marks the message at offset as done in seq, and advances the
+// committed offset of the partition if possible.
+//
+// It's a no-op if Seek was called on the partition after generation.
+func (kc *consumer) commit(partition int32, generation int64, seq *offsetSequencer, offset int64) {
+	next, advanced := seq.complete(offset)
+	if !advanced {
+		return
+	}
+
+	kc.partitionsLock.Lock()
+	defer kc.partitionsLock.Unlock()
+	if state, ok := kc.partitionStates[partition]; ok && state.generation != generation {
+		return
+	}
+	if kc.offsets == nil {
+		kc.offsets = make(map[int32]int64)
+	}
+	kc.offsets[partition] = next
+	kc.trackReplayLocked(partition, next)
+}
+
+// resumeOffset returns the offset a new partition consumer of partition should
+// start from: the committed offset if any message from that partition was
+// already processed, or its resolved start offset (see cfg.StartOffsets and
+// cfg.StartTime), kc.offset otherwise.
+func (kc *consumer) resumeOffset(partition int32) int64 {
+	kc.partitionsLock.Lock()
+	defer kc.partitionsLock.Unlock()
+	return kc.resumeOffsetLocked(partition)
+}
+
+// resumeOffsetLocked is resumeOffset with kc.partitionsLock already held.
+func (kc *consumer) resumeOffsetLocked(partition int32) int64 {
+	if offset, ok := kc.offsets[partition]; ok {
+		return offset
+	}
+	return kc.offset
+}
+
+// Offsets implements Consumer.
+func (kc *consumer) Offsets() map[int32]int64 {
+	kc.partitionsLock.Lock()
+	defer kc.partitionsLock.Unlock()
+	offsets := make(map[int32]int64, len(kc.partitionStates))
+	for p := range kc.partitionStates {
+		offsets[p] = kc.resumeOffsetLocked(p)
+	}
+	return offsets
+}
+
+// HighWaterMarks implements Consumer.
+func (kc *consumer) HighWaterMarks() map[int32]int64 {
+	kc.partitionsLock.Lock()
+	defer kc.partitionsLock.Unlock()
+	hwms := make(map[int32]int64, len(kc.partitionStates))
+	for p, state := range kc.partitionStates {
+		hwms[p] = state.pc.HighWaterMarkOffset()
+	}
+	return hwms
+}
+
+// SetRateLimit implements Consumer.
+func (kc *consumer) SetRateLimit(rate float64, burst int) {
+	kc.limiter.setLimit(rate, burst)
+}
+
+// waitRateLimit blocks until the rate limit across all partitions allows
+// another message to be handled, or ctx is done.
+func (kc *consumer) waitRateLimit(ctx context.Context) error {
+	waited, err := kc.limiter.wait(ctx)
+	if waited {
+		metricsbp.M.Counter(kc.metricName("ratelimited.global.count")).With(kc.topicTags(kc.cfg.Topic)...).Add(1)
+	}
+	return err
+}
+
+// Pause implements Consumer.
+func (kc *consumer) Pause() {
+	kc.pauser.pause()
+}
+
+// Resume implements Consumer.
+func (kc *consumer) Resume() {
+	kc.pauser.resume()
+}
+
+// PausePartition implements Consumer.
+func (kc *consumer) PausePartition(partition int32) {
+	kc.pauser.pausePartition(partition)
+}
+
+// ResumePartition implements Consumer.
+func (kc *consumer) ResumePartition(partition int32) {
+	kc.pauser.resumePartition(partition)
+}
+
+// IsPaused implements Consumer.
+func (kc *consumer) IsPaused() bool {
+	return kc.pauser.isPaused()
+}
+
 // IsHealthy returns true until Consume returns, then false thereafter.
+//
+// When cfg.StalenessThreshold is positive, it also returns false when no
+// message was handled successfully within it.
 func (kc *consumer) IsHealthy() bool {
-	return atomic.LoadInt64(&kc.consumeReturned) == 0
+	return atomic.LoadInt64(&kc.consumeReturned) == 0 && kc.fresh()
+}
+
+// fresh returns false if cfg.StalenessThreshold is positive and no message was
+// handled successfully within it.
+func (kc *consumer) fresh() bool {
+	return kc.cfg.StalenessThreshold <= 0 || kc.HealthyWithin(kc.cfg.StalenessThreshold)
+}
+
+// LastMessageTime implements Consumer.
+func (kc *consumer) LastMessageTime() time.Time {
+	if ns := atomic.LoadInt64(&kc.lastMessage); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// HealthyWithin implements Consumer.
+func (kc *consumer) HealthyWithin(d time.Duration) bool {
+	last := kc.LastMessageTime()
+	if last.IsZero() {
+		last = kc.created
+	}
+	return time.Since(last) <= d
 }
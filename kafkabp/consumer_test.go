@@ -3,12 +3,19 @@ package kafkabp
 import (
 	"context"
 	"errors"
+	"reflect"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/Shopify/sarama/mocks"
+	opentracing "github.com/opentracing/opentracing-go"
+
+	"github.com/reddit/baseplate.go/metricsbp"
 )
 
 func TestKafkaConsumer_Consume(t *testing.T) {
@@ -62,6 +69,12 @@ func TestKafkaConsumer_Consume(t *testing.T) {
 	if !containsMsg(consumedMsgs, kMsg1) {
 		t.Errorf("expected consumedMsgs to contain kMsg, got %v", consumedMsgs)
 	}
+	for _, err := range consumedErrs {
+		var consumeErr ConsumeError
+		if !errors.As(err, &consumeErr) || !errors.Is(err, kErr) {
+			t.Errorf("expected ConsumeError wrapping %v, got %#v", kErr, err)
+		}
+	}
 }
 
 // This tests that when Close() is called on a KafkaConsumer instance
@@ -123,9 +136,1302 @@ func TestKafkaConsumer_Close(t *testing.T) {
 	}
 }
 
+func TestKafkaConsumer_PartitionConsumerFactory(t *testing.T) {
+	kc := getTestMockConsumer(t)
+	pc, _ := setupPartitionConsumers(t, kc)
+	failedPartition := kc.getPartitions()[1]
+	kErr := errors.New("injected error")
+
+	var calls []int32
+	kc.cfg.PartitionConsumerFactory = func(
+		consumer sarama.Consumer,
+		topic string,
+		partition int32,
+		offset int64,
+	) (sarama.PartitionConsumer, error) {
+		calls = append(calls, partition)
+		if partition == failedPartition {
+			return nil, kErr
+		}
+		return DefaultPartitionConsumerFactory(consumer, topic, partition, offset)
+	}
+
+	err := kc.Consume(
+		func(context.Context, *sarama.ConsumerMessage) error {
+			return nil
+		},
+		func(error) {},
+	)
+	if !errors.Is(err, kErr) {
+		t.Errorf("expected error %v, got %v", kErr, err)
+	}
+	if len(calls) != 2 {
+		t.Errorf("expected factory to be called twice, got %v", calls)
+	}
+	pc.Close()
+}
+
+func TestKafkaConsumer_StrictOffsetAssert(t *testing.T) {
+	kc := getTestMockConsumer(t)
+	kc.cfg.StrictOffsetAssert = true
+
+	pc := fakePartitionConsumer{
+		messages: make(chan *sarama.ConsumerMessage, 3),
+	}
+	for _, offset := range []int64{1, 2, 2} {
+		pc.messages <- &sarama.ConsumerMessage{
+			Topic:  kc.cfg.Topic,
+			Offset: offset,
+		}
+	}
+	close(pc.messages)
+
+	var consumed int
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("expected panic on out of order offset, got none")
+		}
+		if consumed != 2 {
+			t.Errorf("expected 2 messages consumed before panic, got %d", consumed)
+		}
+	}()
+	kc.consumeMessages(
+		pc,
+		0, // partition
+		0, // generation
+		func(context.Context, *sarama.ConsumerMessage) error {
+			consumed++
+			return nil
+		},
+		func(context.Context, error) {},
+	)
+}
+
+func TestKafkaConsumer_MaxConcurrentPerPartition(t *testing.T) {
+	const (
+		concurrency = 4
+		total       = 20
+		partition   = 1
+	)
+
+	kc := getTestMockConsumer(t)
+	kc.cfg.MaxConcurrentPerPartition = concurrency
+
+	pc := fakePartitionConsumer{
+		messages: make(chan *sarama.ConsumerMessage, total),
+	}
+	for i := 0; i < total; i++ {
+		pc.messages <- &sarama.ConsumerMessage{
+			Topic:     kc.cfg.Topic,
+			Partition: partition,
+			Offset:    int64(i),
+		}
+	}
+	close(pc.messages)
+
+	var running, maxRunning int64
+	var lock sync.Mutex
+	kc.consumeMessages(
+		pc,
+		partition,
+		0, // generation
+		func(_ context.Context, msg *sarama.ConsumerMessage) error {
+			lock.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			lock.Unlock()
+
+			// Finish the later messages first.
+			time.Sleep(time.Duration(total-msg.Offset) * time.Millisecond)
+
+			lock.Lock()
+			running--
+			lock.Unlock()
+			return nil
+		},
+		func(context.Context, error) {},
+	)
+
+	if maxRunning > concurrency {
+		t.Errorf("expected at most %d concurrent handlers, got %d", concurrency, maxRunning)
+	}
+	if offset := kc.resumeOffset(partition); offset != total {
+		t.Errorf("expected committed offset %d, got %d", total, offset)
+	}
+}
+
+func TestKafkaConsumer_RateLimit(t *testing.T) {
+	const (
+		total     = 3
+		partition = 1
+	)
+
+	kc := getTestMockConsumer(t)
+	kc.SetRateLimit(0.001, 1)
+
+	pc := fakePartitionConsumer{
+		messages: make(chan *sarama.ConsumerMessage, total),
+	}
+	for i := 0; i < total; i++ {
+		pc.messages <- &sarama.ConsumerMessage{
+			Topic:     kc.cfg.Topic,
+			Partition: partition,
+			Offset:    int64(i),
+		}
+	}
+	close(pc.messages)
+
+	consumed := make(chan int64, total)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		kc.consumeMessages(
+			pc,
+			partition,
+			0, // generation
+			func(_ context.Context, msg *sarama.ConsumerMessage) error {
+				consumed <- msg.Offset
+				return nil
+			},
+			func(context.Context, error) {},
+		)
+	}()
+
+	if offset := <-consumed; offset != 0 {
+		t.Errorf("expected offset 0 to be handled within the burst, got %d", offset)
+	}
+	select {
+	case offset := <-consumed:
+		t.Fatalf("expected offset 1 to be rate limited, got %d", offset)
+	case <-time.After(time.Millisecond * 10):
+	}
+
+	// Shutting down skips the messages still waiting on the limit.
+	kc.lifecycle()
+	kc.lifecycleCancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the rate limited messages to be skipped on shutdown")
+	}
+	if len(consumed) != 0 {
+		t.Errorf("expected no more messages to be handled, got %d", len(consumed))
+	}
+}
+
+func TestKafkaConsumer_OnShutdownMessage(t *testing.T) {
+	const partition = 1
+
+	kc := getTestMockConsumer(t)
+	var shutdownMessages []int64
+	kc.cfg.OnShutdownMessage = func(_ context.Context, msg *sarama.ConsumerMessage) error {
+		shutdownMessages = append(shutdownMessages, msg.Offset)
+		return errors.New("shutdown message error")
+	}
+
+	pc := fakePartitionConsumer{
+		messages: make(chan *sarama.ConsumerMessage, 2),
+	}
+	for i := 0; i < 2; i++ {
+		pc.messages <- &sarama.ConsumerMessage{
+			Topic:     kc.cfg.Topic,
+			Partition: partition,
+			Offset:    int64(i),
+		}
+	}
+	close(pc.messages)
+
+	offset := kc.resumeOffset(partition)
+	atomic.StoreInt64(&kc.closed, 1)
+	var consumed, errs int
+	kc.consumeMessages(
+		pc,
+		partition,
+		0, // generation
+		func(context.Context, *sarama.ConsumerMessage) error {
+			consumed++
+			return nil
+		},
+		func(context.Context, error) {
+			errs++
+		},
+	)
+
+	if consumed != 0 {
+		t.Errorf("expected no messages handled after close, got %d", consumed)
+	}
+	if len(shutdownMessages) != 2 {
+		t.Errorf("expected 2 shutdown messages, got %v", shutdownMessages)
+	}
+	if errs != 2 {
+		t.Errorf("expected 2 errors, got %d", errs)
+	}
+	if got := kc.resumeOffset(partition); got != offset {
+		t.Errorf("expected committed offset to stay at %d, got %d", offset, got)
+	}
+}
+
+func TestKafkaConsumer_DrainPolicy(t *testing.T) {
+	const partition = 1
+
+	for _, c := range []struct {
+		label    string
+		policy   DrainPolicy
+		consumed int
+	}{
+		{
+			label:    "all",
+			policy:   DrainAll,
+			consumed: 2,
+		},
+		{
+			label:    "none",
+			policy:   DrainNone,
+			consumed: 0,
+		},
+		{
+			label:    "timeout",
+			policy:   DrainTimeout(time.Minute),
+			consumed: 2,
+		},
+		{
+			label:    "timed-out",
+			policy:   DrainTimeout(0),
+			consumed: 0,
+		},
+	} {
+		t.Run(c.label, func(t *testing.T) {
+			kc := getTestMockConsumer(t)
+			kc.cfg.DrainPolicy = c.policy
+
+			pc := fakePartitionConsumer{
+				messages: make(chan *sarama.ConsumerMessage, 2),
+			}
+			for i := 0; i < 2; i++ {
+				pc.messages <- &sarama.ConsumerMessage{
+					Topic:     kc.cfg.Topic,
+					Partition: partition,
+					Offset:    int64(i),
+				}
+			}
+			close(pc.messages)
+
+			offset := kc.resumeOffset(partition)
+			kc.markShutdown()
+			var consumed int
+			kc.consumeMessages(
+				pc,
+				partition,
+				0, // generation
+				func(context.Context, *sarama.ConsumerMessage) error {
+					consumed++
+					return nil
+				},
+				func(context.Context, error) {},
+			)
+
+			if consumed != c.consumed {
+				t.Errorf("expected %d messages handled after close, got %d", c.consumed, consumed)
+			}
+			if c.consumed == 0 {
+				if got := kc.resumeOffset(partition); got != offset {
+					t.Errorf("expected committed offset to stay at %d, got %d", offset, got)
+				}
+			}
+		})
+	}
+}
+
+func TestKafkaConsumer_ConsumeContext(t *testing.T) {
+	kc := getTestMockConsumer(t)
+	pc, pc1 := setupPartitionConsumers(t, kc)
+	pc.ExpectMessagesDrainedOnClose()
+	pc1.ExpectMessagesDrainedOnClose()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	consumed := make(chan struct{})
+	consumeDone := make(chan error)
+	go func() {
+		consumeDone <- kc.ConsumeContext(
+			ctx,
+			func(context.Context, *sarama.ConsumerMessage) error {
+				close(consumed)
+				return nil
+			},
+			func(error) {},
+		)
+	}()
+	pc.YieldMessage(getTestKafkaMessage("key1", "value1"))
+	<-consumed
+
+	cancel()
+	select {
+	case err := <-consumeDone:
+		if err != nil {
+			t.Errorf("expected ConsumeContext to return nil, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ConsumeContext did not return after ctx is canceled")
+	}
+	if kc.IsHealthy() {
+		t.Error("expected consumer to be unhealthy after ConsumeContext returns")
+	}
+}
+
+func TestKafkaConsumer_Shutdown(t *testing.T) {
+	kc := getTestMockConsumer(t)
+	pc, _ := setupPartitionConsumers(t, kc)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	consumeDone := make(chan struct{})
+	go func() {
+		defer close(consumeDone)
+		kc.Consume(
+			func(context.Context, *sarama.ConsumerMessage) error {
+				close(started)
+				<-release
+				return nil
+			},
+			func(error) {},
+		)
+	}()
+	pc.YieldMessage(getTestKafkaMessage("key1", "value1"))
+	<-started
+	defer func() {
+		close(release)
+		// Don't leave the message handling running into other tests.
+		<-consumeDone
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	err := kc.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestKafkaConsumer_ErrorContext(t *testing.T) {
+	partitions := []int32{3}
+	fake := newFakeConsumer(partitions)
+
+	kc := getTestConsumer(t)
+	kc.cfg.PayloadCodec = PayloadCodecGzip
+	kc.consumer.Store(fake)
+	kc.partitions.Store(partitions)
+
+	type contextError struct {
+		ctx context.Context
+		err error
+	}
+	errs := make(chan contextError, 2)
+	go func() {
+		kc.ConsumeWithErrorContext(
+			context.Background(),
+			func(context.Context, *sarama.ConsumerMessage) error {
+				return nil
+			},
+			func(ctx context.Context, err error) {
+				errs <- contextError{ctx: ctx, err: err}
+			},
+		)
+	}()
+	defer kc.Close()
+
+	pc := receivePartitionConsumers(t, fake, 1)[0]
+	check := func(label string, withSpan bool, send func()) {
+		t.Helper()
+		send()
+		var ce contextError
+		select {
+		case ce = <-errs:
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for the %s", label)
+		}
+		topic, partition, ok := TopicPartitionFromContext(ce.ctx)
+		if !ok || topic != pc.topic || partition != pc.partition {
+			t.Errorf(
+				"expected context of %s to carry %s/%d, got %q/%d (%v)",
+				label,
+				pc.topic,
+				pc.partition,
+				topic,
+				partition,
+				ok,
+			)
+		}
+		if span := opentracing.SpanFromContext(ce.ctx); (span != nil) != withSpan {
+			t.Errorf("expected span of %s: %v, got %v", label, withSpan, span)
+		}
+	}
+	check("partition consumer error", false, func() {
+		pc.errors <- &sarama.ConsumerError{
+			Topic:     pc.topic,
+			Partition: pc.partition,
+			Err:       errors.New("kafka error"),
+		}
+	})
+	check("decode error", true, func() {
+		pc.messages <- &sarama.ConsumerMessage{
+			Topic:     pc.topic,
+			Partition: pc.partition,
+			Value:     []byte("not gzip"),
+		}
+	})
+}
+
+func TestKafkaConsumer_CloseTwice(t *testing.T) {
+	partitions := []int32{0}
+	fake := newFakeConsumer(partitions)
+	fake.closeErr = errors.New("close error")
+
+	kc := getTestConsumer(t)
+	kc.consumer.Store(fake)
+	kc.partitions.Store(partitions)
+
+	if err := kc.Close(); !errors.Is(err, fake.closeErr) {
+		t.Errorf("expected error %v from the first Close, got %v", fake.closeErr, err)
+	}
+	if err := kc.Close(); !errors.Is(err, fake.closeErr) {
+		t.Errorf("expected error %v from the second Close, got %v", fake.closeErr, err)
+	}
+}
+
+func TestKafkaConsumer_CloseWithTimeout(t *testing.T) {
+	st := metricsbp.NewStatsd(context.Background(), metricsbp.StatsdConfig{})
+	defer func(prev *metricsbp.Statsd) {
+		metricsbp.M = prev
+	}(metricsbp.M)
+	metricsbp.M = st
+
+	kc := getTestMockConsumer(t)
+	pc, _ := setupPartitionConsumers(t, kc)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	consumeDone := make(chan struct{})
+	go func() {
+		defer close(consumeDone)
+		kc.Consume(
+			func(context.Context, *sarama.ConsumerMessage) error {
+				close(started)
+				<-release
+				return nil
+			},
+			func(error) {},
+		)
+	}()
+	pc.YieldMessage(getTestKafkaMessage("key1", "value1"))
+	<-started
+	defer func() {
+		close(release)
+		<-consumeDone
+	}()
+
+	err := kc.CloseWithTimeout(time.Millisecond * 10)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	var sb strings.Builder
+	if _, err := st.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	const expected = "kafka.consumer.shutdown.forced"
+	if stats := sb.String(); !strings.Contains(stats, expected) {
+		t.Errorf("expected %q to be reported, got %q", expected, stats)
+	}
+}
+
+func TestKafkaConsumer_HandlerErrorMetrics(t *testing.T) {
+	st := metricsbp.NewStatsd(context.Background(), metricsbp.StatsdConfig{})
+	defer func(prev *metricsbp.Statsd) {
+		metricsbp.M = prev
+	}(metricsbp.M)
+	metricsbp.M = st
+
+	kc := getTestMockConsumer(t)
+	msg := getTestKafkaMessage("key", "value")
+	msg.Topic = kc.cfg.Topic
+	kc.handleMessage(
+		msg,
+		func(context.Context, *sarama.ConsumerMessage) error {
+			return errors.New("handler error")
+		},
+		func(context.Context, error) {},
+	)
+
+	var sb strings.Builder
+	if _, err := st.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	const expected = "kafka.consumer.handler.errors"
+	if stats := sb.String(); !strings.Contains(stats, expected) || !strings.Contains(stats, kc.cfg.Topic) {
+		t.Errorf("expected %q tagged with topic %q, got %q", expected, kc.cfg.Topic, stats)
+	}
+}
+
+func TestKafkaConsumer_PayloadCodec(t *testing.T) {
+	st := metricsbp.NewStatsd(context.Background(), metricsbp.StatsdConfig{})
+	defer func(prev *metricsbp.Statsd) {
+		metricsbp.M = prev
+	}(metricsbp.M)
+	metricsbp.M = st
+
+	kc := getTestMockConsumer(t)
+	kc.cfg.PayloadCodec = PayloadCodecGzip
+
+	t.Run("tombstone", func(t *testing.T) {
+		msg := getTestKafkaMessage("key", "")
+		msg.Value = nil
+		var handled bool
+		err := kc.handleMessage(
+			msg,
+			func(_ context.Context, m *sarama.ConsumerMessage) error {
+				handled = true
+				if m.Value != nil {
+					t.Errorf("expected tombstone value to stay nil, got %q", m.Value)
+				}
+				return nil
+			},
+			func(_ context.Context, err error) {
+				t.Errorf("unexpected error: %v", err)
+			},
+		)
+		if err != nil || !handled {
+			t.Errorf("expected tombstone to be handled, got handled=%v err=%v", handled, err)
+		}
+	})
+
+	t.Run("decode-failure", func(t *testing.T) {
+		msg := getTestKafkaMessage("key", "not gzip")
+		msg.Topic = kc.cfg.Topic
+		var errs []error
+		err := kc.handleMessage(
+			msg,
+			func(context.Context, *sarama.ConsumerMessage) error {
+				t.Error("messagesFunc should not be called")
+				return nil
+			},
+			func(_ context.Context, err error) {
+				errs = append(errs, err)
+			},
+		)
+		if err == nil || len(errs) != 1 {
+			t.Errorf("expected the decode error returned and reported once, got %v, %v", err, errs)
+		}
+
+		var sb strings.Builder
+		if _, err := st.WriteTo(&sb); err != nil {
+			t.Fatal(err)
+		}
+		stats := sb.String()
+		for _, name := range []string{
+			"kafka.consumer.messages.failed",
+			"kafka.consumer.handler.errors",
+		} {
+			if !strings.Contains(stats, name) {
+				t.Errorf("expected metric %q for the decode failure, got %q", name, stats)
+			}
+		}
+	})
+}
+
+func TestKafkaConsumer_TopicTags(t *testing.T) {
+	kc := getTestConsumer(t)
+	if tags := kc.topicTags("foo"); len(tags) != 2 || tags[0] != "topic" || tags[1] != "foo" {
+		t.Errorf("expected topic tag %q, got %q", "foo", tags)
+	}
+
+	kc.cfg.MetricsTopicBuckets = 4
+	tags := kc.topicTags("foo")
+	if len(tags) != 2 || !strings.HasPrefix(tags[1], "bucket-") {
+		t.Fatalf("expected bucketed topic tag, got %q", tags)
+	}
+	if again := kc.topicTags("foo"); again[1] != tags[1] {
+		t.Errorf("expected stable bucket %q, got %q", tags[1], again[1])
+	}
+	buckets := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		buckets[kc.topicTags("topic-"+strconv.Itoa(i))[1]] = true
+	}
+	if len(buckets) > kc.cfg.MetricsTopicBuckets {
+		t.Errorf("expected at most %d buckets, got %v", kc.cfg.MetricsTopicBuckets, buckets)
+	}
+
+	kc.cfg.DisableMetricsTopicTag = true
+	if tags := kc.topicTags("foo"); len(tags) != 0 {
+		t.Errorf("expected no topic tag, got %q", tags)
+	}
+}
+
+func TestKafkaConsumer_MessageMetrics(t *testing.T) {
+	st := metricsbp.NewStatsd(context.Background(), metricsbp.StatsdConfig{})
+	defer func(prev *metricsbp.Statsd) {
+		metricsbp.M = prev
+	}(metricsbp.M)
+	metricsbp.M = st
+
+	kc := getTestMockConsumer(t)
+	for _, handlerErr := range []error{nil, errors.New("handler error")} {
+		msg := getTestKafkaMessage("key", "value")
+		msg.Topic = kc.cfg.Topic
+		kc.handleMessage(
+			msg,
+			func(context.Context, *sarama.ConsumerMessage) error {
+				return handlerErr
+			},
+			func(context.Context, error) {},
+		)
+	}
+
+	var sb strings.Builder
+	if _, err := st.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	stats := sb.String()
+	for _, expected := range []string{
+		"kafka.consumer.message.duration",
+		"kafka.consumer.messages.processed",
+		"kafka.consumer.messages.failed",
+	} {
+		if !strings.Contains(stats, expected) {
+			t.Errorf("expected %q, got %q", expected, stats)
+		}
+	}
+	if !strings.Contains(stats, "kafka.consumer.messages.processed,topic="+kc.cfg.Topic+":2.000000|c") {
+		t.Errorf("expected 2 processed messages, got %q", stats)
+	}
+	if !strings.Contains(stats, "kafka.consumer.messages.failed,topic="+kc.cfg.Topic+":1.000000|c") {
+		t.Errorf("expected 1 failed message, got %q", stats)
+	}
+}
+
+func TestKafkaConsumer_MetricsPrefix(t *testing.T) {
+	st := metricsbp.NewStatsd(context.Background(), metricsbp.StatsdConfig{})
+	defer func(prev *metricsbp.Statsd) {
+		metricsbp.M = prev
+	}(metricsbp.M)
+	metricsbp.M = st
+
+	kc := getTestMockConsumer(t)
+	kc.cfg.MetricsPrefix = "kafka.worker1"
+	msg := getTestKafkaMessage("key", "value")
+	msg.Topic = kc.cfg.Topic
+	kc.handleMessage(
+		msg,
+		func(context.Context, *sarama.ConsumerMessage) error {
+			return nil
+		},
+		func(context.Context, error) {},
+	)
+
+	var sb strings.Builder
+	if _, err := st.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	stats := sb.String()
+	if !strings.Contains(stats, "kafka.worker1.messages.processed,topic="+kc.cfg.Topic) {
+		t.Errorf("expected prefixed metric, got %q", stats)
+	}
+	if strings.Contains(stats, DefaultMetricsPrefix) {
+		t.Errorf("expected no metric with the default prefix, got %q", stats)
+	}
+}
+
+func TestKafkaConsumer_MetricsTags(t *testing.T) {
+	st := metricsbp.NewStatsd(context.Background(), metricsbp.StatsdConfig{})
+	defer func(prev *metricsbp.Statsd) {
+		metricsbp.M = prev
+	}(metricsbp.M)
+	metricsbp.M = st
+
+	kc := getTestMockConsumer(t)
+	kc.cfg.MetricsTags = func(m *sarama.ConsumerMessage) []string {
+		return []string{"event_type", string(m.Key)}
+	}
+	for _, key := range []string{"click", "view"} {
+		msg := getTestKafkaMessage(key, "value")
+		msg.Topic = kc.cfg.Topic
+		kc.handleMessage(
+			msg,
+			func(context.Context, *sarama.ConsumerMessage) error {
+				return errors.New("handler error")
+			},
+			func(context.Context, error) {},
+		)
+	}
+
+	var sb strings.Builder
+	if _, err := st.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	stats := sb.String()
+	for _, expected := range []string{
+		"kafka.consumer.messages.processed,topic=" + kc.cfg.Topic + ",event_type=click:1.000000|c",
+		"kafka.consumer.messages.processed,topic=" + kc.cfg.Topic + ",event_type=view:1.000000|c",
+		"kafka.consumer.messages.failed,topic=" + kc.cfg.Topic + ",event_type=click:1.000000|c",
+		"kafka.consumer.messages.failed,topic=" + kc.cfg.Topic + ",event_type=view:1.000000|c",
+		"kafka.consumer.handler.errors,topic=" + kc.cfg.Topic + ":2.000000|c",
+	} {
+		if !strings.Contains(stats, expected) {
+			t.Errorf("expected %q, got %q", expected, stats)
+		}
+	}
+}
+
+func TestKafkaConsumer_LastMessageTime(t *testing.T) {
+	kc := getTestMockConsumer(t)
+	kc.created = time.Now()
+	kc.cfg.StalenessThreshold = time.Millisecond * 10
+
+	if !kc.LastMessageTime().IsZero() {
+		t.Errorf("expected zero LastMessageTime, got %v", kc.LastMessageTime())
+	}
+	if !kc.IsHealthy() {
+		t.Error("expected consumer to be healthy before StalenessThreshold passed")
+	}
+
+	time.Sleep(kc.cfg.StalenessThreshold)
+	if kc.IsHealthy() {
+		t.Error("expected consumer to be unhealthy after StalenessThreshold passed without messages")
+	}
+
+	msg := getTestKafkaMessage("key", "value")
+	kc.handleMessage(
+		msg,
+		func(context.Context, *sarama.ConsumerMessage) error {
+			return errors.New("handler error")
+		},
+		func(context.Context, error) {},
+	)
+	if !kc.LastMessageTime().IsZero() {
+		t.Errorf("expected failed messages not to update LastMessageTime, got %v", kc.LastMessageTime())
+	}
+
+	kc.handleMessage(
+		msg,
+		func(context.Context, *sarama.ConsumerMessage) error {
+			return nil
+		},
+		func(context.Context, error) {},
+	)
+	if kc.LastMessageTime().IsZero() {
+		t.Error("expected LastMessageTime to be set")
+	}
+	if !kc.IsHealthy() || !kc.HealthyWithin(time.Minute) {
+		t.Error("expected consumer to be healthy after handling a message")
+	}
+}
+
+func TestKafkaConsumer_LogFailedPayloadBytes(t *testing.T) {
+	kc := getTestMockConsumer(t)
+	var logged []string
+	kc.cfg.Logger = func(_ context.Context, msg string) {
+		logged = append(logged, msg)
+	}
+	handlerErr := func(context.Context, *sarama.ConsumerMessage) error {
+		return errors.New("handler error")
+	}
+
+	kc.handleMessage(getTestKafkaMessage("key", "value"), handlerErr, func(context.Context, error) {})
+	if len(logged) != 0 {
+		t.Errorf("expected no logs when LogFailedPayloadBytes is 0, got %q", logged)
+	}
+
+	kc.cfg.LogFailedPayloadBytes = 2
+	kc.handleMessage(getTestKafkaMessage("key", "value"), handlerErr, func(context.Context, error) {})
+	if len(logged) != 1 {
+		t.Fatalf("expected 1 log, got %q", logged)
+	}
+	// hex of "va"
+	if !strings.Contains(logged[0], "payload[:2]=7661") {
+		t.Errorf("expected payload preview in log, got %q", logged[0])
+	}
+}
+
+func TestKafkaConsumer_Rebalance(t *testing.T) {
+	partitions := []int32{0, 1}
+	first := newFakeConsumer(partitions)
+	second := newFakeConsumer(partitions)
+
+	kc := getTestConsumer(t)
+	kc.consumer.Store(first)
+	kc.partitions.Store(partitions)
+	var resets int
+	kc.newSaramaConsumer = func([]string, *sarama.Config) (sarama.Consumer, error) {
+		resets++
+		return second, nil
+	}
+
+	consumed := make(chan *sarama.ConsumerMessage)
+	consumeErr := make(chan error, 1)
+	go func() {
+		consumeErr <- kc.Consume(
+			func(_ context.Context, msg *sarama.ConsumerMessage) error {
+				consumed <- msg
+				return nil
+			},
+			func(error) {},
+		)
+	}()
+
+	firstPCs := receivePartitionConsumers(t, first, len(partitions))
+	firstPCs[0].yield(5)
+	if msg := <-consumed; msg.Offset != 5 {
+		t.Errorf("expected offset 5 before rebalance, got %d", msg.Offset)
+	}
+
+	// Sarama closes the partition consumers' channels on rebalance.
+	first.rebalance()
+
+	secondPCs := receivePartitionConsumers(t, second, len(partitions))
+	if resets != 1 {
+		t.Errorf("expected the consumer to be reset once, got %d", resets)
+	}
+	if !first.isClosed() {
+		t.Error("expected the consumer before rebalance to be closed")
+	}
+	for _, pc := range secondPCs {
+		expected := kc.offset
+		if pc.partition == firstPCs[0].partition {
+			expected = 6
+		}
+		if pc.offset != expected {
+			t.Errorf("partition %d: expected to resume from offset %d, got %d", pc.partition, expected, pc.offset)
+		}
+	}
+
+	secondPCs[1].yield(0)
+	if msg := <-consumed; msg.Partition != secondPCs[1].partition {
+		t.Errorf("expected message from partition %d after rebalance, got %d", secondPCs[1].partition, msg.Partition)
+	}
+
+	if err := kc.Close(); err != nil {
+		t.Errorf("Close returned error: %v", err)
+	}
+	if err := <-consumeErr; err != nil {
+		t.Errorf("Consume returned error: %v", err)
+	}
+}
+
+func TestKafkaConsumer_PartitionsMetrics(t *testing.T) {
+	st := metricsbp.NewStatsd(context.Background(), metricsbp.StatsdConfig{})
+	defer func(prev *metricsbp.Statsd) {
+		metricsbp.M = prev
+	}(metricsbp.M)
+	metricsbp.M = st
+
+	kc := getTestConsumer(t)
+	kc.reportPartitions(4)
+	kc.reportPartitions(4)
+	kc.reportPartitions(2)
+
+	var sb strings.Builder
+	if _, err := st.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	stats := sb.String()
+	if !strings.Contains(stats, "kafka.consumer.partitions.active,topic="+kc.cfg.Topic+":2.000000|g") {
+		t.Errorf("expected 2 active partitions, got %q", stats)
+	}
+	if !strings.Contains(stats, "kafka.consumer.partitions.changed,topic="+kc.cfg.Topic+":1.000000|c") {
+		t.Errorf("expected 1 partition count change, got %q", stats)
+	}
+}
+
+func TestKafkaConsumer_ResetRetry(t *testing.T) {
+	for _, c := range []struct {
+		label    string
+		errs     []error
+		attempts int
+		err      error
+	}{
+		{
+			label:    "transient",
+			errs:     []error{sarama.ErrOutOfBrokers, sarama.ErrOutOfBrokers},
+			attempts: 3,
+		},
+		{
+			label:    "permanent",
+			errs:     []error{sarama.ErrTopicAuthorizationFailed},
+			attempts: 1,
+			err:      sarama.ErrTopicAuthorizationFailed,
+		},
+		{
+			label:    "exhausted",
+			errs:     []error{sarama.ErrOutOfBrokers, sarama.ErrOutOfBrokers, sarama.ErrOutOfBrokers, sarama.ErrOutOfBrokers},
+			attempts: 3,
+			err:      sarama.ErrOutOfBrokers,
+		},
+	} {
+		t.Run(c.label, func(t *testing.T) {
+			kc := getTestConsumer(t)
+			kc.cfg.ResetMaxAttempts = 3
+			kc.cfg.ResetMaxBackoff = time.Millisecond
+			attempts := 0
+			kc.newSaramaConsumer = func([]string, *sarama.Config) (sarama.Consumer, error) {
+				attempts++
+				if attempts <= len(c.errs) {
+					return nil, c.errs[attempts-1]
+				}
+				return newFakeConsumer([]int32{0}), nil
+			}
+
+			err := kc.reset(kc.resetRetryPolicy())
+			if !errors.Is(err, c.err) {
+				t.Errorf("expected error %v, got %v", c.err, err)
+			}
+			if attempts != c.attempts {
+				t.Errorf("expected %d attempts, got %d", c.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestKafkaConsumer_ShutdownDuringReset(t *testing.T) {
+	kc := getTestConsumer(t)
+	kc.cfg.ResetMaxAttempts = 3
+	kc.cfg.ResetMaxBackoff = time.Minute
+	fake := newFakeConsumer([]int32{0})
+	kc.consumer.Store(fake)
+	attempted := make(chan struct{}, 1)
+	kc.newSaramaConsumer = func([]string, *sarama.Config) (sarama.Consumer, error) {
+		attempted <- struct{}{}
+		return nil, sarama.ErrOutOfBrokers
+	}
+
+	reset := make(chan error, 1)
+	go func() {
+		reset <- kc.reset(kc.resetRetryPolicy())
+	}()
+	<-attempted
+
+	// reset is backing off after closing fake, so it's not closed again.
+	if err := kc.Shutdown(context.Background()); err != nil {
+		t.Errorf("expected Shutdown to succeed, got %v", err)
+	}
+	select {
+	case err := <-reset:
+		if !errors.Is(err, sarama.ErrOutOfBrokers) {
+			t.Errorf("expected error %v, got %v", sarama.ErrOutOfBrokers, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected reset to stop retrying after Shutdown")
+	}
+	if !fake.isClosed() {
+		t.Error("expected the consumer to be closed")
+	}
+}
+
+func TestKafkaConsumer_MinPartitions(t *testing.T) {
+	for _, c := range []struct {
+		label         string
+		partitions    []int32
+		minPartitions int
+		err           error
+	}{
+		{
+			label: "empty",
+			err:   ErrTooFewPartitions,
+		},
+		{
+			label:         "too-few",
+			partitions:    []int32{0, 1},
+			minPartitions: 3,
+			err:           ErrTooFewPartitions,
+		},
+		{
+			label:         "enough",
+			partitions:    []int32{0, 1, 2},
+			minPartitions: 3,
+		},
+	} {
+		t.Run(c.label, func(t *testing.T) {
+			kc := getTestConsumer(t)
+			kc.cfg.MinPartitions = c.minPartitions
+			fake := newFakeConsumer(c.partitions)
+			kc.newSaramaConsumer = func([]string, *sarama.Config) (sarama.Consumer, error) {
+				return fake, nil
+			}
+
+			err := kc.reset(RetryPolicy{})
+			if !errors.Is(err, c.err) {
+				t.Errorf("expected error %v, got %v", c.err, err)
+			}
+			if err != nil && !fake.isClosed() {
+				t.Error("expected the consumer to be closed on error")
+			}
+		})
+	}
+}
+
+func TestKafkaConsumer_Seek(t *testing.T) {
+	partitions := []int32{0, 1}
+	fake := newFakeConsumer(partitions)
+	fake.created = make(chan *fakeRebalancePartitionConsumer, 3)
+
+	kc := getTestConsumer(t)
+	kc.consumer.Store(fake)
+	kc.partitions.Store(partitions)
+
+	if err := kc.Seek(0, 100); !errors.Is(err, ErrPartitionNotConsumed) {
+		t.Errorf("expected error %v before consuming, got %v", ErrPartitionNotConsumed, err)
+	}
+
+	consumed := make(chan *sarama.ConsumerMessage)
+	go func() {
+		kc.Consume(
+			func(_ context.Context, msg *sarama.ConsumerMessage) error {
+				consumed <- msg
+				return nil
+			},
+			func(error) {},
+		)
+	}()
+
+	pcs := receivePartitionConsumers(t, fake, len(partitions))
+	pc := pcs[0]
+	pc.yield(1)
+	if msg := <-consumed; msg.Offset != 1 {
+		t.Fatalf("expected offset 1, got %d", msg.Offset)
+	}
+	// 2 will be in-flight and 3 will be buffered when Seek is called.
+	pc.yield(2)
+	pc.yield(3)
+	time.Sleep(time.Millisecond * 10)
+
+	if err := kc.Seek(pc.partition, 100); err != nil {
+		t.Fatalf("Seek returned error: %v", err)
+	}
+	if msg := <-consumed; msg.Offset != 2 {
+		t.Errorf("expected in-flight offset 2, got %d", msg.Offset)
+	}
+
+	seeked := receivePartitionConsumers(t, fake, 1)[0]
+	if seeked.partition != pc.partition || seeked.offset != 100 {
+		t.Errorf("expected partition %d to be recreated at offset 100, got partition %d offset %d", pc.partition, seeked.partition, seeked.offset)
+	}
+	seeked.yield(100)
+	if msg := <-consumed; msg.Offset != 100 {
+		t.Errorf("expected offset 100 after seek (buffered offset 3 discarded), got %d", msg.Offset)
+	}
+
+	if err := kc.Close(); err != nil {
+		t.Errorf("Close returned error: %v", err)
+	}
+}
+
+func TestKafkaConsumer_SaramaConsumer(t *testing.T) {
+	fake := newFakeConsumer([]int32{0})
+	kc := getTestConsumer(t)
+	kc.consumer.Store(fake)
+
+	var c Consumer = kc
+	accessor, ok := c.(SaramaConsumerAccessor)
+	if !ok {
+		t.Fatal("expected consumer to implement SaramaConsumerAccessor")
+	}
+	if accessor.SaramaConsumer() != fake {
+		t.Errorf("expected the current sarama consumer %v, got %v", fake, accessor.SaramaConsumer())
+	}
+}
+
+func TestKafkaConsumer_Offsets(t *testing.T) {
+	partitions := []int32{0, 1}
+	fake := newFakeConsumer(partitions)
+	fake.created = make(chan *fakeRebalancePartitionConsumer, 2)
+
+	kc := getTestConsumer(t)
+	kc.consumer.Store(fake)
+	kc.partitions.Store(partitions)
+
+	consumed := make(chan *sarama.ConsumerMessage)
+	go func() {
+		kc.Consume(
+			func(_ context.Context, msg *sarama.ConsumerMessage) error {
+				consumed <- msg
+				return nil
+			},
+			func(error) {},
+		)
+	}()
+
+	pcs := receivePartitionConsumers(t, fake, len(partitions))
+	pc := pcs[0]
+	pc.yield(5)
+	<-consumed
+	pc.yield(6)
+	<-consumed
+	// The offset is committed right after the handler returns.
+	time.Sleep(time.Millisecond * 10)
+
+	offsets := kc.Offsets()
+	if len(offsets) != len(partitions) {
+		t.Errorf("expected offsets of %d partitions, got %v", len(partitions), offsets)
+	}
+	if offsets[pc.partition] != 7 {
+		t.Errorf("expected offset 7 for partition %d, got %v", pc.partition, offsets)
+	}
+	other := pcs[1].partition
+	if offsets[other] != kc.offset {
+		t.Errorf("expected offset %d for partition %d, got %v", kc.offset, other, offsets)
+	}
+
+	hwms := kc.HighWaterMarks()
+	if hwms[pc.partition] != 7 || hwms[other] != 0 {
+		t.Errorf("expected high water marks map[%d:7 %d:0], got %v", pc.partition, other, hwms)
+	}
+
+	if err := kc.Close(); err != nil {
+		t.Errorf("Close returned error: %v", err)
+	}
+}
+
+func TestNewConsumerFromClient(t *testing.T) {
+	const topic = "kafkabp-test"
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(topic, 0, broker.BrokerID()).
+			SetLeader(topic, 1, broker.BrokerID()),
+	})
+
+	cfg := ConsumerConfig{
+		Brokers:  []string{broker.Addr()},
+		Topic:    topic,
+		ClientID: "test-shared-client",
+	}
+	sc, err := cfg.NewSaramaConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := sarama.NewClient(cfg.Brokers, sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		c, err := NewConsumerFromClient(client, cfg)
+		if err != nil {
+			t.Fatalf("#%d: unexpected error: %v", i, err)
+		}
+		kc := c.(*consumer)
+		if got, expected := kc.getPartitions(), []int32{0, 1}; !reflect.DeepEqual(got, expected) {
+			t.Errorf("#%d: expected partitions %v, got %v", i, expected, got)
+		}
+		if err := c.Close(); err != nil {
+			t.Errorf("#%d: unexpected error closing the consumer: %v", i, err)
+		}
+		if client.Closed() {
+			t.Fatalf("#%d: expected the shared client to stay open after closing the consumer", i)
+		}
+	}
+
+	client.Close()
+	if _, err := NewConsumerFromClient(client, cfg); !errors.Is(err, sarama.ErrClosedClient) {
+		t.Errorf("expected error %v, got %v", sarama.ErrClosedClient, err)
+	}
+}
+
+func TestKafkaConsumer_OffsetOutOfRange(t *testing.T) {
+	partitions := []int32{0}
+	fake := newFakeConsumer(partitions)
+	fake.created = make(chan *fakeRebalancePartitionConsumer, 2)
+
+	kc := getTestConsumer(t)
+	kc.consumer.Store(fake)
+	kc.partitions.Store(partitions)
+
+	consumed := make(chan *sarama.ConsumerMessage)
+	var errLock sync.Mutex
+	var consumedErrs []error
+	go func() {
+		kc.Consume(
+			func(_ context.Context, msg *sarama.ConsumerMessage) error {
+				consumed <- msg
+				return nil
+			},
+			func(err error) {
+				errLock.Lock()
+				defer errLock.Unlock()
+				consumedErrs = append(consumedErrs, err)
+			},
+		)
+	}()
+
+	pc := receivePartitionConsumers(t, fake, 1)[0]
+	pc.yield(5)
+	<-consumed
+	pc.errors <- &sarama.ConsumerError{
+		Topic:     pc.topic,
+		Partition: pc.partition,
+		Err:       sarama.ErrOffsetOutOfRange,
+	}
+
+	recreated := receivePartitionConsumers(t, fake, 1)[0]
+	if recreated.partition != pc.partition || recreated.offset != kc.offset {
+		t.Errorf(
+			"expected partition %d to be recreated at offset %d, got partition %d offset %d",
+			pc.partition,
+			kc.offset,
+			recreated.partition,
+			recreated.offset,
+		)
+	}
+	recreated.yield(1)
+	if msg := <-consumed; msg.Offset != 1 {
+		t.Errorf("expected offset 1 after reset, got %d", msg.Offset)
+	}
+
+	if err := kc.Close(); err != nil {
+		t.Errorf("Close returned error: %v", err)
+	}
+	errLock.Lock()
+	defer errLock.Unlock()
+	if len(consumedErrs) != 0 {
+		t.Errorf("expected ErrOffsetOutOfRange not to be passed to errorsFunc, got %v", consumedErrs)
+	}
+}
+
 // Helper functions
 
-func getTestMockConsumer(t *testing.T) *consumer {
+func receivePartitionConsumers(t *testing.T, c *fakeConsumer, n int) []*fakeRebalancePartitionConsumer {
+	t.Helper()
+
+	pcs := make([]*fakeRebalancePartitionConsumer, 0, n)
+	for len(pcs) < n {
+		select {
+		case pc := <-c.created:
+			pcs = append(pcs, pc)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for partition consumers, got %d/%d", len(pcs), n)
+		}
+	}
+	return pcs
+}
+
+// fakePartitionConsumer is a sarama.PartitionConsumer that delivers the
+// messages sent to its messages channel as-is.
+type fakePartitionConsumer struct {
+	sarama.PartitionConsumer
+
+	messages chan *sarama.ConsumerMessage
+}
+
+func (pc fakePartitionConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return pc.messages
+}
+
+// getTestConsumer returns a consumer without the sarama consumer and
+// partitions set.
+func getTestConsumer(t *testing.T) *consumer {
 	cfg := ConsumerConfig{
 		Brokers:  []string{"127.0.0.1:9090", "127.0.0.2:9090"},
 		Topic:    "kafkabp-test",
@@ -133,12 +1439,17 @@ func getTestMockConsumer(t *testing.T) *consumer {
 	}
 
 	sc, _ := cfg.NewSaramaConfig()
-	c := &consumer{
-		cfg:    cfg,
-		sc:     sc,
-		offset: sc.Consumer.Offsets.Initial,
+	return &consumer{
+		cfg:     cfg,
+		sc:      sc,
+		offset:  sc.Consumer.Offsets.Initial,
+		limiter: newRateLimiter(0, 0),
 	}
-	consumer, partitions := createMockConsumer(t, cfg.Topic)
+}
+
+func getTestMockConsumer(t *testing.T) *consumer {
+	c := getTestConsumer(t)
+	consumer, partitions := createMockConsumer(t, c.cfg.Topic)
 	c.consumer.Store(consumer)
 	c.partitions.Store(partitions)
 	return c
@@ -0,0 +1,96 @@
+package kafkabp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/reddit/baseplate.go/log"
+	"github.com/reddit/baseplate.go/metricsbp"
+)
+
+// HeaderDeadLetterReason is the key of the kafka record header carrying the
+// error that caused a message to be dead-lettered.
+const HeaderDeadLetterReason = "x-dlq-reason"
+
+// DeadLetterConfig configures WithDeadLetter.
+type DeadLetterConfig struct {
+	// Required. The producer used to publish the dead-lettered messages.
+	Producer sarama.SyncProducer
+
+	// Required. The topic to publish the dead-lettered messages to.
+	Topic string
+
+	// Optional. Defaults to DefaultLogger. Used to log the errors publishing the
+	// dead-lettered messages.
+	Logger log.Wrapper
+
+	// Optional. Defaults to DefaultMetricsPrefix. The prefix of the names of
+	// the deadletter.success and deadletter.failure metrics, usually the same
+	// as the ConsumerConfig.MetricsPrefix of the consumer.
+	MetricsPrefix string
+}
+
+// WithDeadLetter wraps fn so that when it returns an error, the message is
+// republished to cfg.Topic with its key, value and headers, plus the
+// HeaderDeadLetterReason header containing the error.
+//
+// The value is the one passed to fn, so it's already decoded when
+// ConsumerConfig.PayloadCodec is set.
+//
+// When the message is dead-lettered successfully, the returned
+// ConsumeMessageFunc returns nil. When publishing to the dead letter topic
+// fails, that failure is logged and counted, and the original error is
+// returned.
+//
+// It's composable with WithRetry, for example:
+//
+//     kafkabp.WithDeadLetter(kafkabp.WithRetry(fn, policy), dlqConfig)
+func WithDeadLetter(fn ConsumeMessageFunc, cfg DeadLetterConfig) ConsumeMessageFunc {
+	prefix := cfg.MetricsPrefix
+	if prefix == "" {
+		prefix = DefaultMetricsPrefix
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = DefaultLogger
+	}
+	return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		err := fn(ctx, msg)
+		if err == nil {
+			return nil
+		}
+
+		dlqMsg := &sarama.ProducerMessage{
+			Topic: cfg.Topic,
+			Value: sarama.ByteEncoder(msg.Value),
+		}
+		if msg.Key != nil {
+			dlqMsg.Key = sarama.ByteEncoder(msg.Key)
+		}
+		for _, h := range msg.Headers {
+			if h != nil && string(h.Key) != HeaderDeadLetterReason {
+				dlqMsg.Headers = append(dlqMsg.Headers, *h)
+			}
+		}
+		dlqMsg.Headers = append(dlqMsg.Headers, sarama.RecordHeader{
+			Key:   []byte(HeaderDeadLetterReason),
+			Value: []byte(err.Error()),
+		})
+
+		if _, _, dlqErr := cfg.Producer.SendMessage(dlqMsg); dlqErr != nil {
+			metricsbp.M.Counter(prefix+".deadletter.failure").With("topic", cfg.Topic).Add(1)
+			cfg.Logger.Log(ctx, fmt.Sprintf(
+				"kafkabp: Error publishing message from topic %q partition %d offset %d to dead letter topic %q: %v",
+				msg.Topic,
+				msg.Partition,
+				msg.Offset,
+				cfg.Topic,
+				dlqErr,
+			))
+			return err
+		}
+		metricsbp.M.Counter(prefix+".deadletter.success").With("topic", cfg.Topic).Add(1)
+		return nil
+	}
+}
@@ -0,0 +1,183 @@
+package kafkabp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+
+	"github.com/reddit/baseplate.go/log"
+	"github.com/reddit/baseplate.go/metricsbp"
+)
+
+func TestWithDeadLetter(t *testing.T) {
+	handlerErr := errors.New("handler error")
+	failing := func(context.Context, *sarama.ConsumerMessage) error {
+		return handlerErr
+	}
+	newMessage := func() *sarama.ConsumerMessage {
+		msg := getTestKafkaMessage("key", "value")
+		msg.Headers = []*sarama.RecordHeader{
+			{Key: []byte("foo"), Value: []byte("bar")},
+		}
+		return msg
+	}
+
+	t.Run("success", func(t *testing.T) {
+		producer := mocks.NewSyncProducer(t, nil)
+		defer producer.Close()
+		called := false
+		fn := WithDeadLetter(
+			func(context.Context, *sarama.ConsumerMessage) error {
+				called = true
+				return nil
+			},
+			DeadLetterConfig{Producer: producer, Topic: "dlq"},
+		)
+		if err := fn(context.Background(), newMessage()); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+		if !called {
+			t.Error("expected the wrapped function to be called")
+		}
+	})
+
+	t.Run("dead-lettered", func(t *testing.T) {
+		producer := mocks.NewSyncProducer(t, nil)
+		defer producer.Close()
+		var sent *sarama.ProducerMessage
+		producer.ExpectSendMessageWithCheckerFunctionAndSucceed(func(val []byte) error {
+			if string(val) != "value" {
+				return errors.New("unexpected value " + string(val))
+			}
+			return nil
+		})
+		fn := WithDeadLetter(failing, DeadLetterConfig{
+			Producer: recordingSyncProducer{SyncProducer: producer, sent: &sent},
+			Topic:    "dlq",
+		})
+		if err := fn(context.Background(), newMessage()); err != nil {
+			t.Errorf("expected no error after dead-lettering, got %v", err)
+		}
+
+		if sent == nil {
+			t.Fatal("expected a message to be dead-lettered")
+		}
+		if sent.Topic != "dlq" {
+			t.Errorf("expected topic %q, got %q", "dlq", sent.Topic)
+		}
+		if key, _ := sent.Key.Encode(); string(key) != "key" {
+			t.Errorf("expected key %q, got %q", "key", key)
+		}
+		headers := make(map[string]string)
+		for _, h := range sent.Headers {
+			headers[string(h.Key)] = string(h.Value)
+		}
+		if headers["foo"] != "bar" {
+			t.Errorf("expected the original headers to be kept, got %v", headers)
+		}
+		if headers[HeaderDeadLetterReason] != handlerErr.Error() {
+			t.Errorf("expected reason %q, got %v", handlerErr.Error(), headers)
+		}
+	})
+
+	t.Run("publish-failure", func(t *testing.T) {
+		producer := mocks.NewSyncProducer(t, nil)
+		defer producer.Close()
+		producer.ExpectSendMessageAndFail(errors.New("dlq error"))
+		var logged []string
+		fn := WithDeadLetter(failing, DeadLetterConfig{
+			Producer: producer,
+			Topic:    "dlq",
+			Logger: func(_ context.Context, msg string) {
+				logged = append(logged, msg)
+			},
+		})
+		if err := fn(context.Background(), newMessage()); !errors.Is(err, handlerErr) {
+			t.Errorf("expected error %v, got %v", handlerErr, err)
+		}
+		if len(logged) != 1 {
+			t.Errorf("expected 1 log, got %q", logged)
+		}
+	})
+
+	t.Run("default-logger", func(t *testing.T) {
+		defer func(prev log.Wrapper) {
+			DefaultLogger = prev
+		}(DefaultLogger)
+		var logged []string
+		DefaultLogger = func(_ context.Context, msg string) {
+			logged = append(logged, msg)
+		}
+
+		producer := mocks.NewSyncProducer(t, nil)
+		defer producer.Close()
+		producer.ExpectSendMessageAndFail(errors.New("dlq error"))
+		fn := WithDeadLetter(failing, DeadLetterConfig{
+			Producer: producer,
+			Topic:    "dlq",
+		})
+		if err := fn(context.Background(), newMessage()); !errors.Is(err, handlerErr) {
+			t.Errorf("expected error %v, got %v", handlerErr, err)
+		}
+		if len(logged) != 1 {
+			t.Errorf("expected 1 log to DefaultLogger, got %q", logged)
+		}
+	})
+}
+
+func TestWithDeadLetter_MetricsPrefix(t *testing.T) {
+	st := metricsbp.NewStatsd(context.Background(), metricsbp.StatsdConfig{})
+	defer func(prev *metricsbp.Statsd) {
+		metricsbp.M = prev
+	}(metricsbp.M)
+	metricsbp.M = st
+
+	failing := func(context.Context, *sarama.ConsumerMessage) error {
+		return errors.New("handler error")
+	}
+	producer := mocks.NewSyncProducer(t, nil)
+	defer producer.Close()
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndFail(errors.New("dlq error"))
+	fn := WithDeadLetter(failing, DeadLetterConfig{
+		Producer:      producer,
+		Topic:         "dlq",
+		MetricsPrefix: "kafka.worker1",
+	})
+	for i := 0; i < 2; i++ {
+		fn(context.Background(), getTestKafkaMessage("key", "value"))
+	}
+
+	var sb strings.Builder
+	if _, err := st.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	stats := sb.String()
+	for _, name := range []string{
+		"kafka.worker1.deadletter.success,topic=dlq",
+		"kafka.worker1.deadletter.failure,topic=dlq",
+	} {
+		if !strings.Contains(stats, name) {
+			t.Errorf("expected metric %q, got %q", name, stats)
+		}
+	}
+	if strings.Contains(stats, DefaultMetricsPrefix) {
+		t.Errorf("expected no metric with the default prefix, got %q", stats)
+	}
+}
+
+// recordingSyncProducer records the last message sent.
+type recordingSyncProducer struct {
+	sarama.SyncProducer
+
+	sent **sarama.ProducerMessage
+}
+
+func (p recordingSyncProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	*p.sent = msg
+	return p.SyncProducer.SendMessage(msg)
+}
@@ -0,0 +1,61 @@
+package kafkabp
+
+import (
+	"time"
+)
+
+type drainMode int
+
+const (
+	drainAll drainMode = iota
+	drainNone
+	drainTimeout
+)
+
+// DrainPolicy decides what happens to the messages already fetched from the
+// brokers, but not handled yet, when the consumer is closed.
+//
+// The messages discarded are not handled, so they never advance the committed
+// offset: the group consumer leaves them unmarked so they are delivered again
+// after a restart or rebalance, and Offsets of the consumer created by
+// NewConsumer stays at the first message discarded of each partition.
+// DrainAll is needed for at-least-once delivery of those messages without
+// relying on redelivery, while DrainNone closes the consumer faster.
+//
+// The zero value is DrainAll.
+type DrainPolicy struct {
+	mode    drainMode
+	timeout time.Duration
+}
+
+var (
+	// DrainAll handles all the buffered messages before Close returns, which
+	// is the default.
+	DrainAll = DrainPolicy{mode: drainAll}
+
+	// DrainNone discards all the buffered messages, only waiting for the
+	// messages already being handled.
+	DrainNone = DrainPolicy{mode: drainNone}
+)
+
+// DrainTimeout returns a DrainPolicy that handles the buffered messages until
+// d after the consumer is closed, and discards the remaining ones.
+func DrainTimeout(d time.Duration) DrainPolicy {
+	return DrainPolicy{
+		mode:    drainTimeout,
+		timeout: d,
+	}
+}
+
+// drains returns true if a buffered message should still be handled, when the
+// consumer was closed at closed.
+func (p DrainPolicy) drains(closed time.Time) bool {
+	switch p.mode {
+	case drainNone:
+		return false
+	case drainTimeout:
+		return time.Since(closed) < p.timeout
+	default:
+		return true
+	}
+}
@@ -0,0 +1,56 @@
+package kafkabp
+
+import (
+	"context"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/reddit/baseplate.go/edgecontext"
+)
+
+// HeaderEdgeRequest is the key of the kafka record header used to carry the
+// serialized edge request context across kafka.
+const HeaderEdgeRequest = "Edge-Request"
+
+// AttachEdgeRequestContext sets the header of the EdgeRequestContext attached
+// to ctx, if any, as the HeaderEdgeRequest header of msg, replacing any
+// existing one.
+//
+// Consumers with ConsumerConfig.EdgeContextImpl set will parse it and attach
+// it to the context passed to their ConsumeMessageFunc.
+func AttachEdgeRequestContext(ctx context.Context, msg *sarama.ProducerMessage) {
+	ec, ok := edgecontext.GetEdgeContext(ctx)
+	if !ok || ec == nil {
+		return
+	}
+	setProducerHeader(msg, HeaderEdgeRequest, ec.Header())
+}
+
+// edgeContextFromMessage returns a context with the EdgeRequestContext parsed
+// from the HeaderEdgeRequest header of m attached.
+//
+// ctx is returned unchanged if cfg.EdgeContextImpl is nil, m doesn't have the
+// header, or the header fails to parse (in which case the error is logged).
+func (kc *consumer) edgeContextFromMessage(ctx context.Context, m *sarama.ConsumerMessage) context.Context {
+	if kc.cfg.EdgeContextImpl == nil {
+		return ctx
+	}
+
+	var header string
+	for _, h := range m.Headers {
+		if h != nil && string(h.Key) == HeaderEdgeRequest {
+			header = string(h.Value)
+			break
+		}
+	}
+	if header == "" {
+		return ctx
+	}
+
+	ec, err := edgecontext.FromHeader(ctx, header, kc.cfg.EdgeContextImpl)
+	if err != nil {
+		kc.cfg.Logger.Log(ctx, "kafkabp.consumer: Error while parsing EdgeRequestContext: "+err.Error())
+		return ctx
+	}
+	return edgecontext.SetEdgeContext(ctx, ec)
+}
@@ -0,0 +1,114 @@
+package kafkabp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/reddit/baseplate.go/edgecontext"
+	"github.com/reddit/baseplate.go/secrets"
+)
+
+// copied from edgecontext tests: a header with no auth token and no device id.
+const headerWithNoAuthNoDevice = "\x0c\x00\x01\x0b\x00\x01\x00\x00\x00\x0bt2_deadbeef\n\x00\x02\x00\x00\x00\x00\x00\x01\x86\xa0\x00\x0c\x00\x02\x0b\x00\x01\x00\x00\x00\x08beefdead\x00\x00"
+
+func newTestEdgeContextImpl(t *testing.T) *edgecontext.Impl {
+	t.Helper()
+
+	store, _, err := secrets.NewTestSecrets(
+		context.Background(),
+		make(map[string]secrets.GenericSecret),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+	})
+	return edgecontext.Init(edgecontext.Config{Store: store})
+}
+
+func TestAttachEdgeRequestContext(t *testing.T) {
+	impl := newTestEdgeContextImpl(t)
+
+	t.Run("no-edge-context", func(t *testing.T) {
+		msg := &sarama.ProducerMessage{}
+		AttachEdgeRequestContext(context.Background(), msg)
+		if len(msg.Headers) != 0 {
+			t.Errorf("expected no headers, got %v", msg.Headers)
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		ec, err := edgecontext.FromHeader(context.Background(), headerWithNoAuthNoDevice, impl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := edgecontext.SetEdgeContext(context.Background(), ec)
+		msg := &sarama.ProducerMessage{
+			Headers: []sarama.RecordHeader{
+				{Key: []byte("foo"), Value: []byte("bar")},
+				{Key: []byte(HeaderEdgeRequest), Value: []byte("stale")},
+			},
+		}
+		AttachEdgeRequestContext(ctx, msg)
+		if len(msg.Headers) != 2 {
+			t.Fatalf("expected 2 headers, got %v", msg.Headers)
+		}
+		if got := string(msg.Headers[1].Value); got != headerWithNoAuthNoDevice {
+			t.Errorf("expected header %q, got %q", headerWithNoAuthNoDevice, got)
+		}
+	})
+}
+
+func TestKafkaConsumer_EdgeContext(t *testing.T) {
+	impl := newTestEdgeContextImpl(t)
+	kc := getTestMockConsumer(t)
+	kc.cfg.EdgeContextImpl = impl
+	var logged []string
+	kc.cfg.Logger = func(_ context.Context, msg string) {
+		logged = append(logged, msg)
+	}
+
+	var header string
+	var found bool
+	handler := func(ctx context.Context, _ *sarama.ConsumerMessage) error {
+		header = ""
+		var ec *edgecontext.EdgeRequestContext
+		ec, found = edgecontext.GetEdgeContext(ctx)
+		if found {
+			header = ec.Header()
+		}
+		return nil
+	}
+
+	msg := getTestKafkaMessage("key", "value")
+	msg.Headers = []*sarama.RecordHeader{
+		{Key: []byte(HeaderEdgeRequest), Value: []byte(headerWithNoAuthNoDevice)},
+	}
+	kc.handleMessage(msg, handler, func(context.Context, error) {})
+	if !found {
+		t.Fatal("expected edge context in handler context")
+	}
+	if header != headerWithNoAuthNoDevice {
+		t.Errorf("expected header %q, got %q", headerWithNoAuthNoDevice, header)
+	}
+
+	kc.handleMessage(getTestKafkaMessage("key", "value"), handler, func(context.Context, error) {})
+	if found {
+		t.Error("expected no edge context without the header")
+	}
+
+	msg = getTestKafkaMessage("key", "value")
+	msg.Headers = []*sarama.RecordHeader{
+		{Key: []byte(HeaderEdgeRequest), Value: []byte("garbage")},
+	}
+	kc.handleMessage(msg, handler, func(context.Context, error) {})
+	if found {
+		t.Error("expected no edge context with a malformed header")
+	}
+	if len(logged) != 1 {
+		t.Errorf("expected 1 log for the malformed header, got %q", logged)
+	}
+}
@@ -0,0 +1,78 @@
+package kafkabp
+
+import (
+	"context"
+)
+
+// errorQueueSize is the number of errors buffered by errorQueue.
+const errorQueueSize = 256
+
+// queuedError is an error buffered by errorQueue along with its context.
+type queuedError struct {
+	ctx context.Context
+	err error
+}
+
+// errorQueue passes the errors drained from the errors channels of sarama to
+// a ConsumeErrorContextFunc from a separate goroutine, so a slow
+// ConsumeErrorContextFunc never blocks the draining, which in turn would block
+// the partition consumers of sarama.
+//
+// The errors overflowing the buffer are dropped, and dropped is called for
+// each of them.
+type errorQueue struct {
+	errors  chan queuedError
+	done    chan struct{}
+	stopped chan struct{}
+	dropped func(err error)
+}
+
+// newErrorQueue creates an errorQueue calling errorsFunc, and starts its
+// goroutine, which runs until stop is called.
+func newErrorQueue(errorsFunc ConsumeErrorContextFunc, dropped func(err error)) *errorQueue {
+	q := &errorQueue{
+		errors:  make(chan queuedError, errorQueueSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		dropped: dropped,
+	}
+	go func() {
+		defer close(q.stopped)
+		for {
+			select {
+			case e := <-q.errors:
+				errorsFunc(e.ctx, e.err)
+			case <-q.done:
+				// Flush the errors already buffered before exiting.
+				for {
+					select {
+					case e := <-q.errors:
+						errorsFunc(e.ctx, e.err)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return q
+}
+
+// add queues err to be passed to the ConsumeErrorContextFunc without
+// blocking.
+func (q *errorQueue) add(ctx context.Context, err error) {
+	select {
+	case q.errors <- queuedError{ctx: ctx, err: err}:
+	default:
+		q.dropped(err)
+	}
+}
+
+// stop stops the goroutine of q, and waits for the buffered errors to be
+// passed to the ConsumeErrorContextFunc. The errors added after stop are lost.
+//
+// It must be called exactly once.
+func (q *errorQueue) stop() {
+	close(q.done)
+	<-q.stopped
+}
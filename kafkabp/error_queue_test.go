@@ -0,0 +1,85 @@
+package kafkabp
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestErrorQueue(t *testing.T) {
+	t.Run("slow-errors-func", func(t *testing.T) {
+		unblock := make(chan struct{})
+		var handled, dropped int64
+		q := newErrorQueue(
+			func(context.Context, error) {
+				<-unblock
+				atomic.AddInt64(&handled, 1)
+			},
+			func(error) {
+				atomic.AddInt64(&dropped, 1)
+			},
+		)
+
+		// One error is taken by the blocked errors func, the buffer holds
+		// errorQueueSize, and the rest are dropped.
+		const total = errorQueueSize + 10
+		added := make(chan struct{})
+		go func() {
+			defer close(added)
+			for i := 0; i < total; i++ {
+				q.add(context.Background(), errors.New("error"))
+				if i == 0 {
+					// Make sure the first error is taken off the buffer.
+					for len(q.errors) > 0 {
+						time.Sleep(time.Millisecond)
+					}
+				}
+			}
+		}()
+		select {
+		case <-added:
+		case <-time.After(time.Second):
+			t.Fatal("add blocked by the slow errors func")
+		}
+
+		close(unblock)
+		q.stop()
+		if got, expected := atomic.LoadInt64(&dropped), int64(total-errorQueueSize-1); got != expected {
+			t.Errorf("expected %d errors dropped, got %d", expected, got)
+		}
+		if got, expected := atomic.LoadInt64(&handled), int64(errorQueueSize+1); got != expected {
+			t.Errorf("expected %d errors handled after stop, got %d", expected, got)
+		}
+	})
+
+	t.Run("context", func(t *testing.T) {
+		type key struct{}
+		ctx := context.WithValue(context.Background(), key{}, "value")
+		errs := make(chan error, 1)
+		q := newErrorQueue(
+			func(ctx context.Context, err error) {
+				if ctx.Value(key{}) != "value" {
+					t.Errorf("expected the context of the error, got %v", ctx)
+				}
+				errs <- err
+			},
+			func(err error) {
+				t.Errorf("unexpected dropped error: %v", err)
+			},
+		)
+		defer q.stop()
+
+		expected := errors.New("error")
+		q.add(ctx, expected)
+		select {
+		case err := <-errs:
+			if err != expected {
+				t.Errorf("expected error %v, got %v", expected, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the error")
+		}
+	})
+}
@@ -0,0 +1,271 @@
+package kafkabp
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Shopify/sarama"
+)
+
+// fakeConsumer is a sarama.Consumer test double that can simulate rebalances
+// by closing the channels of all its partition consumers on demand, the same
+// way sarama does when partitions are reassigned.
+type fakeConsumer struct {
+	sarama.Consumer
+
+	partitions []int32
+	// Every partition consumer created is also sent to this channel, if
+	// non-nil.
+	created chan *fakeRebalancePartitionConsumer
+	// Returned by Close.
+	closeErr error
+
+	lock               sync.Mutex
+	partitionConsumers []*fakeRebalancePartitionConsumer
+	closed             bool
+}
+
+func newFakeConsumer(partitions []int32) *fakeConsumer {
+	return &fakeConsumer{
+		partitions: partitions,
+		created:    make(chan *fakeRebalancePartitionConsumer, len(partitions)),
+	}
+}
+
+func (c *fakeConsumer) Partitions(string) ([]int32, error) {
+	return c.partitions, nil
+}
+
+func (c *fakeConsumer) ConsumePartition(topic string, partition int32, offset int64) (sarama.PartitionConsumer, error) {
+	pc := &fakeRebalancePartitionConsumer{
+		topic:     topic,
+		partition: partition,
+		offset:    offset,
+		messages:  make(chan *sarama.ConsumerMessage, 10),
+		errors:    make(chan *sarama.ConsumerError, 10),
+	}
+
+	c.lock.Lock()
+	c.partitionConsumers = append(c.partitionConsumers, pc)
+	c.lock.Unlock()
+
+	if c.created != nil {
+		c.created <- pc
+	}
+	return pc, nil
+}
+
+func (c *fakeConsumer) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		// The same as the consumer created by sarama.NewConsumer.
+		return sarama.ErrClosedClient
+	}
+	c.closed = true
+	return c.closeErr
+}
+
+func (c *fakeConsumer) isClosed() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.closed
+}
+
+// rebalance closes the channels of all the partition consumers created.
+func (c *fakeConsumer) rebalance() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, pc := range c.partitionConsumers {
+		pc.AsyncClose()
+	}
+}
+
+type fakeRebalancePartitionConsumer struct {
+	sarama.PartitionConsumer
+
+	topic     string
+	partition int32
+	offset    int64
+
+	messages chan *sarama.ConsumerMessage
+	errors   chan *sarama.ConsumerError
+	once     sync.Once
+
+	// The offset following the last message yielded, accessed atomically.
+	highWaterMark int64
+}
+
+func (pc *fakeRebalancePartitionConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return pc.messages
+}
+
+func (pc *fakeRebalancePartitionConsumer) Errors() <-chan *sarama.ConsumerError {
+	return pc.errors
+}
+
+func (pc *fakeRebalancePartitionConsumer) HighWaterMarkOffset() int64 {
+	return atomic.LoadInt64(&pc.highWaterMark)
+}
+
+func (pc *fakeRebalancePartitionConsumer) AsyncClose() {
+	pc.once.Do(func() {
+		close(pc.messages)
+		close(pc.errors)
+	})
+}
+
+func (pc *fakeRebalancePartitionConsumer) Close() error {
+	pc.AsyncClose()
+	return nil
+}
+
+// yield sends a message with the given offset.
+func (pc *fakeRebalancePartitionConsumer) yield(offset int64) {
+	atomic.StoreInt64(&pc.highWaterMark, offset+1)
+	pc.messages <- &sarama.ConsumerMessage{
+		Topic:     pc.topic,
+		Partition: pc.partition,
+		Offset:    offset,
+	}
+}
+
+// fakeConsumerGroup is a sarama.ConsumerGroup that delivers the messages sent
+// to its claims channel as a claim of a new session on every Consume call.
+type fakeConsumerGroup struct {
+	claims chan *fakeGroupClaim
+	errors chan error
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	lock    sync.Mutex
+	marked  []int64
+	commits int
+}
+
+func newFakeConsumerGroup() *fakeConsumerGroup {
+	return &fakeConsumerGroup{
+		claims: make(chan *fakeGroupClaim, 1),
+		errors: make(chan error, 1),
+		closed: make(chan struct{}),
+	}
+}
+
+func (g *fakeConsumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	select {
+	case <-g.closed:
+		return sarama.ErrClosedConsumerGroup
+	default:
+	}
+
+	var claim *fakeGroupClaim
+	select {
+	case <-ctx.Done():
+		return nil
+	case claim = <-g.claims:
+	}
+
+	session := &fakeGroupSession{ctx: ctx, group: g, topics: topics}
+	if err := handler.Setup(session); err != nil {
+		return err
+	}
+	go func() {
+		// like sarama, close the claim when the session ends
+		<-ctx.Done()
+		claim.close()
+	}()
+	if err := handler.ConsumeClaim(session, claim); err != nil {
+		return err
+	}
+	return handler.Cleanup(session)
+}
+
+func (g *fakeConsumerGroup) Errors() <-chan error {
+	return g.errors
+}
+
+func (g *fakeConsumerGroup) Close() error {
+	g.closeOnce.Do(func() {
+		close(g.closed)
+		close(g.errors)
+	})
+	return nil
+}
+
+func (g *fakeConsumerGroup) getMarked() []int64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return append([]int64(nil), g.marked...)
+}
+
+// fakeGroupSession is the sarama.ConsumerGroupSession of fakeConsumerGroup,
+// which records the messages marked and the commits in group.
+type fakeGroupSession struct {
+	sarama.ConsumerGroupSession
+
+	ctx    context.Context
+	group  *fakeConsumerGroup
+	topics []string
+	// If non-nil, returned by Claims instead.
+	claims map[string][]int32
+}
+
+func (s *fakeGroupSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeGroupSession) Claims() map[string][]int32 {
+	if s.claims != nil {
+		return s.claims
+	}
+	// Every session claims a single partition of each topic.
+	claims := make(map[string][]int32, len(s.topics))
+	for _, topic := range s.topics {
+		claims[topic] = []int32{0}
+	}
+	return claims
+}
+
+func (s *fakeGroupSession) Commit() {
+	s.group.lock.Lock()
+	defer s.group.lock.Unlock()
+	s.group.commits++
+}
+
+func (s *fakeGroupSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.group.lock.Lock()
+	defer s.group.lock.Unlock()
+	s.group.marked = append(s.group.marked, msg.Offset)
+}
+
+// fakeGroupClaim is a sarama.ConsumerGroupClaim delivering the messages sent
+// to its messages channel.
+type fakeGroupClaim struct {
+	sarama.ConsumerGroupClaim
+
+	messages      chan *sarama.ConsumerMessage
+	highWaterMark int64
+	closeOnce     sync.Once
+}
+
+func newFakeGroupClaim(size int) *fakeGroupClaim {
+	return &fakeGroupClaim{
+		messages: make(chan *sarama.ConsumerMessage, size),
+	}
+}
+
+func (c *fakeGroupClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+func (c *fakeGroupClaim) HighWaterMarkOffset() int64 {
+	return c.highWaterMark
+}
+
+func (c *fakeGroupClaim) close() {
+	c.closeOnce.Do(func() {
+		close(c.messages)
+	})
+}
@@ -0,0 +1,581 @@
+package kafkabp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/reddit/baseplate.go/metricsbp"
+)
+
+// groupConsumer is a Consumer backed by a sarama.ConsumerGroup.
+type groupConsumer struct {
+	cfg    GroupConsumerConfig
+	group  sarama.ConsumerGroup
+	topics []string
+
+	// Only used to handle the individual messages, so they are handled the
+	// same way as the ones from consumer.
+	kc *consumer
+
+	closed          int64
+	consumeReturned int64
+	// The result of the first Shutdown call.
+	shutdownResult firstResult
+
+	// The partitions claimed in the current group session.
+	claimsLock sync.Mutex
+	claims     map[string][]int32
+	// Closed the first time a group session claims any partition, see
+	// WaitForAssignment.
+	assigned     chan struct{}
+	assignedOnce sync.Once
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	wg sync.WaitGroup
+}
+
+// NewGroupConsumer creates a new Kafka consumer that joins the consumer group
+// cfg.GroupID. Unlike the consumer created by NewConsumer, the partitions of
+// the topic are split among all the consumers in the same group, with the
+// rebalances handled by sarama, so every message is delivered to only one of
+// them.
+//
+// The returned Consumer does not support Seek.
+func NewGroupConsumer(cfg GroupConsumerConfig) (Consumer, error) {
+	sc, err := cfg.NewSaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Logger == nil {
+		cfg.Logger = DefaultLogger
+	}
+
+	group, err := sarama.NewConsumerGroup(cfg.Brokers, cfg.GroupID, sc)
+	if err != nil {
+		return nil, err
+	}
+
+	return newGroupConsumer(cfg, sc, group), nil
+}
+
+func newGroupConsumer(cfg GroupConsumerConfig, sc *sarama.Config, group sarama.ConsumerGroup) *groupConsumer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &groupConsumer{
+		cfg:    cfg,
+		group:  group,
+		topics: cfg.topics(),
+		kc: &consumer{
+			cfg:     cfg.ConsumerConfig,
+			offset:  sc.Consumer.Offsets.Initial,
+			created: time.Now(),
+			limiter: newRateLimiter(cfg.RateLimit, cfg.RateLimitBurst),
+		},
+		assigned: make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Close closes the consumer, see Shutdown.
+func (gc *groupConsumer) Close() error {
+	return gc.Shutdown(context.Background())
+}
+
+// Shutdown leaves the consumer group, waits for in-flight messages to be
+// handled until ctx is done, then closes the consumer.
+//
+// The calls following the first one wait for it to finish, until their ctx is
+// done, and return its result.
+func (gc *groupConsumer) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt64(&gc.closed, 0, 1) {
+		// Closing is already in progress, return its result.
+		return gc.shutdownResult.wait(ctx)
+	}
+	return gc.shutdownResult.set(gc.shutdown(ctx))
+}
+
+// shutdown implements Shutdown, after it's called for the first time.
+func (gc *groupConsumer) shutdown(ctx context.Context) error {
+	gc.kc.markShutdown()
+
+	// ends the current group session, which closes the claims
+	gc.cancel()
+
+	// wait for the Consume function to return
+	drained := make(chan struct{})
+	go func() {
+		gc.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		metricsbp.M.Counter(gc.kc.metricName("shutdown.forced")).With(gc.topicTags()...).Add(1)
+		err := fmt.Errorf(
+			"kafkabp: shutdown interrupted before in-flight messages were drained: %w",
+			ctx.Err(),
+		)
+		if closeErr := gc.group.Close(); closeErr != nil {
+			gc.cfg.Logger.Log(context.Background(), "kafkabp.groupConsumer.Shutdown: Error closing the consumer group:"+closeErr.Error())
+		}
+		return err
+	}
+	return gc.group.Close()
+}
+
+// CloseWithTimeout is the same as Shutdown with a context timing out after
+// timeout.
+func (gc *groupConsumer) CloseWithTimeout(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return gc.Shutdown(ctx)
+}
+
+// Consume consumes the topic as a member of the consumer group, and blocks
+// until the consumer is closed.
+//
+// Every message is handled within the same span as the ones handled by the
+// consumer created by NewConsumer. Unless ManualCommit is enabled, a message
+// is marked as consumed only when its payload decodes and messagesFunc returns
+// nil. A failed message is left unmarked, so it's redelivered after a
+// rebalance or restart, unless a later message of the same partition is marked
+// before then, which commits past it.
+func (gc *groupConsumer) Consume(
+	messagesFunc ConsumeMessageFunc,
+	errorsFunc ConsumeErrorFunc,
+) error {
+	return gc.ConsumeContext(context.Background(), messagesFunc, errorsFunc)
+}
+
+// ConsumeContext is like Consume, including how the messages are marked, but
+// also closes the consumer when ctx is done, and returns after the in-flight
+// messages are handled.
+func (gc *groupConsumer) ConsumeContext(
+	ctx context.Context,
+	messagesFunc ConsumeMessageFunc,
+	errorsFunc ConsumeErrorFunc,
+) error {
+	return gc.ConsumeWithErrorContext(ctx, messagesFunc, IgnoreErrorContext(errorsFunc))
+}
+
+// ConsumeWithErrorContext is like ConsumeContext, but the errors are passed to
+// errorsFunc along with their context.
+//
+// The errors of the consumer group itself are passed with a context carrying
+// no topic or partition.
+func (gc *groupConsumer) ConsumeWithErrorContext(
+	ctx context.Context,
+	messagesFunc ConsumeMessageFunc,
+	errorsFunc ConsumeErrorContextFunc,
+) error {
+	returned := make(chan struct{})
+	defer close(returned)
+	go func() {
+		select {
+		case <-returned:
+		case <-ctx.Done():
+			if err := gc.Close(); err != nil {
+				gc.cfg.Logger.Log(context.Background(), "kafkabp.groupConsumer.ConsumeContext: Error closing the consumer group:"+err.Error())
+			}
+		}
+	}()
+
+	defer atomic.StoreInt64(&gc.consumeReturned, 1)
+	gc.wg.Add(1)
+	defer gc.wg.Done()
+
+	queue := newErrorQueue(errorsFunc, func(error) {
+		metricsbp.M.Counter(gc.kc.metricName("kafka.errors.dropped")).With(gc.topicTags()...).Add(1)
+	})
+	defer queue.stop()
+	go func() {
+		for err := range gc.group.Errors() {
+			metricsbp.M.Counter(gc.kc.metricName("kafka.errors")).With(gc.topicTags()...).Add(1)
+			queue.add(context.Background(), err)
+		}
+	}()
+
+	handler := groupConsumerHandler{
+		gc:           gc,
+		kc:           gc.kc,
+		messagesFunc: messagesFunc,
+		errorsFunc:   queue.add,
+		manualCommit: gc.cfg.ManualCommit,
+	}
+	for {
+		// Consume returns when the group session ends, either because of a
+		// rebalance, or because the consumer is closed.
+		if err := gc.group.Consume(gc.ctx, gc.topics, handler); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return nil
+			}
+			metricsbp.M.Counter(gc.kc.metricName("rebalance.failure")).With(gc.topicTags()...).Add(1)
+			return err
+		}
+
+		// Close or Shutdown was called, so exit. The call to Shutdown handles
+		// cleaning up the consumer group.
+		if gc.ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// Seek always returns ErrSeekNotSupported.
+func (gc *groupConsumer) Seek(partition int32, offset int64) error {
+	return ErrSeekNotSupported
+}
+
+// SetRateLimit implements Consumer.
+func (gc *groupConsumer) SetRateLimit(rate float64, burst int) {
+	gc.kc.SetRateLimit(rate, burst)
+}
+
+// Pause stops handling messages from all the claimed partitions until Resume
+// is called. The consumer stays in the group and keeps its partitions.
+func (gc *groupConsumer) Pause() {
+	gc.kc.Pause()
+}
+
+// Resume implements Consumer.
+func (gc *groupConsumer) Resume() {
+	gc.kc.Resume()
+}
+
+// PausePartition implements Consumer.
+func (gc *groupConsumer) PausePartition(partition int32) {
+	gc.kc.PausePartition(partition)
+}
+
+// ResumePartition implements Consumer.
+func (gc *groupConsumer) ResumePartition(partition int32) {
+	gc.kc.ResumePartition(partition)
+}
+
+// IsPaused implements Consumer.
+func (gc *groupConsumer) IsPaused() bool {
+	return gc.kc.IsPaused()
+}
+
+// Offsets implements Consumer.
+//
+// It only covers the partitions currently claimed by this consumer, and
+// returns nil when consuming multiple topics.
+func (gc *groupConsumer) Offsets() map[int32]int64 {
+	if !gc.tracksPositions() {
+		return nil
+	}
+
+	gc.kc.partitionsLock.Lock()
+	defer gc.kc.partitionsLock.Unlock()
+	offsets := make(map[int32]int64, len(gc.kc.offsets))
+	for p, offset := range gc.kc.offsets {
+		offsets[p] = offset
+	}
+	return offsets
+}
+
+// HighWaterMarks implements Consumer.
+//
+// It only covers the partitions currently claimed by this consumer, and is
+// updated after every message handled. It returns nil when consuming multiple
+// topics.
+func (gc *groupConsumer) HighWaterMarks() map[int32]int64 {
+	if !gc.tracksPositions() {
+		return nil
+	}
+
+	gc.kc.partitionsLock.Lock()
+	defer gc.kc.partitionsLock.Unlock()
+	hwms := make(map[int32]int64, len(gc.kc.highWaterMarks))
+	for p, hwm := range gc.kc.highWaterMarks {
+		hwms[p] = hwm
+	}
+	return hwms
+}
+
+// IsHealthy returns false after Consume returns, or when no message was
+// handled successfully within StalenessThreshold.
+func (gc *groupConsumer) IsHealthy() bool {
+	return atomic.LoadInt64(&gc.consumeReturned) == 0 && gc.kc.fresh()
+}
+
+// LastMessageTime implements Consumer.
+func (gc *groupConsumer) LastMessageTime() time.Time {
+	return gc.kc.LastMessageTime()
+}
+
+// HealthyWithin implements Consumer.
+func (gc *groupConsumer) HealthyWithin(d time.Duration) bool {
+	return gc.kc.HealthyWithin(d)
+}
+
+// AssignmentWaiter is implemented by the Consumer returned by
+// NewGroupConsumer, to wait for the group to assign partitions to it, for
+// example in readiness checks and integration tests:
+//
+//     if waiter, ok := consumer.(kafkabp.AssignmentWaiter); ok {
+//         partitions, err := waiter.WaitForAssignment(ctx)
+//         // ...
+//     }
+type AssignmentWaiter interface {
+	// WaitForAssignment blocks until the group assigns at least one partition
+	// to the consumer, or ctx is done, in which case ctx.Err() is returned.
+	//
+	// It returns the partitions claimed in the current group session, of all
+	// the topics consumed. Once any partition was assigned, it returns
+	// immediately, even if a later rebalance takes all of them away.
+	WaitForAssignment(ctx context.Context) ([]int32, error)
+}
+
+var _ AssignmentWaiter = (*groupConsumer)(nil)
+
+// WaitForAssignment implements AssignmentWaiter.
+func (gc *groupConsumer) WaitForAssignment(ctx context.Context) ([]int32, error) {
+	select {
+	case <-gc.assigned:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	gc.claimsLock.Lock()
+	defer gc.claimsLock.Unlock()
+	var partitions []int32
+	for _, topic := range gc.topics {
+		partitions = append(partitions, gc.claims[topic]...)
+	}
+	return partitions, nil
+}
+
+// tracksPositions returns false when consuming multiple topics, as the
+// positions are tracked by partition only.
+func (gc *groupConsumer) tracksPositions() bool {
+	return len(gc.topics) == 1
+}
+
+// topicTags returns the metrics tags of the topics consumed.
+func (gc *groupConsumer) topicTags() []string {
+	return gc.kc.topicTags(strings.Join(gc.topics, ","))
+}
+
+// RebalanceEvent describes the partitions claimed by a group consumer after a
+// rebalance, by topic.
+type RebalanceEvent struct {
+	// All the partitions claimed after the rebalance.
+	Claims map[string][]int32
+
+	// The partitions claimed after the rebalance but not before.
+	Gained map[string][]int32
+
+	// The partitions claimed before the rebalance but not after.
+	Lost map[string][]int32
+}
+
+// updateClaims replaces the partitions claimed in the current group session
+// with claims, and returns the differences.
+func (gc *groupConsumer) updateClaims(claims map[string][]int32) RebalanceEvent {
+	gc.claimsLock.Lock()
+	defer gc.claimsLock.Unlock()
+	event := RebalanceEvent{
+		Claims: claims,
+		Gained: diffClaims(claims, gc.claims),
+		Lost:   diffClaims(gc.claims, claims),
+	}
+	gc.claims = claims
+	return event
+}
+
+// diffClaims returns the partitions in a but not in b.
+func diffClaims(a, b map[string][]int32) map[string][]int32 {
+	diff := make(map[string][]int32)
+	for topic, partitions := range a {
+		existing := make(map[int32]bool, len(b[topic]))
+		for _, p := range b[topic] {
+			existing[p] = true
+		}
+		for _, p := range partitions {
+			if !existing[p] {
+				diff[topic] = append(diff[topic], p)
+			}
+		}
+	}
+	return diff
+}
+
+// countPartitions returns the number of partitions in claims.
+func countPartitions(claims map[string][]int32) int {
+	var n int
+	for _, partitions := range claims {
+		n += len(partitions)
+	}
+	return n
+}
+
+// groupConsumerHandler is the sarama.ConsumerGroupHandler used by
+// groupConsumer.
+type groupConsumerHandler struct {
+	gc           *groupConsumer
+	kc           *consumer
+	messagesFunc ConsumeMessageFunc
+	errorsFunc   ConsumeErrorContextFunc
+	manualCommit bool
+}
+
+// Setup implements sarama.ConsumerGroupHandler.
+func (h groupConsumerHandler) Setup(session sarama.ConsumerGroupSession) error {
+	claims := session.Claims()
+	var partitions []int32
+	for _, topic := range h.gc.topics {
+		partitions = append(partitions, claims[topic]...)
+	}
+	h.kc.reportPartitions(len(partitions))
+	if h.gc.tracksPositions() {
+		h.kc.resetGroupPositions(partitions, h.kc.offset)
+	}
+	metricsbp.M.Counter(h.kc.metricName("rebalance.success")).With(h.gc.topicTags()...).Add(1)
+
+	event := h.gc.updateClaims(claims)
+	if len(partitions) > 0 {
+		h.gc.assignedOnce.Do(func() {
+			close(h.gc.assigned)
+		})
+	}
+	tags := h.gc.topicTags()
+	metricsbp.M.Counter(h.kc.metricName("group.rebalance")).With(tags...).Add(1)
+	metricsbp.M.Counter(h.kc.metricName("group.rebalance.partitions.gained")).With(tags...).Add(float64(countPartitions(event.Gained)))
+	metricsbp.M.Counter(h.kc.metricName("group.rebalance.partitions.lost")).With(tags...).Add(float64(countPartitions(event.Lost)))
+	if h.kc.cfg.OnRebalanceEnd != nil {
+		h.kc.cfg.OnRebalanceEnd(event)
+	}
+	return nil
+}
+
+// Cleanup implements sarama.ConsumerGroupHandler.
+func (h groupConsumerHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	if h.kc.cfg.OnRebalanceStart != nil {
+		h.kc.cfg.OnRebalanceStart(session.Claims())
+	}
+	return nil
+}
+
+// ConsumeClaim implements sarama.ConsumerGroupHandler.
+func (h groupConsumerHandler) ConsumeClaim(
+	session sarama.ConsumerGroupSession,
+	claim sarama.ConsumerGroupClaim,
+) error {
+	messagesFunc := h.messagesFunc
+	if h.manualCommit {
+		// Give messagesFunc access to the session for MarkMessage and
+		// CommitMarked.
+		messagesFunc = func(ctx context.Context, m *sarama.ConsumerMessage) error {
+			return h.messagesFunc(context.WithValue(ctx, groupSessionKey{}, session), m)
+		}
+	}
+
+	for m := range claim.Messages() {
+		if h.kc.discardOnShutdown() {
+			// Leave the remaining buffered messages unmarked.
+			return nil
+		}
+		if err := h.kc.pauser.wait(session.Context(), m.Partition); err != nil {
+			// The session ended while paused, leave the message unmarked.
+			return nil
+		}
+		if err := h.kc.waitRateLimit(session.Context()); err != nil {
+			// The session ended while rate limited, leave the message unmarked.
+			return nil
+		}
+		err := h.kc.handleMessage(m, messagesFunc, h.errorsFunc)
+		if !h.manualCommit && err == nil {
+			// Same as NewConsumerGroupHandler, only mark the messages handled
+			// successfully.
+			session.MarkMessage(m, "")
+		}
+		h.trackPosition(m, claim)
+	}
+	return nil
+}
+
+// trackPosition records the position of the claim after handling m.
+func (h groupConsumerHandler) trackPosition(m *sarama.ConsumerMessage, claim sarama.ConsumerGroupClaim) {
+	if h.gc.tracksPositions() {
+		h.kc.trackGroupPosition(m, claim.HighWaterMarkOffset())
+	}
+}
+
+// resetGroupPositions forgets the positions of the partitions no longer
+// claimed after a rebalance, and initializes the newly claimed ones to offset.
+func (kc *consumer) resetGroupPositions(partitions []int32, offset int64) {
+	kc.partitionsLock.Lock()
+	defer kc.partitionsLock.Unlock()
+	offsets := make(map[int32]int64, len(partitions))
+	hwms := make(map[int32]int64, len(partitions))
+	for _, p := range partitions {
+		if o, ok := kc.offsets[p]; ok {
+			offsets[p] = o
+			hwms[p] = kc.highWaterMarks[p]
+		} else {
+			offsets[p] = offset
+		}
+	}
+	kc.offsets = offsets
+	kc.highWaterMarks = hwms
+}
+
+// trackGroupPosition records the position of the group consumer after handling
+// m.
+func (kc *consumer) trackGroupPosition(m *sarama.ConsumerMessage, highWaterMark int64) {
+	kc.partitionsLock.Lock()
+	defer kc.partitionsLock.Unlock()
+	if kc.offsets == nil {
+		kc.offsets = make(map[int32]int64)
+	}
+	if kc.highWaterMarks == nil {
+		kc.highWaterMarks = make(map[int32]int64)
+	}
+	kc.offsets[m.Partition] = m.Offset + 1
+	kc.highWaterMarks[m.Partition] = highWaterMark
+}
+
+type groupSessionKey struct{}
+
+// MarkMessage marks msg as consumed, so its offset will be committed by the
+// group consumer. ctx must be the context passed to the ConsumeMessageFunc by
+// a group consumer with ManualCommit enabled, otherwise
+// ErrNotGroupConsumerContext is returned.
+//
+// Please note that Kafka commits offsets by position, so marking a message
+// implicitly commits the unmarked messages before it in the same partition.
+func MarkMessage(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	session, ok := ctx.Value(groupSessionKey{}).(sarama.ConsumerGroupSession)
+	if !ok {
+		return ErrNotGroupConsumerContext
+	}
+	session.MarkMessage(msg, "")
+	return nil
+}
+
+// CommitMarked commits the offsets of the messages marked as consumed so far
+// synchronously, which is mostly useful with DisableAutoCommit. ctx must be the
+// context passed to the ConsumeMessageFunc by a group consumer with
+// ManualCommit enabled, otherwise ErrNotGroupConsumerContext is returned.
+//
+// The errors committing the offsets are passed to the ConsumeErrorFunc.
+func CommitMarked(ctx context.Context) error {
+	session, ok := ctx.Value(groupSessionKey{}).(sarama.ConsumerGroupSession)
+	if !ok {
+		return ErrNotGroupConsumerContext
+	}
+	session.Commit()
+	return nil
+}
@@ -0,0 +1,422 @@
+package kafkabp
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func getTestGroupConsumer(t *testing.T) (*groupConsumer, *fakeConsumerGroup) {
+	cfg := GroupConsumerConfig{
+		ConsumerConfig: ConsumerConfig{
+			Brokers:  []string{"127.0.0.1:9090", "127.0.0.2:9090"},
+			Topic:    "kafkabp-test",
+			ClientID: "test-group-consumer",
+		},
+		GroupID: "test-group",
+	}
+	sc, err := cfg.NewSaramaConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := newFakeConsumerGroup()
+	return newGroupConsumer(cfg, sc, group), group
+}
+
+func TestGroupConsumerConfig(t *testing.T) {
+	cfg := GroupConsumerConfig{
+		ConsumerConfig: ConsumerConfig{
+			Brokers:  []string{"127.0.0.1:9090"},
+			Topic:    "kafkabp-test",
+			ClientID: "test-group-consumer",
+		},
+	}
+	sc, err := cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrGroupIDEmpty) {
+		t.Errorf("expected error %v, got %v", ErrGroupIDEmpty, err)
+	}
+
+	cfg.GroupID = "test-group"
+	sc, err = cfg.NewSaramaConfig()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !sc.Version.IsAtLeast(sarama.V0_10_2_0) {
+		t.Errorf("expected version at least %v, got %v", sarama.V0_10_2_0, sc.Version)
+	}
+
+	cfg.Version = "0.10.1.0"
+	sc, err = cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrVersionTooOld) {
+		t.Errorf("expected error %v, got %v", ErrVersionTooOld, err)
+	}
+
+	cfg.Version = "2.6.0"
+	sc, err = cfg.NewSaramaConfig()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sc.Version != sarama.V2_6_0_0 {
+		t.Errorf("expected version %v, got %v", sarama.V2_6_0_0, sc.Version)
+	}
+	cfg.Version = ""
+
+	if sc.Consumer.Group.Rebalance.Strategy != sarama.BalanceStrategyRange {
+		t.Errorf("expected range strategy by default, got %v", sc.Consumer.Group.Rebalance.Strategy.Name())
+	}
+
+	cfg.AssignmentStrategy = AssignmentStrategySticky
+	sc, err = cfg.NewSaramaConfig()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sc.Consumer.Group.Rebalance.Strategy != sarama.BalanceStrategySticky {
+		t.Errorf("expected sticky strategy, got %v", sc.Consumer.Group.Rebalance.Strategy.Name())
+	}
+
+	cfg.AssignmentStrategy = "foo"
+	sc, err = cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrAssignmentStrategyInvalid) {
+		t.Errorf("expected error %v, got %v", ErrAssignmentStrategyInvalid, err)
+	}
+
+	cfg.AssignmentStrategy = ""
+	cfg.SessionTimeout = 30 * time.Second
+	cfg.HeartbeatInterval = 5 * time.Second
+	sc, err = cfg.NewSaramaConfig()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sc.Consumer.Group.Session.Timeout != cfg.SessionTimeout {
+		t.Errorf("expected session timeout %v, got %v", cfg.SessionTimeout, sc.Consumer.Group.Session.Timeout)
+	}
+	if sc.Consumer.Group.Heartbeat.Interval != cfg.HeartbeatInterval {
+		t.Errorf("expected heartbeat interval %v, got %v", cfg.HeartbeatInterval, sc.Consumer.Group.Heartbeat.Interval)
+	}
+
+	// HeartbeatInterval must be less than a third of SessionTimeout
+	cfg.HeartbeatInterval = 10 * time.Second
+	sc, err = cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrHeartbeatIntervalInvalid) {
+		t.Errorf("expected error %v, got %v", ErrHeartbeatIntervalInvalid, err)
+	}
+
+	cfg.HeartbeatInterval = 0
+	cfg.CommitInterval = 100 * time.Millisecond
+	sc, err = cfg.NewSaramaConfig()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sc.Consumer.Offsets.AutoCommit.Interval != cfg.CommitInterval {
+		t.Errorf("expected commit interval %v, got %v", cfg.CommitInterval, sc.Consumer.Offsets.AutoCommit.Interval)
+	}
+	if !sc.Consumer.Offsets.AutoCommit.Enable {
+		t.Error("expected auto-commit to be enabled by default")
+	}
+
+	cfg.CommitInterval = -time.Second
+	sc, err = cfg.NewSaramaConfig()
+	if sc != nil {
+		t.Errorf("expected config to be nil, got %v", sc)
+	}
+	if !errors.Is(err, ErrCommitIntervalInvalid) {
+		t.Errorf("expected error %v, got %v", ErrCommitIntervalInvalid, err)
+	}
+
+	cfg.DisableAutoCommit = true
+	sc, err = cfg.NewSaramaConfig()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sc.Consumer.Offsets.AutoCommit.Enable {
+		t.Error("expected auto-commit to be disabled")
+	}
+}
+
+func TestGroupConsumer_Consume(t *testing.T) {
+	gc, group := getTestGroupConsumer(t)
+
+	var lock sync.Mutex
+	var consumed []int64
+	var errs []error
+	consumeDone := make(chan error)
+	go func() {
+		consumeDone <- gc.Consume(
+			func(_ context.Context, msg *sarama.ConsumerMessage) error {
+				lock.Lock()
+				defer lock.Unlock()
+				consumed = append(consumed, msg.Offset)
+				if msg.Offset == 1 {
+					return errors.New("handler error")
+				}
+				return nil
+			},
+			func(err error) {
+				lock.Lock()
+				defer lock.Unlock()
+				errs = append(errs, err)
+			},
+		)
+	}()
+
+	// The first session ends with a rebalance, the second with Close.
+	for session := 0; session < 2; session++ {
+		claim := newFakeGroupClaim(2)
+		for i := 0; i < 2; i++ {
+			claim.messages <- &sarama.ConsumerMessage{
+				Topic:  gc.cfg.Topic,
+				Offset: int64(session*2 + i),
+				Value:  []byte("value"),
+			}
+		}
+		if session == 0 {
+			claim.close()
+		}
+		group.claims <- claim
+	}
+	group.errors <- errors.New("kafka error")
+
+	errorsHandled := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(errs)
+	}
+	deadline := time.Now().Add(time.Second)
+	for (len(group.getMarked()) < 3 || errorsHandled() < 1) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !gc.IsHealthy() {
+		t.Error("expected consumer to be healthy while consuming")
+	}
+
+	if err := gc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-consumeDone; err != nil {
+		t.Errorf("expected Consume to return nil, got %v", err)
+	}
+	if gc.IsHealthy() {
+		t.Error("expected consumer to be unhealthy after Consume returns")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(consumed) != 4 {
+		t.Errorf("expected 4 messages consumed, got %v", consumed)
+	}
+	// The message the handler failed is left unmarked.
+	if marked := group.getMarked(); !reflect.DeepEqual(marked, []int64{0, 2, 3}) {
+		t.Errorf("expected offsets [0 2 3] to be marked, got %v", marked)
+	}
+	if len(errs) != 1 {
+		t.Errorf("expected 1 kafka error, got %v", errs)
+	}
+}
+
+func TestGroupConsumer_OffsetsBeforeMessages(t *testing.T) {
+	gc, group := getTestGroupConsumer(t)
+	defer gc.Close()
+
+	h := groupConsumerHandler{
+		gc: gc,
+		kc: gc.kc,
+	}
+	session := &fakeGroupSession{
+		ctx:    context.Background(),
+		group:  group,
+		claims: map[string][]int32{gc.cfg.Topic: {0, 1}},
+	}
+	if err := h.Setup(session); err != nil {
+		t.Fatal(err)
+	}
+
+	// No message is handled yet, so the partitions report the configured
+	// offset, which defaults to the oldest one.
+	expected := map[int32]int64{
+		0: sarama.OffsetOldest,
+		1: sarama.OffsetOldest,
+	}
+	if offsets := gc.Offsets(); !reflect.DeepEqual(offsets, expected) {
+		t.Errorf("expected offsets %v, got %v", expected, offsets)
+	}
+}
+
+func TestGroupConsumer_WaitForAssignment(t *testing.T) {
+	gc, group := getTestGroupConsumer(t)
+	waiter, ok := Consumer(gc).(AssignmentWaiter)
+	if !ok {
+		t.Fatal("expected the group consumer to implement AssignmentWaiter")
+	}
+
+	consumeDone := make(chan error, 1)
+	go func() {
+		consumeDone <- gc.Consume(
+			func(context.Context, *sarama.ConsumerMessage) error {
+				return nil
+			},
+			func(error) {},
+		)
+	}()
+
+	// Nothing is assigned before the first group session.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if partitions, err := waiter.WaitForAssignment(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error %v before assignment, got %v, %v", context.DeadlineExceeded, partitions, err)
+	}
+
+	waited := make(chan []int32, 1)
+	go func() {
+		partitions, err := waiter.WaitForAssignment(context.Background())
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		waited <- partitions
+	}()
+	group.claims <- newFakeGroupClaim(0)
+
+	select {
+	case partitions := <-waited:
+		// fakeGroupSession claims partition 0 of every topic.
+		if !reflect.DeepEqual(partitions, []int32{0}) {
+			t.Errorf("expected partitions [0], got %v", partitions)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the assignment")
+	}
+
+	if err := gc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-consumeDone; err != nil {
+		t.Errorf("expected Consume to return nil, got %v", err)
+	}
+}
+
+func TestGroupConsumer_ManualCommit(t *testing.T) {
+	gc, group := getTestGroupConsumer(t)
+	defer gc.Close()
+
+	claim := newFakeGroupClaim(4)
+	for i := 0; i < 4; i++ {
+		claim.messages <- &sarama.ConsumerMessage{
+			Topic:  gc.cfg.Topic,
+			Offset: int64(i),
+		}
+	}
+	claim.highWaterMark = 10
+	claim.close()
+
+	h := groupConsumerHandler{
+		gc: gc,
+		kc: gc.kc,
+		messagesFunc: func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			if msg.Offset%2 == 0 {
+				return MarkMessage(ctx, msg)
+			}
+			return CommitMarked(ctx)
+		},
+		errorsFunc:   func(context.Context, error) {},
+		manualCommit: true,
+	}
+	session := &fakeGroupSession{ctx: context.Background(), group: group}
+	if err := h.ConsumeClaim(session, claim); err != nil {
+		t.Fatal(err)
+	}
+
+	if offsets := gc.Offsets(); len(offsets) != 1 || offsets[0] != 4 {
+		t.Errorf("expected offsets map[0:4], got %v", offsets)
+	}
+	if hwms := gc.HighWaterMarks(); len(hwms) != 1 || hwms[0] != 10 {
+		t.Errorf("expected high water marks map[0:10], got %v", hwms)
+	}
+
+	if marked := group.getMarked(); len(marked) != 2 || marked[0] != 0 || marked[1] != 2 {
+		t.Errorf("expected offsets [0 2] to be marked, got %v", marked)
+	}
+
+	group.lock.Lock()
+	if group.commits != 2 {
+		t.Errorf("expected 2 commits, got %d", group.commits)
+	}
+	group.lock.Unlock()
+
+	err := MarkMessage(context.Background(), &sarama.ConsumerMessage{})
+	if !errors.Is(err, ErrNotGroupConsumerContext) {
+		t.Errorf("expected error %v, got %v", ErrNotGroupConsumerContext, err)
+	}
+	if err := CommitMarked(context.Background()); !errors.Is(err, ErrNotGroupConsumerContext) {
+		t.Errorf("expected error %v, got %v", ErrNotGroupConsumerContext, err)
+	}
+}
+
+func TestGroupConsumer_Rebalance(t *testing.T) {
+	gc, group := getTestGroupConsumer(t)
+	defer gc.Close()
+
+	var started []map[string][]int32
+	var ended []RebalanceEvent
+	gc.kc.cfg.OnRebalanceStart = func(claims map[string][]int32) {
+		started = append(started, claims)
+	}
+	gc.kc.cfg.OnRebalanceEnd = func(event RebalanceEvent) {
+		ended = append(ended, event)
+	}
+	h := groupConsumerHandler{
+		gc: gc,
+		kc: gc.kc,
+	}
+
+	topic := gc.cfg.Topic
+	for _, claims := range []map[string][]int32{
+		{topic: {0, 1}},
+		{topic: {1, 2}},
+	} {
+		session := &fakeGroupSession{ctx: context.Background(), group: group, claims: claims}
+		if err := h.Setup(session); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.Cleanup(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(started) != 2 || len(started[1][topic]) != 2 || started[1][topic][1] != 2 {
+		t.Errorf("expected OnRebalanceStart to be called with the released claims, got %v", started)
+	}
+	if len(ended) != 2 {
+		t.Fatalf("expected OnRebalanceEnd to be called twice, got %v", ended)
+	}
+	if gained := ended[0].Gained[topic]; len(gained) != 2 || len(ended[0].Lost) != 0 {
+		t.Errorf("expected partitions [0 1] gained on join, got %+v", ended[0])
+	}
+	if gained, lost := ended[1].Gained[topic], ended[1].Lost[topic]; len(gained) != 1 || gained[0] != 2 || len(lost) != 1 || lost[0] != 0 {
+		t.Errorf("expected partition 2 gained and 0 lost, got %+v", ended[1])
+	}
+}
+
+func TestGroupConsumer_Seek(t *testing.T) {
+	gc, _ := getTestGroupConsumer(t)
+	defer gc.Close()
+
+	if err := gc.Seek(0, 1); !errors.Is(err, ErrSeekNotSupported) {
+		t.Errorf("expected error %v, got %v", ErrSeekNotSupported, err)
+	}
+}
@@ -0,0 +1,51 @@
+package kafkabp
+
+import (
+	"github.com/Shopify/sarama"
+)
+
+// NewConsumerGroupHandler adapts a ConsumeMessageFunc into a
+// sarama.ConsumerGroupHandler, so the same handler can be used with both the
+// Consumer in this package and a sarama.ConsumerGroup.
+//
+// Every message is handled within the same span as the ones handled by
+// Consumer.Consume. A message is marked as consumed (so its offset will be
+// committed) when messagesFunc returns nil, and is not marked when
+// messagesFunc returns an error.
+//
+// Please note that Kafka commits offsets by position, so marking a later
+// message from the same partition implicitly commits the failed messages
+// before it. Handlers needing at-least-once delivery for failed messages should
+// retry or dead-letter them before returning.
+func NewConsumerGroupHandler(messagesFunc ConsumeMessageFunc) sarama.ConsumerGroupHandler {
+	return consumerGroupHandler{
+		messagesFunc: TracingMiddleware(messagesFunc),
+	}
+}
+
+type consumerGroupHandler struct {
+	messagesFunc ConsumeMessageFunc
+}
+
+// Setup implements sarama.ConsumerGroupHandler.
+func (consumerGroupHandler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// Cleanup implements sarama.ConsumerGroupHandler.
+func (consumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim implements sarama.ConsumerGroupHandler.
+func (h consumerGroupHandler) ConsumeClaim(
+	session sarama.ConsumerGroupSession,
+	claim sarama.ConsumerGroupClaim,
+) error {
+	for m := range claim.Messages() {
+		if err := h.messagesFunc(session.Context(), m); err == nil {
+			session.MarkMessage(m, "")
+		}
+	}
+	return nil
+}
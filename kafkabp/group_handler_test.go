@@ -0,0 +1,36 @@
+package kafkabp
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestConsumerGroupHandler(t *testing.T) {
+	claim := newFakeGroupClaim(3)
+	for i := int64(0); i < 3; i++ {
+		claim.messages <- &sarama.ConsumerMessage{
+			Topic:  "topic",
+			Offset: i,
+		}
+	}
+	claim.close()
+
+	h := NewConsumerGroupHandler(func(_ context.Context, msg *sarama.ConsumerMessage) error {
+		if msg.Offset == 1 {
+			return errors.New("handler error")
+		}
+		return nil
+	})
+	group := newFakeConsumerGroup()
+	session := &fakeGroupSession{ctx: context.Background(), group: group}
+	if err := h.ConsumeClaim(session, claim); err != nil {
+		t.Fatalf("ConsumeClaim returned error: %v", err)
+	}
+
+	if marked := group.getMarked(); len(marked) != 2 || marked[0] != 0 || marked[1] != 2 {
+		t.Errorf("expected offsets [0 2] to be marked, got %v", marked)
+	}
+}
@@ -0,0 +1,44 @@
+package kafkabp
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/gofrs/uuid"
+)
+
+// HeaderIdempotencyKey is the key of the kafka record header used to carry a
+// unique key per message, for consumers to deduplicate redeliveries.
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// AttachIdempotencyKey sets a random UUID as the HeaderIdempotencyKey header
+// of msg, unless msg already has that header.
+//
+// The only error it could return is from generating the UUID.
+func AttachIdempotencyKey(msg *sarama.ProducerMessage) error {
+	for _, h := range msg.Headers {
+		if string(h.Key) == HeaderIdempotencyKey {
+			return nil
+		}
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+	msg.Headers = append(msg.Headers, sarama.RecordHeader{
+		Key:   []byte(HeaderIdempotencyKey),
+		Value: []byte(id.String()),
+	})
+	return nil
+}
+
+// IdempotencyKey returns the HeaderIdempotencyKey header of m.
+//
+// ok is false when m doesn't have that header or it's empty.
+func IdempotencyKey(m *sarama.ConsumerMessage) (key string, ok bool) {
+	for _, h := range m.Headers {
+		if h != nil && string(h.Key) == HeaderIdempotencyKey {
+			key = string(h.Value)
+			return key, key != ""
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,59 @@
+package kafkabp
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	t.Run("attach", func(t *testing.T) {
+		msg := &sarama.ProducerMessage{}
+		if err := AttachIdempotencyKey(msg); err != nil {
+			t.Fatal(err)
+		}
+		if len(msg.Headers) != 1 || string(msg.Headers[0].Key) != HeaderIdempotencyKey {
+			t.Fatalf("expected an %q header, got %v", HeaderIdempotencyKey, msg.Headers)
+		}
+		if len(msg.Headers[0].Value) == 0 {
+			t.Error("expected a non-empty idempotency key")
+		}
+
+		other := &sarama.ProducerMessage{}
+		if err := AttachIdempotencyKey(other); err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.Headers[0].Value) == string(other.Headers[0].Value) {
+			t.Errorf("expected unique idempotency keys, got %q twice", msg.Headers[0].Value)
+		}
+	})
+
+	t.Run("keep-existing", func(t *testing.T) {
+		msg := &sarama.ProducerMessage{
+			Headers: []sarama.RecordHeader{
+				{Key: []byte(HeaderIdempotencyKey), Value: []byte("caller-key")},
+			},
+		}
+		if err := AttachIdempotencyKey(msg); err != nil {
+			t.Fatal(err)
+		}
+		if len(msg.Headers) != 1 || string(msg.Headers[0].Value) != "caller-key" {
+			t.Errorf("expected the caller provided key to be kept, got %v", msg.Headers)
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		m := getTestKafkaMessage("key", "value")
+		if key, ok := IdempotencyKey(m); ok {
+			t.Errorf("expected no idempotency key, got %q", key)
+		}
+
+		m.Headers = []*sarama.RecordHeader{
+			{Key: []byte("foo"), Value: []byte("bar")},
+			{Key: []byte(HeaderIdempotencyKey), Value: []byte("caller-key")},
+		}
+		if key, ok := IdempotencyKey(m); !ok || key != "caller-key" {
+			t.Errorf("expected (%q, true), got (%q, %v)", "caller-key", key, ok)
+		}
+	})
+}
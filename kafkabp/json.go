@@ -0,0 +1,82 @@
+package kafkabp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+)
+
+// JSONMessageFunc is the typed handler called by the ConsumeMessageFunc
+// returned by JSONConsumer, with v being the decoded message value.
+type JSONMessageFunc func(ctx context.Context, msg *sarama.ConsumerMessage, v interface{}) error
+
+// JSONDecodeErrorFunc handles the messages JSONConsumer failed to decode, err
+// being a JSONDecodeError.
+type JSONDecodeErrorFunc func(ctx context.Context, msg *sarama.ConsumerMessage, err error) error
+
+// JSONDecodeError is the error returned when the value of a message is not
+// valid JSON for the type it's decoded into.
+type JSONDecodeError struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+
+	Cause error
+}
+
+func (e JSONDecodeError) Error() string {
+	return fmt.Sprintf(
+		"kafkabp: failed to decode json message: topic=%q partition=%d offset=%d: %v",
+		e.Topic,
+		e.Partition,
+		e.Offset,
+		e.Cause,
+	)
+}
+
+// Unwrap returns the underlying json error.
+func (e JSONDecodeError) Unwrap() error {
+	return e.Cause
+}
+
+// JSONConsumer returns a ConsumeMessageFunc that decodes the value of every
+// message as JSON into a new value returned by newValue, then passes it to fn.
+//
+// newValue must return a pointer for json.Unmarshal to decode into, for
+// example:
+//
+//     kafkabp.JSONConsumer(
+//         func() interface{} {
+//             return new(MyEvent)
+//         },
+//         func(ctx context.Context, msg *sarama.ConsumerMessage, v interface{}) error {
+//             event := v.(*MyEvent)
+//             // ...
+//         },
+//         nil, // decodeErrorFunc
+//     )
+//
+// When the value fails to decode, fn is not called and the JSONDecodeError is
+// passed to decodeErrorFunc, and its result is returned. When decodeErrorFunc
+// is nil, the JSONDecodeError is returned directly, so it can be handled by
+// the wrappers like WithDeadLetter.
+func JSONConsumer(newValue func() interface{}, fn JSONMessageFunc, decodeErrorFunc JSONDecodeErrorFunc) ConsumeMessageFunc {
+	return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		v := newValue()
+		if err := json.Unmarshal(msg.Value, v); err != nil {
+			err = JSONDecodeError{
+				Topic:     msg.Topic,
+				Partition: msg.Partition,
+				Offset:    msg.Offset,
+				Cause:     err,
+			}
+			if decodeErrorFunc != nil {
+				return decodeErrorFunc(ctx, msg, err)
+			}
+			return err
+		}
+		return fn(ctx, msg, v)
+	}
+}
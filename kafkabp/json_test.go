@@ -0,0 +1,73 @@
+package kafkabp
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+type jsonTestEvent struct {
+	ID string `json:"id"`
+}
+
+func TestJSONConsumer(t *testing.T) {
+	newValue := func() interface{} {
+		return new(jsonTestEvent)
+	}
+	var ids []string
+	fn := func(_ context.Context, _ *sarama.ConsumerMessage, v interface{}) error {
+		ids = append(ids, v.(*jsonTestEvent).ID)
+		return nil
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		ids = nil
+		consume := JSONConsumer(newValue, fn, nil)
+		if err := consume(context.Background(), &sarama.ConsumerMessage{
+			Value: []byte(`{"id":"foo"}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 1 || ids[0] != "foo" {
+			t.Errorf("expected ids [foo], got %v", ids)
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		ids = nil
+		consume := JSONConsumer(newValue, fn, nil)
+		err := consume(context.Background(), &sarama.ConsumerMessage{
+			Topic:  "topic",
+			Offset: 42,
+			Value:  []byte(`{"id":`),
+		})
+		var decodeErr JSONDecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Fatalf("expected JSONDecodeError, got %v", err)
+		}
+		if decodeErr.Topic != "topic" || decodeErr.Offset != 42 {
+			t.Errorf("unexpected JSONDecodeError: %+v", decodeErr)
+		}
+		if len(ids) != 0 {
+			t.Errorf("expected fn not to be called, got ids %v", ids)
+		}
+	})
+
+	t.Run("decode-error-func", func(t *testing.T) {
+		var handled error
+		consume := JSONConsumer(newValue, fn, func(_ context.Context, _ *sarama.ConsumerMessage, err error) error {
+			handled = err
+			return nil
+		})
+		if err := consume(context.Background(), &sarama.ConsumerMessage{
+			Value: []byte(`not json`),
+		}); err != nil {
+			t.Errorf("expected the result of decodeErrorFunc, got %v", err)
+		}
+		if !errors.As(handled, new(JSONDecodeError)) {
+			t.Errorf("expected decodeErrorFunc to get JSONDecodeError, got %v", handled)
+		}
+	})
+}
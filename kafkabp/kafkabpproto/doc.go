@@ -0,0 +1,6 @@
+// Package kafkabpproto provides helpers to consume and publish kafka messages
+// carrying protobuf.
+//
+// It's a separate package from kafkabp, so that only the users importing it
+// depend on the protobuf library.
+package kafkabpproto
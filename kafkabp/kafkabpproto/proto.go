@@ -0,0 +1,111 @@
+package kafkabpproto
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/protobuf/proto"
+
+	"github.com/reddit/baseplate.go/kafkabp"
+)
+
+// MessageFunc is the typed handler called by the ConsumeMessageFunc returned
+// by ProtoConsumer, with v being the decoded message value.
+type MessageFunc func(ctx context.Context, msg *sarama.ConsumerMessage, v proto.Message) error
+
+// DecodeErrorFunc handles the messages ProtoConsumer failed to decode, err
+// being a DecodeError.
+type DecodeErrorFunc func(ctx context.Context, msg *sarama.ConsumerMessage, err error) error
+
+// DecodeError is the error returned when the value of a message is not valid
+// protobuf for the type it's decoded into.
+type DecodeError struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+
+	Cause error
+}
+
+func (e DecodeError) Error() string {
+	return fmt.Sprintf(
+		"kafkabpproto: failed to decode protobuf message: topic=%q partition=%d offset=%d: %v",
+		e.Topic,
+		e.Partition,
+		e.Offset,
+		e.Cause,
+	)
+}
+
+// Unwrap returns the underlying protobuf error.
+func (e DecodeError) Unwrap() error {
+	return e.Cause
+}
+
+// ProtoConsumer returns a kafkabp.ConsumeMessageFunc that decodes the value of
+// every message as protobuf into a new message of the same type as msgProto,
+// then passes it to fn.
+//
+// msgProto is only used as the prototype and never modified, for example:
+//
+//     kafkabpproto.ProtoConsumer(
+//         &pb.MyEvent{},
+//         func(ctx context.Context, msg *sarama.ConsumerMessage, v proto.Message) error {
+//             event := v.(*pb.MyEvent)
+//             // ...
+//         },
+//         nil, // decodeErrorFunc
+//     )
+//
+// When the value fails to decode, fn is not called and the DecodeError is
+// passed to decodeErrorFunc, and its result is returned. When decodeErrorFunc
+// is nil, the DecodeError is returned directly, so it can be handled by the
+// wrappers like kafkabp.WithDeadLetter.
+func ProtoConsumer(msgProto proto.Message, fn MessageFunc, decodeErrorFunc DecodeErrorFunc) kafkabp.ConsumeMessageFunc {
+	return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		v := proto.Clone(msgProto)
+		v.Reset()
+		if err := proto.Unmarshal(msg.Value, v); err != nil {
+			err = DecodeError{
+				Topic:     msg.Topic,
+				Partition: msg.Partition,
+				Offset:    msg.Offset,
+				Cause:     err,
+			}
+			if decodeErrorFunc != nil {
+				return decodeErrorFunc(ctx, msg, err)
+			}
+			return err
+		}
+		return fn(ctx, msg, v)
+	}
+}
+
+// PublishProto encodes m as protobuf and publishes it to topic with key (which
+// can be nil) via producer, with the tracing headers of the span in ctx (see
+// kafkabp.AttachTracingHeaders).
+//
+// It returns the partition and offset the message is published to.
+func PublishProto(
+	ctx context.Context,
+	producer sarama.SyncProducer,
+	topic string,
+	key []byte,
+	m proto.Message,
+) (partition int32, offset int64, err error) {
+	value, err := proto.Marshal(m)
+	if err != nil {
+		return 0, 0, fmt.Errorf("kafkabpproto: failed to encode protobuf message: %w", err)
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Value: sarama.ByteEncoder(value),
+	}
+	if key != nil {
+		msg.Key = sarama.ByteEncoder(key)
+	}
+	kafkabp.AttachTracingHeaders(ctx, msg)
+	return producer.SendMessage(msg)
+}
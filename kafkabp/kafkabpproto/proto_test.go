@@ -0,0 +1,118 @@
+package kafkabpproto
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+)
+
+func TestProtoConsumer(t *testing.T) {
+	prototype := &wrappers.StringValue{Value: "prototype"}
+	value, err := proto.Marshal(&wrappers.StringValue{Value: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("decoded", func(t *testing.T) {
+		var got []string
+		fn := ProtoConsumer(
+			prototype,
+			func(_ context.Context, _ *sarama.ConsumerMessage, v proto.Message) error {
+				got = append(got, v.(*wrappers.StringValue).GetValue())
+				return nil
+			},
+			nil,
+		)
+		for i := 0; i < 2; i++ {
+			if err := fn(context.Background(), &sarama.ConsumerMessage{Value: value}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// An empty message decodes into the zero value, not the prototype.
+		if err := fn(context.Background(), &sarama.ConsumerMessage{}); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 3 || got[0] != "foo" || got[1] != "foo" || got[2] != "" {
+			t.Errorf("expected [foo foo \"\"], got %q", got)
+		}
+		if prototype.GetValue() != "prototype" {
+			t.Errorf("expected the prototype to be unchanged, got %v", prototype)
+		}
+	})
+
+	t.Run("decode-error", func(t *testing.T) {
+		fn := ProtoConsumer(
+			prototype,
+			func(context.Context, *sarama.ConsumerMessage, proto.Message) error {
+				t.Error("fn should not be called")
+				return nil
+			},
+			nil,
+		)
+		msg := &sarama.ConsumerMessage{
+			Topic:     "topic",
+			Partition: 1,
+			Offset:    2,
+			Value:     []byte{0xff},
+		}
+		err := fn(context.Background(), msg)
+		var decodeErr DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Fatalf("expected DecodeError, got %v", err)
+		}
+		if decodeErr.Topic != "topic" || decodeErr.Partition != 1 || decodeErr.Offset != 2 {
+			t.Errorf("unexpected DecodeError %#v", decodeErr)
+		}
+	})
+
+	t.Run("decode-error-func", func(t *testing.T) {
+		expected := errors.New("handled")
+		fn := ProtoConsumer(
+			prototype,
+			func(context.Context, *sarama.ConsumerMessage, proto.Message) error {
+				t.Error("fn should not be called")
+				return nil
+			},
+			func(_ context.Context, _ *sarama.ConsumerMessage, err error) error {
+				if !errors.As(err, new(DecodeError)) {
+					t.Errorf("expected DecodeError, got %v", err)
+				}
+				return expected
+			},
+		)
+		err := fn(context.Background(), &sarama.ConsumerMessage{Value: []byte{0xff}})
+		if !errors.Is(err, expected) {
+			t.Errorf("expected error %v, got %v", expected, err)
+		}
+	})
+}
+
+func TestPublishProto(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithCheckerFunctionAndSucceed(func(value []byte) error {
+		var v wrappers.StringValue
+		if err := proto.Unmarshal(value, &v); err != nil {
+			return err
+		}
+		if v.GetValue() != "foo" {
+			return errors.New("unexpected value " + v.GetValue())
+		}
+		return nil
+	})
+	defer producer.Close()
+
+	if _, _, err := PublishProto(
+		context.Background(),
+		producer,
+		"topic",
+		[]byte("key"),
+		&wrappers.StringValue{Value: "foo"},
+	); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,3 @@
+// Package kafkabptest contains objects and utility methods to aid with testing
+// code using kafkabp consumers.
+package kafkabptest
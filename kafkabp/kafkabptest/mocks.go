@@ -0,0 +1,333 @@
+package kafkabptest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/reddit/baseplate.go/kafkabp"
+)
+
+// ErrMockConsumerClosed is returned by MockConsumer.InjectMessage and
+// MockConsumer.InjectError when the MockConsumer is closed before Consume is
+// called.
+var ErrMockConsumerClosed = errors.New("kafkabptest: MockConsumer is closed")
+
+// MockConsumer implements kafkabp.Consumer without sarama or kafka brokers,
+// and can be used to unit test the ConsumeMessageFunc and ConsumeErrorFunc
+// registered via Consume.
+//
+// Like a real consumer, Consume blocks until Close is called, so it's usually
+// called in a separate goroutine. Messages and errors injected via
+// InjectMessage and InjectError are then passed to the registered functions
+// synchronously.
+//
+// MockConsumer is provided to help with unit testing and should not be used in
+// production code.
+type MockConsumer struct {
+	lock         sync.Mutex
+	messagesFunc kafkabp.ConsumeMessageFunc
+	errorsFunc   kafkabp.ConsumeErrorContextFunc
+	paused       bool
+	partitions   map[int32]bool
+	offsets      map[int32]int64
+	positions    map[int32]int64
+	hwms         map[int32]int64
+	rateLimit    float64
+	burst        int
+	lastMessage  time.Time
+
+	consuming     chan struct{}
+	consumingOnce sync.Once
+	closed        chan struct{}
+	closeOnce     sync.Once
+}
+
+var _ kafkabp.Consumer = (*MockConsumer)(nil)
+
+// NewMockConsumer returns a pointer to a new MockConsumer.
+func NewMockConsumer() *MockConsumer {
+	return &MockConsumer{
+		consuming: make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+}
+
+// Close implements kafkabp.Consumer.
+//
+// It makes Consume return, and IsHealthy return false thereafter.
+func (c *MockConsumer) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+// Shutdown implements kafkabp.Consumer and is the same as Close.
+func (c *MockConsumer) Shutdown(ctx context.Context) error {
+	return c.Close()
+}
+
+// CloseWithTimeout implements kafkabp.Consumer and is the same as Close.
+func (c *MockConsumer) CloseWithTimeout(timeout time.Duration) error {
+	return c.Close()
+}
+
+// Closed returns true if Close or Shutdown was called.
+func (c *MockConsumer) Closed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Consume implements kafkabp.Consumer.
+//
+// It registers messagesFunc and errorsFunc then blocks until Close is called.
+func (c *MockConsumer) Consume(
+	messagesFunc kafkabp.ConsumeMessageFunc,
+	errorsFunc kafkabp.ConsumeErrorFunc,
+) error {
+	return c.ConsumeContext(context.Background(), messagesFunc, errorsFunc)
+}
+
+// ConsumeContext implements kafkabp.Consumer.
+//
+// It's the same as Consume, but also closes the MockConsumer when ctx is done.
+func (c *MockConsumer) ConsumeContext(
+	ctx context.Context,
+	messagesFunc kafkabp.ConsumeMessageFunc,
+	errorsFunc kafkabp.ConsumeErrorFunc,
+) error {
+	return c.ConsumeWithErrorContext(ctx, messagesFunc, kafkabp.IgnoreErrorContext(errorsFunc))
+}
+
+// ConsumeWithErrorContext implements kafkabp.Consumer.
+//
+// It's the same as ConsumeContext, with the errors passed to errorsFunc with a
+// background context.
+func (c *MockConsumer) ConsumeWithErrorContext(
+	ctx context.Context,
+	messagesFunc kafkabp.ConsumeMessageFunc,
+	errorsFunc kafkabp.ConsumeErrorContextFunc,
+) error {
+	c.lock.Lock()
+	c.messagesFunc = messagesFunc
+	c.errorsFunc = errorsFunc
+	c.lock.Unlock()
+	c.consumingOnce.Do(func() {
+		close(c.consuming)
+	})
+
+	select {
+	case <-c.closed:
+	case <-ctx.Done():
+		c.Close()
+	}
+	return nil
+}
+
+// registered waits until Consume is called, then returns the registered
+// functions.
+//
+// It returns ErrMockConsumerClosed if the MockConsumer is closed before that.
+func (c *MockConsumer) registered() (kafkabp.ConsumeMessageFunc, kafkabp.ConsumeErrorContextFunc, error) {
+	select {
+	case <-c.consuming:
+	case <-c.closed:
+		return nil, nil, ErrMockConsumerClosed
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.messagesFunc, c.errorsFunc, nil
+}
+
+// InjectMessage passes msg to the ConsumeMessageFunc registered via Consume
+// with a background context, and returns the error it returns.
+//
+// If Consume is not called yet, it blocks until Consume or Close is called.
+// msg is delivered regardless of Pause and PausePartition.
+func (c *MockConsumer) InjectMessage(msg *sarama.ConsumerMessage) error {
+	messagesFunc, _, err := c.registered()
+	if err != nil {
+		return err
+	}
+	if err := messagesFunc(context.Background(), msg); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.lastMessage = time.Now()
+	if c.positions == nil {
+		c.positions = make(map[int32]int64)
+	}
+	c.positions[msg.Partition] = msg.Offset + 1
+	return nil
+}
+
+// InjectError passes err to the ConsumeErrorFunc registered via Consume.
+//
+// If Consume is not called yet, it blocks until Consume or Close is called, in
+// which case it returns ErrMockConsumerClosed.
+func (c *MockConsumer) InjectError(err error) error {
+	_, errorsFunc, registerErr := c.registered()
+	if registerErr != nil {
+		return registerErr
+	}
+	errorsFunc(context.Background(), err)
+	return nil
+}
+
+// Seek implements kafkabp.Consumer.
+//
+// It only records offset, which can be read back via SeekOffset.
+func (c *MockConsumer) Seek(partition int32, offset int64) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.offsets == nil {
+		c.offsets = make(map[int32]int64)
+	}
+	c.offsets[partition] = offset
+	return nil
+}
+
+// SeekOffset returns the offset passed to the last Seek call of partition.
+func (c *MockConsumer) SeekOffset(partition int32) (offset int64, ok bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	offset, ok = c.offsets[partition]
+	return
+}
+
+// Offsets implements kafkabp.Consumer.
+//
+// It returns the offset following the last message of each partition injected
+// via InjectMessage and handled successfully.
+func (c *MockConsumer) Offsets() map[int32]int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return copyOffsets(c.positions)
+}
+
+// HighWaterMarks implements kafkabp.Consumer.
+//
+// It returns the high water marks set via SetHighWaterMark.
+func (c *MockConsumer) HighWaterMarks() map[int32]int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return copyOffsets(c.hwms)
+}
+
+// SetHighWaterMark sets the high water mark of partition returned by
+// HighWaterMarks.
+func (c *MockConsumer) SetHighWaterMark(partition int32, offset int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.hwms == nil {
+		c.hwms = make(map[int32]int64)
+	}
+	c.hwms[partition] = offset
+}
+
+func copyOffsets(offsets map[int32]int64) map[int32]int64 {
+	copied := make(map[int32]int64, len(offsets))
+	for p, offset := range offsets {
+		copied[p] = offset
+	}
+	return copied
+}
+
+// SetRateLimit implements kafkabp.Consumer.
+//
+// It only records rate and burst, which can be read back via RateLimit.
+func (c *MockConsumer) SetRateLimit(rate float64, burst int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.rateLimit = rate
+	c.burst = burst
+}
+
+// RateLimit returns the rate and burst passed to the last SetRateLimit call.
+func (c *MockConsumer) RateLimit() (rate float64, burst int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.rateLimit, c.burst
+}
+
+// Pause implements kafkabp.Consumer.
+//
+// It only changes the result of IsPaused.
+func (c *MockConsumer) Pause() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.paused = true
+}
+
+// Resume implements kafkabp.Consumer.
+//
+// It only changes the result of IsPaused.
+func (c *MockConsumer) Resume() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.paused = false
+	c.partitions = nil
+}
+
+// PausePartition implements kafkabp.Consumer.
+//
+// It only changes the result of IsPaused.
+func (c *MockConsumer) PausePartition(partition int32) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.partitions == nil {
+		c.partitions = make(map[int32]bool)
+	}
+	c.partitions[partition] = true
+}
+
+// ResumePartition implements kafkabp.Consumer.
+//
+// It only changes the result of IsPaused.
+func (c *MockConsumer) ResumePartition(partition int32) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.partitions, partition)
+}
+
+// IsPaused implements kafkabp.Consumer.
+func (c *MockConsumer) IsPaused() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.paused || len(c.partitions) > 0
+}
+
+// LastMessageTime implements kafkabp.Consumer.
+//
+// It returns the time the last message injected via InjectMessage was
+// handled successfully.
+func (c *MockConsumer) LastMessageTime() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lastMessage
+}
+
+// HealthyWithin implements kafkabp.Consumer.
+//
+// It returns true if a message injected via InjectMessage was handled
+// successfully within d.
+func (c *MockConsumer) HealthyWithin(d time.Duration) bool {
+	last := c.LastMessageTime()
+	return !last.IsZero() && time.Since(last) <= d
+}
+
+// IsHealthy implements kafkabp.Consumer.
+//
+// It returns true until Close is called, then false thereafter.
+func (c *MockConsumer) IsHealthy() bool {
+	return !c.Closed()
+}
@@ -0,0 +1,109 @@
+package kafkabptest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/reddit/baseplate.go/kafkabp/kafkabptest"
+)
+
+func TestMockConsumer(t *testing.T) {
+	c := kafkabptest.NewMockConsumer()
+
+	handlerErr := errors.New("handler")
+	var messages []*sarama.ConsumerMessage
+	var errs []error
+	consumeReturned := make(chan error)
+	go func() {
+		consumeReturned <- c.Consume(
+			func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+				messages = append(messages, msg)
+				if string(msg.Value) == "fail" {
+					return handlerErr
+				}
+				return nil
+			},
+			func(err error) {
+				errs = append(errs, err)
+			},
+		)
+	}()
+
+	if !c.IsHealthy() {
+		t.Error("Expected MockConsumer to be healthy before Close")
+	}
+
+	if err := c.InjectMessage(&sarama.ConsumerMessage{Value: []byte("hello")}); err != nil {
+		t.Errorf("Expected no error from InjectMessage, got %v", err)
+	}
+	if err := c.InjectMessage(&sarama.ConsumerMessage{Value: []byte("fail")}); !errors.Is(err, handlerErr) {
+		t.Errorf("Expected InjectMessage to return %v, got %v", handlerErr, err)
+	}
+	if c.LastMessageTime().IsZero() || !c.HealthyWithin(time.Minute) {
+		t.Error("Expected LastMessageTime to be set after InjectMessage")
+	}
+	if len(messages) != 2 {
+		t.Errorf("Expected 2 messages to be consumed, got %d", len(messages))
+	}
+	if offsets := c.Offsets(); len(offsets) != 1 || offsets[0] != 1 {
+		t.Errorf("Expected Offsets to be map[0:1], got %v", offsets)
+	}
+	c.SetHighWaterMark(0, 5)
+	if hwms := c.HighWaterMarks(); len(hwms) != 1 || hwms[0] != 5 {
+		t.Errorf("Expected HighWaterMarks to be map[0:5], got %v", hwms)
+	}
+
+	kafkaErr := errors.New("kafka")
+	if err := c.InjectError(kafkaErr); err != nil {
+		t.Errorf("Expected no error from InjectError, got %v", err)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], kafkaErr) {
+		t.Errorf("Expected errors to be [%v], got %v", kafkaErr, errs)
+	}
+
+	c.SetRateLimit(10, 2)
+	if rate, burst := c.RateLimit(); rate != 10 || burst != 2 {
+		t.Errorf("Expected RateLimit to return (10, 2), got (%v, %d)", rate, burst)
+	}
+
+	c.PausePartition(1)
+	if !c.IsPaused() {
+		t.Error("Expected MockConsumer to be paused after PausePartition")
+	}
+	c.Resume()
+	if c.IsPaused() {
+		t.Error("Expected MockConsumer to not be paused after Resume")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-consumeReturned:
+		if err != nil {
+			t.Errorf("Expected Consume to return nil, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Consume to return after Close")
+	}
+	if !c.Closed() {
+		t.Error("Expected MockConsumer to be closed")
+	}
+	if c.IsHealthy() {
+		t.Error("Expected MockConsumer to be unhealthy after Close")
+	}
+}
+
+func TestMockConsumerClosedBeforeConsume(t *testing.T) {
+	c := kafkabptest.NewMockConsumer()
+	c.Close()
+
+	err := c.InjectMessage(&sarama.ConsumerMessage{})
+	if !errors.Is(err, kafkabptest.ErrMockConsumerClosed) {
+		t.Errorf("Expected error %v, got %v", kafkabptest.ErrMockConsumerClosed, err)
+	}
+}
@@ -0,0 +1,79 @@
+package kafkabp
+
+import (
+	"context"
+
+	"github.com/Shopify/sarama"
+)
+
+// ConsumeMiddleware wraps the given ConsumeMessageFunc and returns a new,
+// wrapped, ConsumeMessageFunc.
+type ConsumeMiddleware func(next ConsumeMessageFunc) ConsumeMessageFunc
+
+// Chain composes the given ConsumeMiddlewares into a single one.
+//
+// Middlewares will be called in the order that they are defined:
+//
+//		1. Middlewares[0]
+//		2. Middlewares[1]
+//		...
+//		N. Middlewares[n]
+//
+// For example:
+//
+//     consumer.Consume(
+//         kafkabp.Chain(
+//             kafkabp.DeadLetterMiddleware(dlqConfig),
+//             kafkabp.RetryMiddleware(policy),
+//         )(handler),
+//         errorsFunc,
+//     )
+func Chain(middlewares ...ConsumeMiddleware) ConsumeMiddleware {
+	return func(next ConsumeMessageFunc) ConsumeMessageFunc {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// TracingMiddleware is a ConsumeMiddleware that handles every message within a
+// span started by BaseplateSpanStarter.
+//
+// The consumers created by NewConsumer and NewGroupConsumer already apply
+// SpanMiddleware to the ConsumeMessageFunc passed to Consume, so it's only
+// needed when the ConsumeMessageFunc is called in other ways.
+func TracingMiddleware(next ConsumeMessageFunc) ConsumeMessageFunc {
+	return SpanMiddleware(BaseplateSpanStarter{})(next)
+}
+
+// SpanMiddleware returns a ConsumeMiddleware that handles every message within
+// a span started by starter.
+func SpanMiddleware(starter SpanStarter) ConsumeMiddleware {
+	return func(next ConsumeMessageFunc) ConsumeMessageFunc {
+		return func(ctx context.Context, m *sarama.ConsumerMessage) (err error) {
+			ctx, finish := starter.StartSpan(ctx, m)
+			defer func() {
+				finish(err)
+			}()
+
+			return next(ctx, m)
+		}
+	}
+}
+
+// RetryMiddleware returns a ConsumeMiddleware that applies WithRetry with
+// policy.
+func RetryMiddleware(policy RetryPolicy) ConsumeMiddleware {
+	return func(next ConsumeMessageFunc) ConsumeMessageFunc {
+		return WithRetry(next, policy)
+	}
+}
+
+// DeadLetterMiddleware returns a ConsumeMiddleware that applies WithDeadLetter
+// with cfg.
+func DeadLetterMiddleware(cfg DeadLetterConfig) ConsumeMiddleware {
+	return func(next ConsumeMessageFunc) ConsumeMessageFunc {
+		return WithDeadLetter(next, cfg)
+	}
+}
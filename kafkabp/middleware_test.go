@@ -0,0 +1,52 @@
+package kafkabp
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	middleware := func(name string) ConsumeMiddleware {
+		return func(next ConsumeMessageFunc) ConsumeMessageFunc {
+			return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+				calls = append(calls, name)
+				return next(ctx, msg)
+			}
+		}
+	}
+
+	fn := Chain(
+		middleware("first"),
+		middleware("second"),
+		middleware("third"),
+	)(func(context.Context, *sarama.ConsumerMessage) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+	if err := fn(context.Background(), &sarama.ConsumerMessage{}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"first", "second", "third", "handler"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls %v, got %v", expected, calls)
+	}
+}
+
+func TestChainEmpty(t *testing.T) {
+	called := false
+	fn := Chain()(func(context.Context, *sarama.ConsumerMessage) error {
+		called = true
+		return nil
+	})
+	if err := fn(context.Background(), &sarama.ConsumerMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Error("expected the handler to be called")
+	}
+}
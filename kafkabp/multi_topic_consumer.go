@@ -0,0 +1,208 @@
+package kafkabp
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/reddit/baseplate.go/errorsbp"
+)
+
+// multiTopicConsumer is the Consumer created by NewConsumer when
+// ConsumerConfig specifies multiple topics.
+//
+// It runs a consumer for every topic with the same ConsumeMessageFunc and
+// lifecycle.
+type multiTopicConsumer struct {
+	consumers []*consumer
+}
+
+var _ Consumer = (*multiTopicConsumer)(nil)
+
+// Close closes the consumers of all topics, see Shutdown.
+func (mc *multiTopicConsumer) Close() error {
+	return mc.Shutdown(context.Background())
+}
+
+// Shutdown shuts down the consumers of all topics concurrently, see
+// Consumer.Shutdown.
+func (mc *multiTopicConsumer) Shutdown(ctx context.Context) error {
+	return mc.forEach(func(kc *consumer) error {
+		return kc.Shutdown(ctx)
+	})
+}
+
+// CloseWithTimeout is the same as Shutdown with a context timing out after
+// timeout.
+func (mc *multiTopicConsumer) CloseWithTimeout(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return mc.Shutdown(ctx)
+}
+
+// Consume consumes all topics, and blocks until the consumer is closed.
+//
+// When the consumer of a topic fails, the consumers of the other topics are
+// closed as well.
+func (mc *multiTopicConsumer) Consume(
+	messagesFunc ConsumeMessageFunc,
+	errorsFunc ConsumeErrorFunc,
+) error {
+	return mc.ConsumeContext(context.Background(), messagesFunc, errorsFunc)
+}
+
+// ConsumeContext is like Consume, but also closes the consumer when ctx is
+// done.
+func (mc *multiTopicConsumer) ConsumeContext(
+	ctx context.Context,
+	messagesFunc ConsumeMessageFunc,
+	errorsFunc ConsumeErrorFunc,
+) error {
+	return mc.ConsumeWithErrorContext(ctx, messagesFunc, IgnoreErrorContext(errorsFunc))
+}
+
+// ConsumeWithErrorContext is like ConsumeContext, but the errors are passed to
+// errorsFunc along with their context.
+func (mc *multiTopicConsumer) ConsumeWithErrorContext(
+	ctx context.Context,
+	messagesFunc ConsumeMessageFunc,
+	errorsFunc ConsumeErrorContextFunc,
+) error {
+	return mc.forEach(func(kc *consumer) error {
+		err := kc.ConsumeWithErrorContext(ctx, messagesFunc, errorsFunc)
+		if err != nil {
+			if closeErr := mc.Close(); closeErr != nil {
+				kc.cfg.Logger.Log(context.Background(), "kafkabp.multiTopicConsumer.ConsumeContext: Error closing the consumers:"+closeErr.Error())
+			}
+		}
+		return err
+	})
+}
+
+// forEach calls fn with the consumers of all topics concurrently, and returns
+// the errors they return combined.
+func (mc *multiTopicConsumer) forEach(fn func(kc *consumer) error) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(mc.consumers))
+	for i, kc := range mc.consumers {
+		wg.Add(1)
+		go func(i int, kc *consumer) {
+			defer wg.Done()
+			errs[i] = fn(kc)
+		}(i, kc)
+	}
+	wg.Wait()
+
+	var batch errorsbp.Batch
+	batch.Add(errs...)
+	return batch.Compile()
+}
+
+// Seek always returns ErrSeekMultipleTopics.
+func (mc *multiTopicConsumer) Seek(partition int32, offset int64) error {
+	return ErrSeekMultipleTopics
+}
+
+// SetRateLimit implements Consumer.
+//
+// The limit is shared by all topics.
+func (mc *multiTopicConsumer) SetRateLimit(rate float64, burst int) {
+	mc.consumers[0].SetRateLimit(rate, burst)
+}
+
+// Pause implements Consumer.
+func (mc *multiTopicConsumer) Pause() {
+	for _, kc := range mc.consumers {
+		kc.Pause()
+	}
+}
+
+// Resume implements Consumer.
+func (mc *multiTopicConsumer) Resume() {
+	for _, kc := range mc.consumers {
+		kc.Resume()
+	}
+}
+
+// PausePartition pauses partition of every topic.
+func (mc *multiTopicConsumer) PausePartition(partition int32) {
+	for _, kc := range mc.consumers {
+		kc.PausePartition(partition)
+	}
+}
+
+// ResumePartition resumes partition of every topic.
+func (mc *multiTopicConsumer) ResumePartition(partition int32) {
+	for _, kc := range mc.consumers {
+		kc.ResumePartition(partition)
+	}
+}
+
+// IsPaused implements Consumer.
+func (mc *multiTopicConsumer) IsPaused() bool {
+	for _, kc := range mc.consumers {
+		if kc.IsPaused() {
+			return true
+		}
+	}
+	return false
+}
+
+// Offsets always returns nil, as the partitions of different topics can't be
+// told apart.
+func (mc *multiTopicConsumer) Offsets() map[int32]int64 {
+	return nil
+}
+
+// HighWaterMarks always returns nil, as the partitions of different topics
+// can't be told apart.
+func (mc *multiTopicConsumer) HighWaterMarks() map[int32]int64 {
+	return nil
+}
+
+// IsHealthy returns false after the Consume of any topic returns, or when no
+// message of any topic was handled successfully within StalenessThreshold.
+//
+// With ReplayUntilCaughtUp, the topics caught up are not counted, so it only
+// returns false after all of them are caught up.
+func (mc *multiTopicConsumer) IsHealthy() bool {
+	running := 0
+	for _, kc := range mc.consumers {
+		if atomic.LoadInt64(&kc.consumeReturned) == 0 {
+			running++
+			continue
+		}
+		if !kc.replayCaughtUp() {
+			return false
+		}
+	}
+	if running == 0 {
+		return false
+	}
+	threshold := mc.consumers[0].cfg.StalenessThreshold
+	return threshold <= 0 || mc.HealthyWithin(threshold)
+}
+
+// LastMessageTime returns the time the last message of any topic was handled
+// successfully.
+func (mc *multiTopicConsumer) LastMessageTime() time.Time {
+	var last time.Time
+	for _, kc := range mc.consumers {
+		if t := kc.LastMessageTime(); t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+// HealthyWithin returns true if a message of any topic was handled
+// successfully within d.
+func (mc *multiTopicConsumer) HealthyWithin(d time.Duration) bool {
+	for _, kc := range mc.consumers {
+		if kc.HealthyWithin(d) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package kafkabp
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestMultiTopicConsumer(t *testing.T) {
+	topics := []string{"kafkabp-test-1", "kafkabp-test-2"}
+	partitions := []int32{0}
+	limiter := newRateLimiter(0, 0)
+
+	mc := &multiTopicConsumer{}
+	fakes := make([]*fakeConsumer, 0, len(topics))
+	for _, topic := range topics {
+		kc := getTestConsumer(t)
+		kc.cfg.Topic = topic
+		kc.limiter = limiter
+		fake := newFakeConsumer(partitions)
+		kc.consumer.Store(fake)
+		kc.partitions.Store(partitions)
+		mc.consumers = append(mc.consumers, kc)
+		fakes = append(fakes, fake)
+	}
+
+	consumed := make(chan *sarama.ConsumerMessage)
+	consumeReturned := make(chan error)
+	go func() {
+		consumeReturned <- mc.Consume(
+			func(_ context.Context, msg *sarama.ConsumerMessage) error {
+				consumed <- msg
+				return nil
+			},
+			func(error) {},
+		)
+	}()
+
+	for i, fake := range fakes {
+		pc := receivePartitionConsumers(t, fake, 1)[0]
+		if pc.topic != topics[i] {
+			t.Errorf("expected partition consumer of topic %q, got %q", topics[i], pc.topic)
+		}
+		pc.yield(int64(i))
+		if msg := <-consumed; msg.Topic != topics[i] {
+			t.Errorf("expected message of topic %q, got %q", topics[i], msg.Topic)
+		}
+	}
+
+	if err := mc.Seek(0, 1); !errors.Is(err, ErrSeekMultipleTopics) {
+		t.Errorf("expected error %v, got %v", ErrSeekMultipleTopics, err)
+	}
+	if offsets := mc.Offsets(); offsets != nil {
+		t.Errorf("expected nil offsets, got %v", offsets)
+	}
+
+	mc.PausePartition(0)
+	for i, kc := range mc.consumers {
+		if !kc.IsPaused() {
+			t.Errorf("expected the consumer of topic %q to be paused", topics[i])
+		}
+	}
+	mc.Resume()
+
+	if !mc.IsHealthy() {
+		t.Error("expected consumer to be healthy while consuming")
+	}
+	if err := mc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-consumeReturned; err != nil {
+		t.Errorf("expected Consume to return nil, got %v", err)
+	}
+	for i, fake := range fakes {
+		if !fake.isClosed() {
+			t.Errorf("expected the consumer of topic %q to be closed", topics[i])
+		}
+	}
+	if mc.IsHealthy() {
+		t.Error("expected consumer to be unhealthy after Consume returns")
+	}
+}
+
+func TestMultiTopicConsumer_IsHealthyReplay(t *testing.T) {
+	mc := &multiTopicConsumer{}
+	for _, topic := range []string{"kafkabp-test-1", "kafkabp-test-2"} {
+		kc := getTestConsumer(t)
+		kc.cfg.Topic = topic
+		kc.replayTargets = map[int32]int64{0: 10}
+		mc.consumers = append(mc.consumers, kc)
+	}
+	if !mc.IsHealthy() {
+		t.Error("expected consumer to be healthy while replaying")
+	}
+
+	// The first topic caught up and its Consume returned.
+	first := mc.consumers[0]
+	first.replayTargets = map[int32]int64{}
+	atomic.StoreInt64(&first.consumeReturned, 1)
+	if !mc.IsHealthy() {
+		t.Error("expected consumer to be healthy while the other topic is still replaying")
+	}
+
+	second := mc.consumers[1]
+	second.replayTargets = map[int32]int64{}
+	atomic.StoreInt64(&second.consumeReturned, 1)
+	if mc.IsHealthy() {
+		t.Error("expected consumer to be unhealthy after all topics caught up")
+	}
+}
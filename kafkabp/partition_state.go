@@ -0,0 +1,110 @@
+package kafkabp
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// PartitionState is the state of a partition kept across the messages of the
+// partition by WithPartitionState.
+type PartitionState struct {
+	// The topic and partition the state belongs to.
+	Topic     string
+	Partition int32
+
+	// Value is free for the PartitionedConsumeFunc to use, for example to hold
+	// per-key aggregations of the partition. It's nil for the first message of
+	// the partition.
+	Value interface{}
+}
+
+// PartitionedConsumeFunc is a ConsumeMessageFunc that also receives the state
+// of the partition of m, see WithPartitionState.
+type PartitionedConsumeFunc func(ctx context.Context, state *PartitionState, m *sarama.ConsumerMessage) error
+
+// WithPartitionState adapts fn into a ConsumeMessageFunc, which calls fn with
+// the same *PartitionState for every message of the same topic and partition.
+//
+// The calls of fn for the same partition never run concurrently, and are made
+// in the order the messages are consumed (the offset order, unless reordered by
+// ConsumerConfig.PriorityHeader), so fn can access the state without locking.
+// That still holds with ConsumerConfig.MaxConcurrentPerPartition larger than 1,
+// where every call waits for the call of the previous message of the partition
+// to return, which effectively limits the concurrency of fn to 1 per partition.
+// The calls for different partitions still run concurrently.
+//
+// The states are kept for the life of the returned ConsumeMessageFunc. With
+// the group consumer, the state of a partition claimed again after a
+// rebalance is the one left when it was last handled by this instance, and
+// could miss the messages handled by other instances in between, so it should
+// be treated as a cache that can be rebuilt.
+func WithPartitionState(fn PartitionedConsumeFunc) ConsumeMessageFunc {
+	var states partitionStates
+	return func(ctx context.Context, m *sarama.ConsumerMessage) error {
+		waitPreviousMessage(ctx)
+		state := states.get(m.Topic, m.Partition)
+		state.lock.Lock()
+		defer state.lock.Unlock()
+		return fn(ctx, &state.state, m)
+	}
+}
+
+type previousMessageKey struct{}
+
+// withPreviousMessage returns ctx carrying done, the channel closed once the
+// previous message of the same partition is handled, see waitPreviousMessage.
+func withPreviousMessage(ctx context.Context, done <-chan struct{}) context.Context {
+	return context.WithValue(ctx, previousMessageKey{}, done)
+}
+
+// waitPreviousMessage waits for the previous message of the same partition to
+// be handled, when the messages of the partition are handled concurrently.
+//
+// It returns immediately when ctx carries no previous message, for example
+// when the messages are handled one at a time.
+func waitPreviousMessage(ctx context.Context) {
+	if done, ok := ctx.Value(previousMessageKey{}).(<-chan struct{}); ok {
+		<-done
+	}
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// lockedPartitionState is a PartitionState with the lock serializing its
+// access.
+type lockedPartitionState struct {
+	lock  sync.Mutex
+	state PartitionState
+}
+
+// partitionStates are the states of the partitions used by
+// WithPartitionState.
+type partitionStates struct {
+	lock   sync.Mutex
+	states map[topicPartition]*lockedPartitionState
+}
+
+func (s *partitionStates) get(topic string, partition int32) *lockedPartitionState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := topicPartition{topic: topic, partition: partition}
+	state, ok := s.states[key]
+	if !ok {
+		if s.states == nil {
+			s.states = make(map[topicPartition]*lockedPartitionState)
+		}
+		state = &lockedPartitionState{
+			state: PartitionState{
+				Topic:     topic,
+				Partition: partition,
+			},
+		}
+		s.states[key] = state
+	}
+	return state
+}
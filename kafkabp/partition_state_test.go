@@ -0,0 +1,127 @@
+package kafkabp
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestWithPartitionState(t *testing.T) {
+	var running, maxRunning int64
+	handler := WithPartitionState(func(_ context.Context, state *PartitionState, m *sarama.ConsumerMessage) error {
+		if state.Topic != m.Topic || state.Partition != m.Partition {
+			t.Errorf("expected state of %s/%d, got %s/%d", m.Topic, m.Partition, state.Topic, state.Partition)
+		}
+		if m.Partition == 0 {
+			n := atomic.AddInt64(&running, 1)
+			defer atomic.AddInt64(&running, -1)
+			for {
+				max := atomic.LoadInt64(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt64(&maxRunning, max, n) {
+					break
+				}
+			}
+		}
+
+		count, _ := state.Value.(int)
+		state.Value = count + 1
+		return nil
+	})
+
+	const n = 100
+	var wg sync.WaitGroup
+	for _, topic := range []string{"topic-1", "topic-2"} {
+		for _, partition := range []int32{0, 1} {
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(topic string, partition int32) {
+					defer wg.Done()
+					handler(context.Background(), &sarama.ConsumerMessage{
+						Topic:     topic,
+						Partition: partition,
+					})
+				}(topic, partition)
+			}
+		}
+	}
+	wg.Wait()
+
+	if max := atomic.LoadInt64(&maxRunning); max > 2 {
+		// Partition 0 of the two topics could run concurrently.
+		t.Errorf("expected at most 1 concurrent call per partition, got %d across 2 topics", max)
+	}
+
+	var counts []int
+	handler = WithPartitionState(func(_ context.Context, state *PartitionState, m *sarama.ConsumerMessage) error {
+		count, _ := state.Value.(int)
+		count++
+		state.Value = count
+		counts = append(counts, count)
+		return nil
+	})
+	for _, partition := range []int32{0, 0, 1, 0} {
+		handler(context.Background(), &sarama.ConsumerMessage{
+			Topic:     "topic",
+			Partition: partition,
+		})
+	}
+	expected := []int{1, 2, 1, 3}
+	for i := range expected {
+		if counts[i] != expected[i] {
+			t.Errorf("expected counts %v, got %v", expected, counts)
+			break
+		}
+	}
+}
+
+func TestWithPartitionState_MaxConcurrentPerPartition(t *testing.T) {
+	const (
+		total     = 20
+		partition = 1
+	)
+
+	kc := getTestMockConsumer(t)
+	kc.cfg.MaxConcurrentPerPartition = 4
+
+	pc := fakePartitionConsumer{
+		messages: make(chan *sarama.ConsumerMessage, total),
+	}
+	for i := 0; i < total; i++ {
+		pc.messages <- &sarama.ConsumerMessage{
+			Topic:     kc.cfg.Topic,
+			Partition: partition,
+			Offset:    int64(i),
+		}
+	}
+	close(pc.messages)
+
+	var offsets []int64
+	kc.consumeMessages(
+		pc,
+		partition,
+		0, // generation
+		WithPartitionState(func(_ context.Context, _ *PartitionState, m *sarama.ConsumerMessage) error {
+			// Without the ordering, the later messages would be handled first.
+			time.Sleep(time.Duration(total-m.Offset) * time.Millisecond)
+			offsets = append(offsets, m.Offset)
+			return nil
+		}),
+		func(context.Context, error) {},
+	)
+
+	if len(offsets) != total {
+		t.Fatalf("expected %d messages to be handled, got %v", total, offsets)
+	}
+	for i, offset := range offsets {
+		if offset != int64(i) {
+			t.Fatalf("expected the messages to be handled in offset order, got %v", offsets)
+		}
+	}
+	if offset := kc.resumeOffset(partition); offset != total {
+		t.Errorf("expected committed offset %d, got %d", total, offset)
+	}
+}
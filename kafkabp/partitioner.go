@@ -0,0 +1,90 @@
+package kafkabp
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/reddit/baseplate.go/timebp"
+)
+
+// NewTimeBucketPartitioner returns a sarama.PartitionerConstructor that routes
+// messages to partitions by time bucket, for time-series topics where keeping
+// messages of the same time range in the same partition keeps queries local.
+//
+// The message time is read from the header named timestampHeader as
+// milliseconds since EPOCH when timestampHeader is non-empty and the message
+// carries that header, from msg.Timestamp otherwise, and falls back to the
+// current time when neither is set. The time is then bucketed into interval,
+// and the bucket number modulo the number of partitions is the partition.
+//
+// It can be used by setting it as Producer.Partitioner of the sarama.Config
+// used to create the producer.
+//
+// interval must be positive, or the returned partitioner will return
+// ErrTimeBucketIntervalInvalid for every message.
+func NewTimeBucketPartitioner(interval time.Duration, timestampHeader string) sarama.PartitionerConstructor {
+	return func(topic string) sarama.Partitioner {
+		return timeBucketPartitioner{
+			interval: interval,
+			header:   timestampHeader,
+		}
+	}
+}
+
+type timeBucketPartitioner struct {
+	interval time.Duration
+	header   string
+}
+
+// Partition implements sarama.Partitioner.
+func (p timeBucketPartitioner) Partition(msg *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if p.interval <= 0 {
+		return -1, ErrTimeBucketIntervalInvalid
+	}
+
+	ts, err := p.messageTime(msg)
+	if err != nil {
+		return -1, err
+	}
+
+	bucket := ts.UnixNano() / int64(p.interval)
+	partition := int32(bucket % int64(numPartitions))
+	if partition < 0 {
+		// For times before EPOCH.
+		partition += numPartitions
+	}
+	return partition, nil
+}
+
+// RequiresConsistency implements sarama.Partitioner.
+//
+// The same time bucket should always be routed to the same partition.
+func (p timeBucketPartitioner) RequiresConsistency() bool {
+	return true
+}
+
+func (p timeBucketPartitioner) messageTime(msg *sarama.ProducerMessage) (time.Time, error) {
+	if p.header != "" {
+		for _, h := range msg.Headers {
+			if string(h.Key) != p.header {
+				continue
+			}
+			ms, err := strconv.ParseInt(string(h.Value), 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf(
+					"kafkabp: malformed timestamp header %q: %w",
+					p.header,
+					err,
+				)
+			}
+			return timebp.MillisecondsToTime(ms), nil
+		}
+	}
+	if !msg.Timestamp.IsZero() {
+		return msg.Timestamp, nil
+	}
+	return time.Now(), nil
+}
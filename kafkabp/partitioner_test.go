@@ -0,0 +1,101 @@
+package kafkabp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestTimeBucketPartitioner(t *testing.T) {
+	const (
+		interval      = time.Hour
+		numPartitions = 4
+		header        = "x-timestamp"
+	)
+	p := NewTimeBucketPartitioner(interval, header)("topic")
+	if !p.RequiresConsistency() {
+		t.Error("expected RequiresConsistency to be true")
+	}
+
+	// Bucket 100 of the hour interval, which maps to partition 0.
+	bucketStart := time.Unix(0, 0).Add(100 * interval)
+
+	for _, c := range []struct {
+		label     string
+		msg       *sarama.ProducerMessage
+		partition int32
+	}{
+		{
+			label:     "bucket-start",
+			msg:       &sarama.ProducerMessage{Timestamp: bucketStart},
+			partition: 0,
+		},
+		{
+			label:     "bucket-end",
+			msg:       &sarama.ProducerMessage{Timestamp: bucketStart.Add(interval - time.Nanosecond)},
+			partition: 0,
+		},
+		{
+			label:     "next-bucket",
+			msg:       &sarama.ProducerMessage{Timestamp: bucketStart.Add(interval)},
+			partition: 1,
+		},
+		{
+			label:     "wrap-around",
+			msg:       &sarama.ProducerMessage{Timestamp: bucketStart.Add(numPartitions * interval)},
+			partition: 0,
+		},
+		{
+			label:     "before-epoch",
+			msg:       &sarama.ProducerMessage{Timestamp: time.Unix(0, 0).Add(-interval)},
+			partition: 3,
+		},
+		{
+			label: "header",
+			msg: &sarama.ProducerMessage{
+				Timestamp: bucketStart,
+				Headers: []sarama.RecordHeader{
+					{
+						Key:   []byte(header),
+						Value: []byte("363600000"), // bucket 101
+					},
+				},
+			},
+			partition: 1,
+		},
+	} {
+		t.Run(c.label, func(t *testing.T) {
+			partition, err := p.Partition(c.msg, numPartitions)
+			if err != nil {
+				t.Fatalf("Partition returned error: %v", err)
+			}
+			if partition != c.partition {
+				t.Errorf("expected partition %d, got %d", c.partition, partition)
+			}
+		})
+	}
+
+	t.Run("malformed-header", func(t *testing.T) {
+		msg := &sarama.ProducerMessage{
+			Headers: []sarama.RecordHeader{
+				{
+					Key:   []byte(header),
+					Value: []byte("yesterday"),
+				},
+			},
+		}
+		if _, err := p.Partition(msg, numPartitions); err == nil {
+			t.Error("expected error on malformed header, got nil")
+		}
+	})
+
+	t.Run("invalid-interval", func(t *testing.T) {
+		p := NewTimeBucketPartitioner(0, "")("topic")
+		_, err := p.Partition(&sarama.ProducerMessage{}, numPartitions)
+		if !errors.Is(err, ErrTimeBucketIntervalInvalid) {
+			t.Errorf("expected error %v, got %v", ErrTimeBucketIntervalInvalid, err)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package kafkabp
+
+import (
+	"context"
+
+	"github.com/Shopify/sarama"
+)
+
+// partitionsClient is the subset of sarama.Client used by Partitions.
+type partitionsClient interface {
+	Partitions(topic string) ([]int32, error)
+}
+
+var _ partitionsClient = (sarama.Client)(nil)
+
+// Partitions returns the partitions of every topic in cfg (see
+// ConsumerConfig.Topics), keyed by topic, without creating a consumer.
+//
+// It connects to the brokers with a short-lived client, so it can be used to
+// check that the topics exist (sarama.ErrUnknownTopicOrPartition is returned
+// otherwise) and how many partitions they have at startup.
+func Partitions(cfg ConsumerConfig) (map[string][]int32, error) {
+	sc, err := cfg.NewSaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = DefaultLogger
+	}
+
+	client, err := sarama.NewClient(cfg.Brokers, sc)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			cfg.Logger.Log(context.Background(), "kafkabp.Partitions: Error closing the client:"+err.Error())
+		}
+	}()
+	return topicPartitions(client, cfg.topics())
+}
+
+// topicPartitions returns the partitions of topics, keyed by topic.
+func topicPartitions(client partitionsClient, topics []string) (map[string][]int32, error) {
+	partitions := make(map[string][]int32, len(topics))
+	for _, topic := range topics {
+		p, err := client.Partitions(topic)
+		if err != nil {
+			return nil, err
+		}
+		partitions[topic] = p
+	}
+	return partitions, nil
+}
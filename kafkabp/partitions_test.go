@@ -0,0 +1,54 @@
+package kafkabp
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+type fakePartitionsClient map[string][]int32
+
+func (c fakePartitionsClient) Partitions(topic string) ([]int32, error) {
+	partitions, ok := c[topic]
+	if !ok {
+		return nil, sarama.ErrUnknownTopicOrPartition
+	}
+	return partitions, nil
+}
+
+func TestPartitions(t *testing.T) {
+	client := fakePartitionsClient{
+		"topic-1": {0, 1, 2},
+		"topic-2": {0},
+	}
+
+	t.Run("topics", func(t *testing.T) {
+		partitions, err := topicPartitions(client, []string{"topic-1", "topic-2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string][]int32{
+			"topic-1": {0, 1, 2},
+			"topic-2": {0},
+		}
+		if !reflect.DeepEqual(partitions, expected) {
+			t.Errorf("expected partitions %v, got %v", expected, partitions)
+		}
+	})
+
+	t.Run("unknown-topic", func(t *testing.T) {
+		_, err := topicPartitions(client, []string{"topic-1", "topic-3"})
+		if !errors.Is(err, sarama.ErrUnknownTopicOrPartition) {
+			t.Errorf("expected error %v, got %v", sarama.ErrUnknownTopicOrPartition, err)
+		}
+	})
+
+	t.Run("invalid-config", func(t *testing.T) {
+		_, err := Partitions(ConsumerConfig{Topic: "topic-1"})
+		if !errors.Is(err, ErrBrokersEmpty) {
+			t.Errorf("expected error %v, got %v", ErrBrokersEmpty, err)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package kafkabp
+
+import (
+	"context"
+	"sync"
+)
+
+// pauser gates message handling while the consumer or some of its partitions
+// are paused.
+//
+// The zero value is ready to use, with nothing paused.
+type pauser struct {
+	lock       sync.Mutex
+	all        bool
+	partitions map[int32]bool
+	// closed and replaced on every resume, to wake up the waiters.
+	resumed chan struct{}
+}
+
+func (p *pauser) pause() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.all = true
+}
+
+func (p *pauser) pausePartition(partition int32) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.partitions == nil {
+		p.partitions = make(map[int32]bool)
+	}
+	p.partitions[partition] = true
+}
+
+// resume resumes the whole consumer, including the partitions paused
+// individually.
+func (p *pauser) resume() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.all = false
+	p.partitions = nil
+	p.wakeLocked()
+}
+
+func (p *pauser) resumePartition(partition int32) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.partitions, partition)
+	p.wakeLocked()
+}
+
+func (p *pauser) wakeLocked() {
+	if p.resumed != nil {
+		close(p.resumed)
+		p.resumed = nil
+	}
+}
+
+// isPaused returns true if the consumer or any of its partitions is paused.
+func (p *pauser) isPaused() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.all || len(p.partitions) > 0
+}
+
+// wait blocks until partition is not paused, or ctx is done, in which case
+// ctx.Err() is returned.
+func (p *pauser) wait(ctx context.Context, partition int32) error {
+	for {
+		p.lock.Lock()
+		if !p.all && !p.partitions[partition] {
+			p.lock.Unlock()
+			return nil
+		}
+		if p.resumed == nil {
+			p.resumed = make(chan struct{})
+		}
+		resumed := p.resumed
+		p.lock.Unlock()
+
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
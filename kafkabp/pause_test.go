@@ -0,0 +1,114 @@
+package kafkabp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestPauser(t *testing.T) {
+	var p pauser
+	ctx := context.Background()
+	if p.isPaused() {
+		t.Error("expected the zero value not to be paused")
+	}
+	if err := p.wait(ctx, 0); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	p.pausePartition(1)
+	if !p.isPaused() {
+		t.Error("expected to be paused")
+	}
+	if err := p.wait(ctx, 0); err != nil {
+		t.Errorf("expected partition 0 not to be paused, got %v", err)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := p.wait(timeoutCtx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	p.pause()
+	done := make(chan error)
+	go func() {
+		done <- p.wait(ctx, 0)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("expected wait to block while paused, got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	p.resume()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected wait to return after resume")
+	}
+	if p.isPaused() {
+		t.Error("expected resume to resume the paused partitions too")
+	}
+}
+
+func TestKafkaConsumer_Pause(t *testing.T) {
+	const total = 3
+
+	kc := getTestMockConsumer(t)
+	pc := fakePartitionConsumer{
+		messages: make(chan *sarama.ConsumerMessage, total),
+	}
+	for i := 0; i < total; i++ {
+		pc.messages <- &sarama.ConsumerMessage{
+			Topic:  kc.cfg.Topic,
+			Offset: int64(i),
+		}
+	}
+	close(pc.messages)
+
+	kc.Pause()
+	if !kc.IsPaused() {
+		t.Error("expected consumer to be paused")
+	}
+	consumed := make(chan int64, total)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		kc.consumeMessages(
+			pc,
+			0, // partition
+			0, // generation
+			func(_ context.Context, msg *sarama.ConsumerMessage) error {
+				consumed <- msg.Offset
+				return nil
+			},
+			func(context.Context, error) {},
+		)
+	}()
+
+	select {
+	case offset := <-consumed:
+		t.Fatalf("expected no messages handled while paused, got offset %d", offset)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	kc.Resume()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected consuming to finish after resume")
+	}
+	if len(consumed) != total {
+		t.Errorf("expected %d messages handled, got %d", total, len(consumed))
+	}
+	if kc.IsPaused() {
+		t.Error("expected consumer not to be paused")
+	}
+}
@@ -0,0 +1,128 @@
+package kafkabp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Allowed PayloadCodec values
+const (
+	PayloadCodecNone = "none"
+	PayloadCodecGzip = "gzip"
+	PayloadCodecZstd = "zstd"
+)
+
+var (
+	zstdDecoderOnce sync.Once
+	zstdDecoder     *zstd.Decoder
+	zstdDecoderErr  error
+
+	zstdEncoderOnce sync.Once
+	zstdEncoder     *zstd.Encoder
+	zstdEncoderErr  error
+)
+
+// validatePayloadCodec returns ErrPayloadCodecInvalid if codec is not one of
+// the allowed PayloadCodec values.
+func validatePayloadCodec(codec string) error {
+	switch codec {
+	case "", PayloadCodecNone, PayloadCodecGzip, PayloadCodecZstd:
+		return nil
+	default:
+		return ErrPayloadCodecInvalid
+	}
+}
+
+// EncodePayload compresses value according to codec, the PayloadCodec of the
+// consumers of the topic, for the producers to publish messages they can
+// decode.
+//
+// This is application layer compression applied to the message value, and is
+// unrelated to the transport compression sarama handles transparently. Empty
+// values (including the nil values of tombstones) are returned unchanged.
+func EncodePayload(codec string, value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return value, nil
+	}
+
+	switch codec {
+	case "", PayloadCodecNone:
+		return value, nil
+
+	case PayloadCodecGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(value); err != nil {
+			return nil, fmt.Errorf("kafkabp: failed to encode gzip payload: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("kafkabp: failed to encode gzip payload: %w", err)
+		}
+		return buf.Bytes(), nil
+
+	case PayloadCodecZstd:
+		zstdEncoderOnce.Do(func() {
+			// An encoder without an underlying writer is safe for concurrent
+			// EncodeAll calls, so it's shared by all producers.
+			zstdEncoder, zstdEncoderErr = zstd.NewWriter(nil)
+		})
+		if zstdEncoderErr != nil {
+			return nil, fmt.Errorf("kafkabp: failed to create zstd encoder: %w", zstdEncoderErr)
+		}
+		return zstdEncoder.EncodeAll(value, nil), nil
+
+	default:
+		return nil, ErrPayloadCodecInvalid
+	}
+}
+
+// decodePayload decompresses payload according to codec, the reverse of
+// EncodePayload.
+//
+// Empty payloads (including the nil values of tombstones on compacted topics)
+// are returned unchanged regardless of codec.
+func decodePayload(codec string, payload []byte) ([]byte, error) {
+	if len(payload) == 0 {
+		return payload, nil
+	}
+
+	switch codec {
+	case "", PayloadCodecNone:
+		return payload, nil
+
+	case PayloadCodecGzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf("kafkabp: failed to decode gzip payload: %w", err)
+		}
+		defer r.Close()
+		decoded, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("kafkabp: failed to decode gzip payload: %w", err)
+		}
+		return decoded, nil
+
+	case PayloadCodecZstd:
+		zstdDecoderOnce.Do(func() {
+			// A decoder without an underlying reader is safe for concurrent
+			// DecodeAll calls, so it's shared by all partitions.
+			zstdDecoder, zstdDecoderErr = zstd.NewReader(nil)
+		})
+		if zstdDecoderErr != nil {
+			return nil, fmt.Errorf("kafkabp: failed to create zstd decoder: %w", zstdDecoderErr)
+		}
+		decoded, err := zstdDecoder.DecodeAll(payload, nil)
+		if err != nil {
+			return nil, fmt.Errorf("kafkabp: failed to decode zstd payload: %w", err)
+		}
+		return decoded, nil
+
+	default:
+		return nil, ErrPayloadCodecInvalid
+	}
+}
@@ -0,0 +1,120 @@
+package kafkabp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDecodePayload(t *testing.T) {
+	payload := []byte("hello, world")
+
+	var gzipped bytes.Buffer
+	w := gzip.NewWriter(&gzipped)
+	if _, err := w.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zstded := enc.EncodeAll(payload, nil)
+
+	for _, c := range []struct {
+		label   string
+		codec   string
+		payload []byte
+	}{
+		{
+			label:   "default",
+			codec:   "",
+			payload: payload,
+		},
+		{
+			label:   "none",
+			codec:   PayloadCodecNone,
+			payload: payload,
+		},
+		{
+			label:   "gzip",
+			codec:   PayloadCodecGzip,
+			payload: gzipped.Bytes(),
+		},
+		{
+			label:   "zstd",
+			codec:   PayloadCodecZstd,
+			payload: zstded,
+		},
+	} {
+		t.Run(c.label, func(t *testing.T) {
+			decoded, err := decodePayload(c.codec, c.payload)
+			if err != nil {
+				t.Fatalf("decodePayload returned error: %v", err)
+			}
+			if !bytes.Equal(decoded, payload) {
+				t.Errorf("expected decoded payload %q, got %q", payload, decoded)
+			}
+		})
+	}
+
+	t.Run("malformed", func(t *testing.T) {
+		if _, err := decodePayload(PayloadCodecGzip, payload); err == nil {
+			t.Error("expected error decoding malformed gzip payload, got nil")
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := decodePayload("fanciest", payload)
+		if !errors.Is(err, ErrPayloadCodecInvalid) {
+			t.Errorf("expected error %v, got %v", ErrPayloadCodecInvalid, err)
+		}
+	})
+}
+
+func TestDecodePayload_Tombstone(t *testing.T) {
+	for _, codec := range []string{"", PayloadCodecNone, PayloadCodecGzip, PayloadCodecZstd} {
+		for _, payload := range [][]byte{nil, {}} {
+			decoded, err := decodePayload(codec, payload)
+			if err != nil {
+				t.Errorf("codec %q: unexpected error decoding %#v: %v", codec, payload, err)
+			}
+			if len(decoded) != 0 || (payload == nil) != (decoded == nil) {
+				t.Errorf("codec %q: expected %#v returned unchanged, got %#v", codec, payload, decoded)
+			}
+		}
+	}
+}
+
+func TestEncodePayload(t *testing.T) {
+	payload := []byte("hello, world")
+	for _, codec := range []string{"", PayloadCodecNone, PayloadCodecGzip, PayloadCodecZstd} {
+		t.Run(codec, func(t *testing.T) {
+			encoded, err := EncodePayload(codec, payload)
+			if err != nil {
+				t.Fatalf("EncodePayload returned error: %v", err)
+			}
+			decoded, err := decodePayload(codec, encoded)
+			if err != nil {
+				t.Fatalf("decodePayload returned error: %v", err)
+			}
+			if !bytes.Equal(decoded, payload) {
+				t.Errorf("expected round trip payload %q, got %q", payload, decoded)
+			}
+
+			if encoded, err := EncodePayload(codec, nil); err != nil || encoded != nil {
+				t.Errorf("expected tombstone to stay nil, got %#v, %v", encoded, err)
+			}
+		})
+	}
+
+	if _, err := EncodePayload("fanciest", payload); !errors.Is(err, ErrPayloadCodecInvalid) {
+		t.Errorf("expected error %v, got %v", ErrPayloadCodecInvalid, err)
+	}
+}
@@ -0,0 +1,139 @@
+package kafkabp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+)
+
+// Decoder decodes a consumed message into a value to be processed by a
+// Pipeline.
+type Decoder func(msg *sarama.ConsumerMessage) (interface{}, error)
+
+// Encoder encodes the value processed by a Pipeline into bytes.
+type Encoder func(v interface{}) ([]byte, error)
+
+// MapFunc transforms a value in a Pipeline.
+type MapFunc func(ctx context.Context, v interface{}) (interface{}, error)
+
+// FilterFunc decides whether a value should continue through a Pipeline.
+//
+// Values it returns false for are dropped silently.
+type FilterFunc func(ctx context.Context, v interface{}) bool
+
+// SinkFunc receives the encoded output of a Pipeline, along with the original
+// message it's derived from.
+//
+// It's usually where the output gets produced to another topic.
+type SinkFunc func(ctx context.Context, msg *sarama.ConsumerMessage, encoded []byte) error
+
+// Pipeline is a reusable decode -> transform -> encode chain that can be
+// built into a ConsumeMessageFunc.
+//
+// The zero value is a pipeline passing the message value through as-is.
+// Pipeline is immutable: every method returns a new Pipeline, so a common
+// prefix can be shared by multiple pipelines safely.
+//
+// Example:
+//
+//     consumer.Consume(
+//         kafkabp.Pipeline{}.
+//             Decode(decodeEvent).
+//             Filter(isInteresting).
+//             Map(enrich).
+//             Encode(encodeEvent).
+//             Build(publishEnriched),
+//         errorsFunc,
+//     )
+type Pipeline struct {
+	decoder Decoder
+	stages  []func(ctx context.Context, v interface{}) (out interface{}, keep bool, err error)
+	encoder Encoder
+}
+
+// Decode returns a new Pipeline using d to decode messages.
+//
+// When not set, the decoded value is msg.Value as []byte.
+func (p Pipeline) Decode(d Decoder) Pipeline {
+	p.decoder = d
+	return p
+}
+
+// Map returns a new Pipeline with fn appended as a transform stage.
+func (p Pipeline) Map(fn MapFunc) Pipeline {
+	return p.withStage(func(ctx context.Context, v interface{}) (interface{}, bool, error) {
+		out, err := fn(ctx, v)
+		return out, true, err
+	})
+}
+
+// Filter returns a new Pipeline with fn appended as a filter stage.
+func (p Pipeline) Filter(fn FilterFunc) Pipeline {
+	return p.withStage(func(ctx context.Context, v interface{}) (interface{}, bool, error) {
+		return v, fn(ctx, v), nil
+	})
+}
+
+// Encode returns a new Pipeline using e to encode the processed values.
+//
+// When not set, the processed value must be []byte.
+func (p Pipeline) Encode(e Encoder) Pipeline {
+	p.encoder = e
+	return p
+}
+
+func (p Pipeline) withStage(
+	stage func(ctx context.Context, v interface{}) (interface{}, bool, error),
+) Pipeline {
+	stages := make([]func(context.Context, interface{}) (interface{}, bool, error), len(p.stages), len(p.stages)+1)
+	copy(stages, p.stages)
+	p.stages = append(stages, stage)
+	return p
+}
+
+// Build builds the Pipeline into a ConsumeMessageFunc that sends the encoded
+// output of every message not filtered out to sink.
+//
+// Any error from the decoder, stages, encoder, or sink is returned by the
+// ConsumeMessageFunc.
+func (p Pipeline) Build(sink SinkFunc) ConsumeMessageFunc {
+	return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		var v interface{} = msg.Value
+		if p.decoder != nil {
+			var err error
+			v, err = p.decoder(msg)
+			if err != nil {
+				return fmt.Errorf("kafkabp: pipeline decode: %w", err)
+			}
+		}
+
+		for _, stage := range p.stages {
+			var keep bool
+			var err error
+			v, keep, err = stage(ctx, v)
+			if err != nil {
+				return err
+			}
+			if !keep {
+				return nil
+			}
+		}
+
+		var encoded []byte
+		if p.encoder != nil {
+			var err error
+			encoded, err = p.encoder(v)
+			if err != nil {
+				return fmt.Errorf("kafkabp: pipeline encode: %w", err)
+			}
+		} else {
+			var ok bool
+			encoded, ok = v.([]byte)
+			if !ok {
+				return fmt.Errorf("kafkabp: pipeline without Encoder produced %T, expected []byte", v)
+			}
+		}
+		return sink(ctx, msg, encoded)
+	}
+}
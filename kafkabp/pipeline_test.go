@@ -0,0 +1,68 @@
+package kafkabp
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestPipeline(t *testing.T) {
+	var sunk []string
+	sink := func(_ context.Context, _ *sarama.ConsumerMessage, encoded []byte) error {
+		sunk = append(sunk, string(encoded))
+		return nil
+	}
+
+	base := Pipeline{}.
+		Decode(func(msg *sarama.ConsumerMessage) (interface{}, error) {
+			return strconv.Atoi(string(msg.Value))
+		}).
+		Filter(func(_ context.Context, v interface{}) bool {
+			return v.(int)%2 == 0
+		})
+	doubled := base.
+		Map(func(_ context.Context, v interface{}) (interface{}, error) {
+			return v.(int) * 2, nil
+		}).
+		Encode(func(v interface{}) ([]byte, error) {
+			return []byte(strconv.Itoa(v.(int))), nil
+		}).
+		Build(sink)
+
+	for _, value := range []string{"1", "2", "3", "4"} {
+		if err := doubled(context.Background(), getTestKafkaMessage("key", value)); err != nil {
+			t.Errorf("unexpected error for %q: %v", value, err)
+		}
+	}
+	if len(sunk) != 2 || sunk[0] != "4" || sunk[1] != "8" {
+		t.Errorf("expected [4 8], got %q", sunk)
+	}
+
+	t.Run("decode-error", func(t *testing.T) {
+		err := doubled(context.Background(), getTestKafkaMessage("key", "NaN"))
+		if err == nil {
+			t.Error("expected decode error, got nil")
+		}
+	})
+
+	t.Run("missing-encoder", func(t *testing.T) {
+		// base doesn't have an Encoder, and produces int values.
+		err := base.Build(sink)(context.Background(), getTestKafkaMessage("key", "2"))
+		if err == nil {
+			t.Error("expected error for non-[]byte output without Encoder, got nil")
+		}
+	})
+
+	t.Run("map-error", func(t *testing.T) {
+		mapErr := errors.New("map error")
+		fn := Pipeline{}.Map(func(context.Context, interface{}) (interface{}, error) {
+			return nil, mapErr
+		}).Build(sink)
+		if err := fn(context.Background(), getTestKafkaMessage("key", "value")); !errors.Is(err, mapErr) {
+			t.Errorf("expected error %v, got %v", mapErr, err)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package kafkabp
+
+import (
+	"container/heap"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+)
+
+// DefaultPriorityBufferSize is the default ConsumerConfig.PriorityBufferSize.
+const DefaultPriorityBufferSize = 100
+
+// messagePriority returns the priority of m read from its header, or 0 if m
+// doesn't have the header or it's not an integer.
+func messagePriority(m *sarama.ConsumerMessage, header string) int64 {
+	for _, h := range m.Headers {
+		if h != nil && string(h.Key) == header {
+			priority, err := strconv.ParseInt(string(h.Value), 10, 64)
+			if err != nil {
+				return 0
+			}
+			return priority
+		}
+	}
+	return 0
+}
+
+// prioritizeMessages buffers up to size messages from in, and delivers them
+// to the returned channel highest priority first, in the order they are
+// received among the same priority.
+//
+// received is called with every message in the order they are received from
+// in, before they are buffered.
+//
+// The returned channel is closed after in is closed and all the buffered
+// messages are delivered.
+func prioritizeMessages(
+	in <-chan *sarama.ConsumerMessage,
+	header string,
+	size int,
+	received func(m *sarama.ConsumerMessage),
+) <-chan *sarama.ConsumerMessage {
+	out := make(chan *sarama.ConsumerMessage)
+	go func() {
+		defer close(out)
+
+		var q priorityQueue
+		var seq uint64
+		for in != nil || q.Len() > 0 {
+			var recv <-chan *sarama.ConsumerMessage
+			if q.Len() < size {
+				recv = in
+			}
+			var send chan<- *sarama.ConsumerMessage
+			var next *sarama.ConsumerMessage
+			if q.Len() > 0 {
+				send = out
+				next = q[0].msg
+			}
+
+			select {
+			case m, ok := <-recv:
+				if !ok {
+					in = nil
+					continue
+				}
+				received(m)
+				heap.Push(&q, prioritizedMessage{
+					msg:      m,
+					priority: messagePriority(m, header),
+					seq:      seq,
+				})
+				seq++
+			case send <- next:
+				heap.Pop(&q)
+			}
+		}
+	}()
+	return out
+}
+
+type prioritizedMessage struct {
+	msg      *sarama.ConsumerMessage
+	priority int64
+	seq      uint64
+}
+
+// priorityQueue implements heap.Interface.
+type priorityQueue []prioritizedMessage
+
+func (q priorityQueue) Len() int {
+	return len(q)
+}
+
+func (q priorityQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q priorityQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *priorityQueue) Push(x interface{}) {
+	*q = append(*q, x.(prioritizedMessage))
+}
+
+func (q *priorityQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = prioritizedMessage{}
+	*q = old[:n-1]
+	return x
+}
@@ -0,0 +1,132 @@
+package kafkabp
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func getTestPriorityMessage(offset int64, priority string) *sarama.ConsumerMessage {
+	m := &sarama.ConsumerMessage{
+		Topic:  "kafkabp-test",
+		Offset: offset,
+	}
+	if priority != "" {
+		m.Headers = []*sarama.RecordHeader{
+			{Key: []byte("priority"), Value: []byte(priority)},
+		}
+	}
+	return m
+}
+
+func TestPrioritizeMessages(t *testing.T) {
+	priorities := []string{"", "1", "invalid", "5", "1", "-1"}
+	// highest priority first, then offset order
+	expected := []int64{3, 1, 4, 0, 2, 5}
+
+	in := make(chan *sarama.ConsumerMessage, len(priorities))
+	for i, p := range priorities {
+		in <- getTestPriorityMessage(int64(i), p)
+	}
+	close(in)
+
+	received := make(chan int64, len(priorities))
+	out := prioritizeMessages(in, "priority", len(priorities), func(m *sarama.ConsumerMessage) {
+		received <- m.Offset
+	})
+
+	// Wait for all the messages to be buffered before reading any of them.
+	for i := range priorities {
+		if offset := <-received; offset != int64(i) {
+			t.Errorf("expected received offset %d, got %d", i, offset)
+		}
+	}
+
+	var got []int64
+	for m := range out {
+		got = append(got, m.Offset)
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected offsets %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected offsets %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestPrioritizeMessagesBufferSize(t *testing.T) {
+	const total = 10
+
+	in := make(chan *sarama.ConsumerMessage, total)
+	for i := 0; i < total; i++ {
+		in <- getTestPriorityMessage(int64(i), strconv.Itoa(i))
+	}
+	close(in)
+
+	received := make(chan int64, total)
+	out := prioritizeMessages(in, "priority", 2, func(m *sarama.ConsumerMessage) {
+		received <- m.Offset
+	})
+	<-received
+	<-received
+
+	// The buffer is full, so no more messages are received until one of them
+	// is delivered.
+	select {
+	case offset := <-received:
+		t.Errorf("expected the buffer to be full, received offset %d", offset)
+	default:
+	}
+
+	var count int
+	for range out {
+		count++
+	}
+	if count != total {
+		t.Errorf("expected %d messages, got %d", total, count)
+	}
+}
+
+func TestKafkaConsumer_PriorityHeader(t *testing.T) {
+	const (
+		total     = 20
+		partition = 1
+	)
+
+	kc := getTestMockConsumer(t)
+	kc.cfg.PriorityHeader = "priority"
+	kc.cfg.StrictOffsetAssert = true
+
+	pc := fakePartitionConsumer{
+		messages: make(chan *sarama.ConsumerMessage, total),
+	}
+	for i := 0; i < total; i++ {
+		m := getTestPriorityMessage(int64(i), strconv.Itoa(i%3))
+		m.Partition = partition
+		pc.messages <- m
+	}
+	close(pc.messages)
+
+	var consumed int
+	kc.consumeMessages(
+		pc,
+		partition,
+		0, // generation
+		func(context.Context, *sarama.ConsumerMessage) error {
+			consumed++
+			return nil
+		},
+		func(context.Context, error) {},
+	)
+
+	if consumed != total {
+		t.Errorf("expected %d messages consumed, got %d", total, consumed)
+	}
+	if offset := kc.resumeOffset(partition); offset != total {
+		t.Errorf("expected committed offset %d, got %d", total, offset)
+	}
+}
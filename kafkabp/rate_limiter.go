@@ -0,0 +1,137 @@
+package kafkabp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a simple token bucket rate limiter.
+//
+// The zero value is an unlimited rateLimiter.
+type rateLimiter struct {
+	lock   sync.Mutex
+	rate   float64 // tokens per second, non-positive means unlimited
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// Closed by setLimit to wake up the waiters, so they wait according to the
+	// new limit.
+	changed chan struct{}
+}
+
+// newRateLimiter creates a rateLimiter allowing rate events per second, with
+// bursts of at most burst events.
+//
+// Non-positive burst is treated as 1.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until an event is allowed or ctx is done.
+//
+// It returns true if it had to wait, and ctx.Err() if ctx is done before the
+// event is allowed.
+func (l *rateLimiter) wait(ctx context.Context) (waited bool, err error) {
+	for {
+		delay, changed := l.reserve()
+		if delay <= 0 {
+			return waited, nil
+		}
+		waited = true
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+			return true, nil
+		case <-changed:
+			timer.Stop()
+			l.cancel()
+		case <-ctx.Done():
+			timer.Stop()
+			l.cancel()
+			return true, ctx.Err()
+		}
+	}
+}
+
+// setLimit changes the limit to rate events per second, with bursts of at most
+// burst events.
+//
+// Non-positive rate means unlimited, and non-positive burst is treated as 1.
+// The callers currently waiting are woken up to wait according to the new
+// limit.
+func (l *rateLimiter) setLimit(rate float64, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	if l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+	} else {
+		l.tokens = float64(burst)
+	}
+	if l.tokens > float64(burst) {
+		l.tokens = float64(burst)
+	}
+	l.last = now
+	l.rate = rate
+	l.burst = float64(burst)
+
+	if l.changed != nil {
+		close(l.changed)
+		l.changed = nil
+	}
+}
+
+// reserve takes a token, and returns how long the caller needs to wait before
+// the token is actually available, and a channel closed when the limit is
+// changed before that.
+func (l *rateLimiter) reserve() (time.Duration, <-chan struct{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.rate <= 0 {
+		return 0, nil
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0, nil
+	}
+	if l.changed == nil {
+		l.changed = make(chan struct{})
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second)), l.changed
+}
+
+// cancel returns a token taken by reserve.
+func (l *rateLimiter) cancel() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.rate <= 0 {
+		return
+	}
+	l.tokens++
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
@@ -0,0 +1,76 @@
+package kafkabp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("unlimited", func(t *testing.T) {
+		l := newRateLimiter(0, 0)
+		for i := 0; i < 100; i++ {
+			if waited, err := l.wait(context.Background()); waited || err != nil {
+				t.Fatalf("expected no wait, got waited=%v err=%v", waited, err)
+			}
+		}
+	})
+
+	t.Run("burst", func(t *testing.T) {
+		l := newRateLimiter(100, 2)
+		start := time.Now()
+		for i := 0; i < 2; i++ {
+			if waited, _ := l.wait(context.Background()); waited {
+				t.Errorf("expected event %d within burst to not wait", i)
+			}
+		}
+		if waited, _ := l.wait(context.Background()); !waited {
+			t.Error("expected event after burst to wait")
+		}
+		if elapsed := time.Since(start); elapsed < time.Millisecond*5 {
+			t.Errorf("expected to wait about 10ms, waited %v", elapsed)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		l := newRateLimiter(0.001, 1)
+		l.wait(context.Background())
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		if _, err := l.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected error %v, got %v", context.DeadlineExceeded, err)
+		}
+	})
+
+	t.Run("zero", func(t *testing.T) {
+		var l rateLimiter
+		if waited, err := l.wait(context.Background()); waited || err != nil {
+			t.Errorf("expected zero value to be unlimited, got waited=%v err=%v", waited, err)
+		}
+	})
+
+	t.Run("setLimit", func(t *testing.T) {
+		var l rateLimiter
+		l.setLimit(0.001, 1)
+		l.wait(context.Background())
+
+		done := make(chan bool)
+		go func() {
+			waited, _ := l.wait(context.Background())
+			done <- waited
+		}()
+		time.Sleep(time.Millisecond * 10)
+
+		l.setLimit(0, 0)
+		select {
+		case waited := <-done:
+			if !waited {
+				t.Error("expected event to wait before the limit is lifted")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected waiter to be released after the limit is lifted")
+		}
+	})
+}
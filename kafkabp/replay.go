@@ -0,0 +1,164 @@
+package kafkabp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// initReplayTargets resolves the offsets each partition is consumed up to when
+// cfg.ReplayUntilCaughtUp is set, see resolveReplayTargets.
+func (kc *consumer) initReplayTargets() error {
+	if !kc.cfg.ReplayUntilCaughtUp {
+		return nil
+	}
+
+	client, release, err := kc.newClient("initReplayTargets")
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	kc.partitionsLock.Lock()
+	defer kc.partitionsLock.Unlock()
+	targets, err := resolveReplayTargets(client, kc.cfg.Topic, kc.resumeOffsetLocked)
+	if err != nil {
+		return err
+	}
+	kc.replayTargets = targets
+	return nil
+}
+
+// resolveReplayTargets returns the high water mark of every partition of topic
+// that has messages to consume before it, starting from the offset returned
+// by start.
+//
+// The partitions without such messages are already caught up, so they are not
+// in the returned map, which is empty but non-nil when all partitions are.
+func resolveReplayTargets(client offsetClient, topic string, start func(partition int32) int64) (map[int32]int64, error) {
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make(map[int32]int64, len(partitions))
+	for _, p := range partitions {
+		newest, err := client.GetOffset(topic, p, sarama.OffsetNewest)
+		if err != nil {
+			return nil, err
+		}
+		offset := start(p)
+		switch offset {
+		case sarama.OffsetNewest:
+			continue
+		case sarama.OffsetOldest:
+			offset, err = client.GetOffset(topic, p, sarama.OffsetOldest)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if offset < newest {
+			targets[p] = newest
+		}
+	}
+	return targets, nil
+}
+
+// replayCaughtUp returns true if cfg.ReplayUntilCaughtUp is set and all the
+// partitions are caught up.
+func (kc *consumer) replayCaughtUp() bool {
+	kc.partitionsLock.Lock()
+	defer kc.partitionsLock.Unlock()
+	return kc.replayTargets != nil && len(kc.replayTargets) == 0
+}
+
+// replaying returns true if partition is not caught up yet with
+// cfg.ReplayUntilCaughtUp set.
+func (kc *consumer) replaying(partition int32) bool {
+	kc.partitionsLock.Lock()
+	defer kc.partitionsLock.Unlock()
+	_, ok := kc.replayTargets[partition]
+	return ok
+}
+
+// replayIdleTimeout returns cfg.ReplayIdleTimeout, or its default.
+func (kc *consumer) replayIdleTimeout() time.Duration {
+	if kc.cfg.ReplayIdleTimeout > 0 {
+		return kc.cfg.ReplayIdleTimeout
+	}
+	return DefaultReplayIdleTimeout
+}
+
+// checkReplayIdle marks partition as caught up if the high water mark of pc
+// reached its target, and no message was delivered since lastDelivered for
+// cfg.ReplayIdleTimeout while none is being handled (tracked by seq). That
+// happens when the last offsets before the target are never delivered, for
+// example transaction markers.
+//
+// It returns how long to wait before checking again, or 0 when partition is
+// no longer replaying.
+func (kc *consumer) checkReplayIdle(
+	pc sarama.PartitionConsumer,
+	partition int32,
+	seq *offsetSequencer,
+	lastDelivered time.Time,
+) time.Duration {
+	timeout := kc.replayIdleTimeout()
+	if !seq.idle() {
+		return timeout
+	}
+	if wait := timeout - time.Since(lastDelivered); wait > 0 {
+		return wait
+	}
+
+	kc.partitionsLock.Lock()
+	defer kc.partitionsLock.Unlock()
+	target, ok := kc.replayTargets[partition]
+	if !ok {
+		return 0
+	}
+	if pc.HighWaterMarkOffset() < target {
+		return timeout
+	}
+	kc.cfg.Logger.Log(context.Background(), fmt.Sprintf(
+		"kafkabp.consumer: topic %q partition %d caught up at offset %d, the offsets up to %d were not delivered",
+		kc.cfg.Topic,
+		partition,
+		kc.resumeOffsetLocked(partition),
+		target,
+	))
+	kc.finishReplayLocked(partition)
+	return 0
+}
+
+// trackReplayLocked records that partition is consumed up to offset, and
+// closes the consumer in the background once all the partitions are caught up.
+//
+// It must be called with kc.partitionsLock held.
+func (kc *consumer) trackReplayLocked(partition int32, offset int64) {
+	target, ok := kc.replayTargets[partition]
+	if !ok || offset < target {
+		return
+	}
+	kc.finishReplayLocked(partition)
+}
+
+// finishReplayLocked records that partition is caught up, and closes the
+// consumer in the background once all the partitions are.
+//
+// It must be called with kc.partitionsLock held.
+func (kc *consumer) finishReplayLocked(partition int32) {
+	delete(kc.replayTargets, partition)
+	if len(kc.replayTargets) > 0 {
+		return
+	}
+
+	// Called from the goroutine handling the message, which Close waits for.
+	go func() {
+		if err := kc.Close(); err != nil {
+			kc.cfg.Logger.Log(context.Background(), "kafkabp.consumer: Error closing the consumer after replay caught up:"+err.Error())
+		}
+	}()
+}
@@ -0,0 +1,181 @@
+package kafkabp
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestResolveReplayTargets(t *testing.T) {
+	t.Run("targets", func(t *testing.T) {
+		client := fakeOffsetClient{
+			partitions: []int32{0, 1, 2, 3},
+			oldest:     10,
+			newest:     100,
+		}
+		start := map[int32]int64{
+			0: sarama.OffsetOldest,
+			1: sarama.OffsetNewest,
+			2: 100,
+			3: 50,
+		}
+		targets, err := resolveReplayTargets(client, "topic", func(p int32) int64 {
+			return start[p]
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[int32]int64{
+			0: 100,
+			3: 100,
+		}
+		if !reflect.DeepEqual(targets, expected) {
+			t.Errorf("expected targets %v, got %v", expected, targets)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		client := fakeOffsetClient{
+			partitions: []int32{0},
+			oldest:     10,
+			newest:     10,
+		}
+		targets, err := resolveReplayTargets(client, "topic", func(int32) int64 {
+			return sarama.OffsetOldest
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if targets == nil || len(targets) != 0 {
+			t.Errorf("expected empty non-nil targets, got %#v", targets)
+		}
+	})
+}
+
+func TestKafkaConsumer_ReplayUntilCaughtUp(t *testing.T) {
+	t.Run("caught-up", func(t *testing.T) {
+		partitions := []int32{0, 1}
+		fake := newFakeConsumer(partitions)
+
+		kc := getTestConsumer(t)
+		kc.consumer.Store(fake)
+		kc.partitions.Store(partitions)
+		targets := map[int32]int64{
+			0: 2,
+			1: 1,
+		}
+		kc.replayTargets = make(map[int32]int64, len(targets))
+		for p, target := range targets {
+			kc.replayTargets[p] = target
+		}
+
+		consumeReturned := make(chan error)
+		go func() {
+			consumeReturned <- kc.Consume(
+				func(context.Context, *sarama.ConsumerMessage) error {
+					return nil
+				},
+				func(error) {},
+			)
+		}()
+
+		pcs := receivePartitionConsumers(t, fake, len(partitions))
+		for _, pc := range pcs {
+			for offset := int64(0); offset < targets[pc.partition]; offset++ {
+				if pc.partition == 0 && offset == 1 {
+					// Partition 0 is not caught up yet.
+					select {
+					case err := <-consumeReturned:
+						t.Fatalf("expected Consume to not return before all partitions caught up, got %v", err)
+					case <-time.After(10 * time.Millisecond):
+					}
+				}
+				pc.yield(offset)
+			}
+		}
+
+		select {
+		case err := <-consumeReturned:
+			if err != nil {
+				t.Errorf("expected Consume to return nil, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected Consume to return after all partitions caught up")
+		}
+		if atomic.LoadInt64(&kc.closed) == 0 {
+			t.Error("expected the consumer to be closed")
+		}
+	})
+
+	t.Run("idle", func(t *testing.T) {
+		partitions := []int32{0}
+		fake := newFakeConsumer(partitions)
+
+		kc := getTestConsumer(t)
+		kc.cfg.ReplayIdleTimeout = 10 * time.Millisecond
+		kc.consumer.Store(fake)
+		kc.partitions.Store(partitions)
+		// Offset 2 is a transaction marker, which is never delivered.
+		kc.replayTargets = map[int32]int64{0: 3}
+
+		consumeReturned := make(chan error)
+		go func() {
+			consumeReturned <- kc.Consume(
+				func(context.Context, *sarama.ConsumerMessage) error {
+					return nil
+				},
+				func(error) {},
+			)
+		}()
+
+		pc := receivePartitionConsumers(t, fake, len(partitions))[0]
+		pc.yield(0)
+		pc.yield(1)
+		select {
+		case err := <-consumeReturned:
+			t.Fatalf("expected Consume to not return before the high water mark reached the target, got %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		atomic.StoreInt64(&pc.highWaterMark, 3)
+		select {
+		case err := <-consumeReturned:
+			if err != nil {
+				t.Errorf("expected Consume to return nil, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected Consume to return after the partition was idle at the high water mark")
+		}
+		if offset := kc.resumeOffset(0); offset != 2 {
+			t.Errorf("expected committed offset 2, got %d", offset)
+		}
+	})
+
+	t.Run("nothing-to-replay", func(t *testing.T) {
+		partitions := []int32{0}
+		fake := newFakeConsumer(partitions)
+
+		kc := getTestConsumer(t)
+		kc.consumer.Store(fake)
+		kc.partitions.Store(partitions)
+		kc.replayTargets = map[int32]int64{}
+
+		err := kc.Consume(
+			func(context.Context, *sarama.ConsumerMessage) error {
+				t.Error("expected no message to be handled")
+				return nil
+			},
+			func(error) {},
+		)
+		if err != nil {
+			t.Errorf("expected Consume to return nil, got %v", err)
+		}
+		if !fake.isClosed() {
+			t.Error("expected the consumer to be closed")
+		}
+	})
+}
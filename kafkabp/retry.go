@@ -0,0 +1,100 @@
+package kafkabp
+
+import (
+	"context"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/reddit/baseplate.go/randbp"
+)
+
+// RetryPolicy defines how WithRetry retries a ConsumeMessageFunc.
+//
+// All fields are optional.
+type RetryPolicy struct {
+	// The maximum number of attempts, including the first one.
+	// If <=1, the message is never retried.
+	MaxAttempts int
+
+	// The delay before the first retry, doubled for every following retry.
+	// If <=0, 1ms will be used.
+	InitialDelay time.Duration
+
+	// The cap of the exponential delay. If <=0, the delay is not capped.
+	//
+	// Please note that it doesn't cap the MaxJitter part, so the actual max
+	// delay could be MaxDelay+MaxJitter.
+	MaxDelay time.Duration
+
+	// Max random jitter to be added to each retry delay.
+	// If <=0, no random jitter will be added.
+	MaxJitter time.Duration
+
+	// Retryable decides whether an error returned by the ConsumeMessageFunc is
+	// transient and should be retried. Errors it returns false for are
+	// returned right away, so poison messages are not retried.
+	//
+	// If nil, all errors are retried.
+	Retryable func(err error) bool
+}
+
+// delay returns the delay before the retry following the nth attempt (0-based).
+func (p RetryPolicy) delay(n int) time.Duration {
+	delay := p.InitialDelay
+	if delay <= 0 {
+		delay = time.Millisecond
+	}
+	for i := 0; i < n; i++ {
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			break
+		}
+		if delay > delay<<1 {
+			// overflow
+			break
+		}
+		delay <<= 1
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.MaxJitter > 0 {
+		delay += time.Duration(randbp.R.Int63n(int64(p.MaxJitter)))
+	}
+	return delay
+}
+
+// WithRetry wraps fn so that the errors it returns are retried according to
+// policy, with exponential backoff.
+//
+// Retries stop early when the context is done, or when the context deadline
+// would be exceeded before the next retry. In all cases the error of the last
+// attempt is returned.
+func WithRetry(fn ConsumeMessageFunc, policy RetryPolicy) ConsumeMessageFunc {
+	return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		for n := 0; ; n++ {
+			err := fn(ctx, msg)
+			if err == nil {
+				return nil
+			}
+			if n+1 >= policy.MaxAttempts {
+				return err
+			}
+			if policy.Retryable != nil && !policy.Retryable(err) {
+				return err
+			}
+
+			delay := policy.delay(n)
+			if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+				return err
+			}
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package kafkabp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestWithRetry(t *testing.T) {
+	transient := errors.New("transient")
+	fatal := errors.New("fatal")
+
+	newFunc := func(errs ...error) (ConsumeMessageFunc, *int) {
+		var calls int
+		return func(context.Context, *sarama.ConsumerMessage) error {
+			calls++
+			if calls <= len(errs) {
+				return errs[calls-1]
+			}
+			return nil
+		}, &calls
+	}
+	policy := RetryPolicy{
+		MaxAttempts:  3,
+		InitialDelay: time.Millisecond,
+		Retryable: func(err error) bool {
+			return !errors.Is(err, fatal)
+		},
+	}
+	msg := getTestKafkaMessage("key", "value")
+
+	t.Run("success-after-retries", func(t *testing.T) {
+		fn, calls := newFunc(transient, transient)
+		if err := WithRetry(fn, policy)(context.Background(), msg); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+		if *calls != 3 {
+			t.Errorf("expected 3 calls, got %d", *calls)
+		}
+	})
+
+	t.Run("attempts-exhausted", func(t *testing.T) {
+		fn, calls := newFunc(transient, transient, transient, transient)
+		if err := WithRetry(fn, policy)(context.Background(), msg); !errors.Is(err, transient) {
+			t.Errorf("expected error %v, got %v", transient, err)
+		}
+		if *calls != 3 {
+			t.Errorf("expected 3 calls, got %d", *calls)
+		}
+	})
+
+	t.Run("not-retryable", func(t *testing.T) {
+		fn, calls := newFunc(fatal)
+		if err := WithRetry(fn, policy)(context.Background(), msg); !errors.Is(err, fatal) {
+			t.Errorf("expected error %v, got %v", fatal, err)
+		}
+		if *calls != 1 {
+			t.Errorf("expected 1 call, got %d", *calls)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		fn, calls := newFunc(transient, transient)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		p := policy
+		p.InitialDelay = time.Hour
+		if err := WithRetry(fn, p)(ctx, msg); !errors.Is(err, transient) {
+			t.Errorf("expected error %v, got %v", transient, err)
+		}
+		if *calls != 1 {
+			t.Errorf("expected 1 call, got %d", *calls)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		fn, calls := newFunc(transient, transient)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		p := policy
+		p.InitialDelay = time.Hour
+		start := time.Now()
+		if err := WithRetry(fn, p)(ctx, msg); !errors.Is(err, transient) {
+			t.Errorf("expected error %v, got %v", transient, err)
+		}
+		if *calls != 1 {
+			t.Errorf("expected 1 call, got %d", *calls)
+		}
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Errorf("expected to return right away, took %v", elapsed)
+		}
+	})
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{
+		InitialDelay: time.Millisecond,
+		MaxDelay:     5 * time.Millisecond,
+	}
+	for n, expected := range []time.Duration{
+		time.Millisecond,
+		2 * time.Millisecond,
+		4 * time.Millisecond,
+		5 * time.Millisecond,
+		5 * time.Millisecond,
+	} {
+		if delay := p.delay(n); delay != expected {
+			t.Errorf("delay(%d): expected %v, got %v", n, expected, delay)
+		}
+	}
+
+	p = RetryPolicy{InitialDelay: time.Second}
+	if delay := p.delay(100); delay <= 0 {
+		t.Errorf("expected positive delay without overflow, got %v", delay)
+	}
+}
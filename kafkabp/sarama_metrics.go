@@ -0,0 +1,70 @@
+package kafkabp
+
+import (
+	"context"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+
+	"github.com/reddit/baseplate.go/metricsbp"
+)
+
+// DefaultSaramaMetricsInterval is the default interval used by
+// RunSaramaMetricsReporter.
+const DefaultSaramaMetricsInterval = time.Second * 10
+
+const saramaMetricsPrefix = "kafka.sarama."
+
+// RunSaramaMetricsReporter starts a goroutine to periodically report all the
+// metrics in registry (usually ConsumerConfig.MetricRegistry) as gauges via
+// metricsbp.M.
+//
+// Sarama records its low level broker interaction metrics (request rate,
+// response size, batch size, etc.) into a go-metrics registry. This bridges
+// them into metricsbp so they become visible with the rest of the service
+// metrics, prefixed by "kafka.sarama.".
+//
+// Canceling the context passed in will stop the goroutine.
+// If interval is non-positive, DefaultSaramaMetricsInterval will be used.
+func RunSaramaMetricsReporter(ctx context.Context, registry metrics.Registry, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultSaramaMetricsInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reportSaramaMetrics(metricsbp.M, registry)
+			}
+		}
+	}()
+}
+
+func reportSaramaMetrics(st *metricsbp.Statsd, registry metrics.Registry) {
+	registry.Each(func(name string, i interface{}) {
+		name = saramaMetricsPrefix + name
+		switch m := i.(type) {
+		case metrics.Counter:
+			st.Gauge(name + ".count").Set(float64(m.Count()))
+		case metrics.Gauge:
+			st.Gauge(name).Set(float64(m.Value()))
+		case metrics.GaugeFloat64:
+			st.Gauge(name).Set(m.Value())
+		case metrics.Meter:
+			s := m.Snapshot()
+			st.Gauge(name + ".count").Set(float64(s.Count()))
+			st.Gauge(name + ".rate1").Set(s.Rate1())
+		case metrics.Histogram:
+			s := m.Snapshot()
+			st.Gauge(name + ".count").Set(float64(s.Count()))
+			st.Gauge(name + ".mean").Set(s.Mean())
+			st.Gauge(name + ".p99").Set(s.Percentile(0.99))
+		}
+	})
+}
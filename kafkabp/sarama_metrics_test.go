@@ -0,0 +1,34 @@
+package kafkabp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metrics "github.com/rcrowley/go-metrics"
+
+	"github.com/reddit/baseplate.go/metricsbp"
+)
+
+func TestReportSaramaMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("request-count", registry).Inc(3)
+	metrics.GetOrRegisterGauge("in-flight", registry).Update(5)
+
+	st := metricsbp.NewStatsd(context.Background(), metricsbp.StatsdConfig{})
+	reportSaramaMetrics(st, registry)
+
+	var sb strings.Builder
+	if _, err := st.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	stats := sb.String()
+	for _, expected := range []string{
+		"kafka.sarama.request-count.count:3.000000|g",
+		"kafka.sarama.in-flight:5.000000|g",
+	} {
+		if !strings.Contains(stats, expected) {
+			t.Errorf("expected %q in reported metrics, got %q", expected, stats)
+		}
+	}
+}
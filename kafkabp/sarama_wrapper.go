@@ -2,6 +2,7 @@ package kafkabp
 
 import (
 	"errors"
+	"strings"
 )
 
 // Allowed Offset values
@@ -10,16 +11,163 @@ const (
 	OffsetNewest = "newest"
 )
 
+// Allowed AssignmentStrategy values
+const (
+	AssignmentStrategyRange      = "range"
+	AssignmentStrategyRoundRobin = "roundrobin"
+	AssignmentStrategySticky     = "sticky"
+)
+
 var (
 	// ErrBrokersEmpty is thrown when the slice of brokers is empty.
 	ErrBrokersEmpty = errors.New("kafkabp: Brokers are empty")
 
-	// ErrTopicEmpty is thrown when the topic is empty.
+	// ErrTopicEmpty is thrown when neither Topic nor Topics is set, or when
+	// Topics contains an empty topic.
 	ErrTopicEmpty = errors.New("kafkabp: Topic is empty")
 
 	// ErrClientIDEmpty is thrown when the client ID is empty.
 	ErrClientIDEmpty = errors.New("kafkabp: ClientID is empty")
 
+	// ErrGroupIDEmpty is thrown when the group ID is empty.
+	ErrGroupIDEmpty = errors.New("kafkabp: GroupID is empty")
+
+	// ErrAssignmentStrategyInvalid is thrown when an invalid assignment
+	// strategy is specified.
+	ErrAssignmentStrategyInvalid = errors.New("kafkabp: AssignmentStrategy is invalid")
+
+	// ErrHeartbeatIntervalInvalid is thrown when the group heartbeat interval
+	// is not less than a third of the group session timeout.
+	ErrHeartbeatIntervalInvalid = errors.New("kafkabp: HeartbeatInterval must be less than a third of SessionTimeout")
+
+	// ErrMinPartitionsInvalid is thrown when MinPartitions is negative.
+	ErrMinPartitionsInvalid = errors.New("kafkabp: MinPartitions must not be negative")
+
+	// ErrTooFewPartitions is returned by NewConsumer when a topic has fewer
+	// partitions than MinPartitions, or none.
+	ErrTooFewPartitions = errors.New("kafkabp: topic has too few partitions")
+
+	// ErrCommitIntervalInvalid is thrown when CommitInterval is negative while
+	// auto-commit is enabled.
+	ErrCommitIntervalInvalid = errors.New("kafkabp: CommitInterval must be positive")
+
 	// ErrOffsetInvalid is thrown when an invalid offset is specified.
 	ErrOffsetInvalid = errors.New("kafkabp: Offset is invalid")
+
+	// ErrStartOffsetInvalid is thrown when a negative offset is specified in
+	// StartOffsets.
+	ErrStartOffsetInvalid = errors.New("kafkabp: StartOffsets must not be negative")
+
+	// ErrVersionInvalid is thrown when the kafka version can't be parsed.
+	ErrVersionInvalid = errors.New("kafkabp: Version is invalid")
+
+	// ErrVersionTooOld is thrown when the group consumer is configured with a
+	// Version older than 0.10.2, which doesn't support consumer groups.
+	ErrVersionTooOld = errors.New("kafkabp: Version is too old for consumer groups")
+
+	// ErrSASLMechanismInvalid is thrown when an invalid SASL mechanism is
+	// specified.
+	ErrSASLMechanismInvalid = errors.New("kafkabp: SASL mechanism is invalid")
+
+	// ErrSASLCredentialsEmpty is thrown when a SASL mechanism is specified
+	// without the username or password.
+	ErrSASLCredentialsEmpty = errors.New("kafkabp: SASL username and password are required when a SASL mechanism is set")
+
+	// ErrTLSClientCertInvalid is thrown when only one of the TLS client cert
+	// and key is specified.
+	ErrTLSClientCertInvalid = errors.New("kafkabp: TLS ClientCert and ClientKey must be set together")
+
+	// ErrPayloadCodecInvalid is thrown when an invalid payload codec is
+	// specified.
+	ErrPayloadCodecInvalid = errors.New("kafkabp: PayloadCodec is invalid")
+
+	// ErrMaxConcurrentPerPartitionInvalid is thrown when
+	// MaxConcurrentPerPartition is negative.
+	ErrMaxConcurrentPerPartitionInvalid = errors.New("kafkabp: MaxConcurrentPerPartition is negative")
+
+	// ErrRateLimitInvalid is thrown when a negative rate limit is specified.
+	ErrRateLimitInvalid = errors.New("kafkabp: rate limit is negative")
+
+	// ErrResetMaxAttemptsInvalid is thrown when ResetMaxAttempts is negative.
+	ErrResetMaxAttemptsInvalid = errors.New("kafkabp: ResetMaxAttempts must not be negative")
+
+	// ErrNetworkConfigInvalid is thrown when DialTimeout, MaxOpenRequests,
+	// MetadataRetryMax or MetadataRetryBackoff is negative.
+	ErrNetworkConfigInvalid = errors.New("kafkabp: DialTimeout, MaxOpenRequests, MetadataRetryMax and MetadataRetryBackoff must not be negative")
+
+	// ErrMaxWaitTimeInvalid is thrown when MaxWaitTime is set to less than 1ms.
+	ErrMaxWaitTimeInvalid = errors.New("kafkabp: MaxWaitTime must be at least 1ms")
+
+	// ErrFetchSizeInvalid is thrown when the fetch sizes are negative, or not
+	// in the order of FetchMin <= FetchDefault <= FetchMax.
+	ErrFetchSizeInvalid = errors.New("kafkabp: fetch sizes must not be negative and must satisfy FetchMin <= FetchDefault <= FetchMax")
+
+	// ErrPriorityBufferSizeInvalid is thrown when PriorityBufferSize is
+	// negative.
+	ErrPriorityBufferSizeInvalid = errors.New("kafkabp: PriorityBufferSize must not be negative")
+
+	// ErrMetricsTopicBucketsInvalid is thrown when MetricsTopicBuckets is
+	// negative.
+	ErrMetricsTopicBucketsInvalid = errors.New("kafkabp: MetricsTopicBuckets must not be negative")
+
+	// ErrPartitionNotConsumed is returned by Seek when the partition is not
+	// currently being consumed.
+	ErrPartitionNotConsumed = errors.New("kafkabp: partition is not being consumed")
+
+	// ErrNotGroupConsumerContext is returned by MarkMessage when the context
+	// is not from a group consumer with ManualCommit enabled.
+	ErrNotGroupConsumerContext = errors.New("kafkabp: context is not from a group consumer with ManualCommit enabled")
+
+	// ErrSeekNotSupported is returned by Seek of the group consumer, as the
+	// offsets of a consumer group are managed by the group.
+	ErrSeekNotSupported = errors.New("kafkabp: Seek is not supported by the group consumer")
+
+	// ErrSeekMultipleTopics is returned by Seek of the consumer consuming
+	// multiple topics, as the partition to reposition is ambiguous.
+	ErrSeekMultipleTopics = errors.New("kafkabp: Seek is not supported when consuming multiple topics")
+
+	// ErrTimeBucketIntervalInvalid is returned by the time bucket partitioner
+	// when its interval is not positive.
+	ErrTimeBucketIntervalInvalid = errors.New("kafkabp: time bucket interval must be positive")
 )
+
+// ConfigError is returned by NewSaramaConfig, and so by NewConsumer and
+// NewGroupConsumer, with all the problems found in the config at once, instead
+// of only the first one.
+//
+// errors.Is and errors.As match any of the individual errors, for example:
+//
+//     if errors.Is(err, kafkabp.ErrTopicEmpty) {
+//         // ...
+//     }
+type ConfigError struct {
+	Errors []error
+}
+
+func (e *ConfigError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return "kafkabp: invalid config: " + strings.Join(msgs, "; ")
+}
+
+// Is returns true if any of the individual errors matches target.
+func (e *ConfigError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first individual error that matches target.
+func (e *ConfigError) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package kafkabp
+
+import (
+	"crypto/sha512"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg/scram"
+)
+
+// Supported SASL mechanisms.
+const (
+	SASLMechanismPlain       = "plain"
+	SASLMechanismSCRAMSHA256 = "scram-sha-256"
+	SASLMechanismSCRAMSHA512 = "scram-sha-512"
+)
+
+// SASLConfig can be used to configure SASL authentication with the brokers.
+//
+// Can be deserialized from YAML.
+//
+// Example:
+//
+// sasl:
+//   mechanism: scram-sha-512
+//   username: myuser
+//   password: mypassword
+type SASLConfig struct {
+	// Optional. Defaults to "" (SASL disabled). Valid values are "plain",
+	// "scram-sha-256" and "scram-sha-512".
+	Mechanism string `yaml:"mechanism"`
+
+	// Required when Mechanism is set.
+	Username string `yaml:"username"`
+
+	// Required when Mechanism is set.
+	Password string `yaml:"password"`
+}
+
+// apply validates cfg and maps it onto c.Net.SASL.
+func (cfg SASLConfig) apply(c *sarama.Config) error {
+	var mechanism sarama.SASLMechanism
+	var hashGenerator scram.HashGeneratorFcn
+	switch cfg.Mechanism {
+	case "":
+		return nil
+	case SASLMechanismPlain:
+		mechanism = sarama.SASLTypePlaintext
+	case SASLMechanismSCRAMSHA256:
+		mechanism = sarama.SASLTypeSCRAMSHA256
+		hashGenerator = scram.SHA256
+	case SASLMechanismSCRAMSHA512:
+		mechanism = sarama.SASLTypeSCRAMSHA512
+		hashGenerator = scram.HashGeneratorFcn(sha512.New)
+	default:
+		return ErrSASLMechanismInvalid
+	}
+
+	if cfg.Username == "" || cfg.Password == "" {
+		return ErrSASLCredentialsEmpty
+	}
+
+	c.Net.SASL.Enable = true
+	c.Net.SASL.Mechanism = mechanism
+	c.Net.SASL.User = cfg.Username
+	c.Net.SASL.Password = cfg.Password
+	if hashGenerator != nil {
+		c.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hashGenerator: hashGenerator}
+		}
+	}
+	return nil
+}
+
+// scramClient implements sarama.SCRAMClient.
+type scramClient struct {
+	hashGenerator scram.HashGeneratorFcn
+	conversation  *scram.ClientConversation
+}
+
+// Begin implements sarama.SCRAMClient.
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.hashGenerator.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+// Step implements sarama.SCRAMClient.
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+// Done implements sarama.SCRAMClient.
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
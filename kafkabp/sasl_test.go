@@ -0,0 +1,116 @@
+package kafkabp
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestSASLConfig(t *testing.T) {
+	for _, c := range []struct {
+		label     string
+		cfg       SASLConfig
+		err       error
+		mechanism sarama.SASLMechanism
+		scram     bool
+	}{
+		{
+			label: "disabled",
+		},
+		{
+			label: "invalid-mechanism",
+			cfg: SASLConfig{
+				Mechanism: "foo",
+				Username:  "user",
+				Password:  "pass",
+			},
+			err: ErrSASLMechanismInvalid,
+		},
+		{
+			label: "empty-password",
+			cfg: SASLConfig{
+				Mechanism: SASLMechanismPlain,
+				Username:  "user",
+			},
+			err: ErrSASLCredentialsEmpty,
+		},
+		{
+			label: "plain",
+			cfg: SASLConfig{
+				Mechanism: SASLMechanismPlain,
+				Username:  "user",
+				Password:  "pass",
+			},
+			mechanism: sarama.SASLTypePlaintext,
+		},
+		{
+			label: "scram-sha-256",
+			cfg: SASLConfig{
+				Mechanism: SASLMechanismSCRAMSHA256,
+				Username:  "user",
+				Password:  "pass",
+			},
+			mechanism: sarama.SASLTypeSCRAMSHA256,
+			scram:     true,
+		},
+		{
+			label: "scram-sha-512",
+			cfg: SASLConfig{
+				Mechanism: SASLMechanismSCRAMSHA512,
+				Username:  "user",
+				Password:  "pass",
+			},
+			mechanism: sarama.SASLTypeSCRAMSHA512,
+			scram:     true,
+		},
+	} {
+		t.Run(c.label, func(t *testing.T) {
+			cfg := ConsumerConfig{
+				Brokers:  []string{"127.0.0.1:9090"},
+				Topic:    "kafkabp-test",
+				ClientID: "test-sasl",
+				SASL:     c.cfg,
+			}
+			sc, err := cfg.NewSaramaConfig()
+			if !errors.Is(err, c.err) {
+				t.Fatalf("expected error %v, got %v", c.err, err)
+			}
+			if c.err != nil {
+				return
+			}
+
+			if enabled := c.cfg.Mechanism != ""; sc.Net.SASL.Enable != enabled {
+				t.Errorf("expected SASL enabled %v, got %v", enabled, sc.Net.SASL.Enable)
+			}
+			if !sc.Net.SASL.Enable {
+				return
+			}
+			if sc.Net.SASL.Mechanism != c.mechanism {
+				t.Errorf("expected mechanism %q, got %q", c.mechanism, sc.Net.SASL.Mechanism)
+			}
+			if sc.Net.SASL.User != c.cfg.Username || sc.Net.SASL.Password != c.cfg.Password {
+				t.Errorf("expected credentials %q/%q, got %q/%q", c.cfg.Username, c.cfg.Password, sc.Net.SASL.User, sc.Net.SASL.Password)
+			}
+			if c.scram {
+				if sc.Net.SASL.SCRAMClientGeneratorFunc == nil {
+					t.Fatal("expected SCRAMClientGeneratorFunc to be set")
+				}
+				client := sc.Net.SASL.SCRAMClientGeneratorFunc()
+				if err := client.Begin("user", "pass", ""); err != nil {
+					t.Fatal(err)
+				}
+				first, err := client.Step("")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if first == "" || client.Done() {
+					t.Errorf("expected the client-first message, got %q (done=%v)", first, client.Done())
+				}
+			}
+			if err := sc.Validate(); err != nil {
+				t.Errorf("expected valid sarama config, got %v", err)
+			}
+		})
+	}
+}
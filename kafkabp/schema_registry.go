@@ -0,0 +1,276 @@
+package kafkabp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// ErrSchemaRegistryRequestFailed is returned by SchemaRegistry when the schema
+// registry responds with a non-2xx status.
+var ErrSchemaRegistryRequestFailed = errors.New("kafkabp: schema registry request failed")
+
+// SchemaRegistry is a client of the Confluent schema registry, which caches
+// the schemas and schema IDs it looked up, so only the first message of every
+// schema costs a round-trip to the registry.
+//
+// It's safe for concurrent use.
+type SchemaRegistry struct {
+	url    string
+	client *http.Client
+
+	lock    sync.Mutex
+	schemas map[int32]string
+	ids     map[subjectSchema]int32
+}
+
+// subjectSchema is the key of the schema IDs cached by SchemaRegistry.
+type subjectSchema struct {
+	subject string
+	schema  string
+}
+
+// NewSchemaRegistry creates a SchemaRegistry for the schema registry at
+// registryURL (e.g. "http://schema-registry:8081"), sending the requests with
+// client, or http.DefaultClient when client is nil.
+func NewSchemaRegistry(registryURL string, client *http.Client) *SchemaRegistry {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &SchemaRegistry{
+		url:     strings.TrimSuffix(registryURL, "/"),
+		client:  client,
+		schemas: make(map[int32]string),
+		ids:     make(map[subjectSchema]int32),
+	}
+}
+
+// Schema returns the schema of id, the schema ID of a payload in the schema
+// registry wire format (see DecodeSchemaRegistryPayload).
+func (r *SchemaRegistry) Schema(ctx context.Context, id int32) (string, error) {
+	r.lock.Lock()
+	schema, ok := r.schemas[id]
+	r.lock.Unlock()
+	if ok {
+		return schema, nil
+	}
+
+	var resp struct {
+		Schema string `json:"schema"`
+	}
+	path := "/schemas/ids/" + strconv.FormatInt(int64(id), 10)
+	if err := r.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return "", err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.schemas[id] = resp.Schema
+	return resp.Schema, nil
+}
+
+// Register registers schema under subject (e.g. "<topic>-value"), and returns
+// its schema ID, to frame the payloads encoded with it with
+// EncodeSchemaRegistryPayload.
+//
+// Registering a schema already registered under subject returns its existing
+// ID.
+func (r *SchemaRegistry) Register(ctx context.Context, subject, schema string) (int32, error) {
+	key := subjectSchema{subject: subject, schema: schema}
+	r.lock.Lock()
+	id, ok := r.ids[key]
+	r.lock.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	body, err := json.Marshal(struct {
+		Schema string `json:"schema"`
+	}{Schema: schema})
+	if err != nil {
+		return 0, err
+	}
+	var resp struct {
+		ID int32 `json:"id"`
+	}
+	path := "/subjects/" + url.PathEscape(subject) + "/versions"
+	if err := r.do(ctx, http.MethodPost, path, body, &resp); err != nil {
+		return 0, err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.ids[key] = resp.ID
+	r.schemas[resp.ID] = schema
+	return resp.ID, nil
+}
+
+// do sends a request to the schema registry, and decodes the JSON response
+// into v.
+func (r *SchemaRegistry) do(ctx context.Context, method, path string, body []byte, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, r.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf(
+			"%w: %s %s: status %d: %s",
+			ErrSchemaRegistryRequestFailed,
+			method,
+			path,
+			resp.StatusCode,
+			bytes.TrimSpace(msg),
+		)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// SchemaDecoder decodes the data encoded with a schema, for example with the
+// Avro codec of the schema.
+type SchemaDecoder func(data []byte) (interface{}, error)
+
+// SchemaMessageFunc is the typed handler called by the ConsumeMessageFunc
+// returned by SchemaRegistryConsumer, with v being the decoded message value.
+type SchemaMessageFunc func(ctx context.Context, msg *sarama.ConsumerMessage, v interface{}) error
+
+// SchemaDecodeErrorFunc handles the messages SchemaRegistryConsumer failed to
+// decode, err being a SchemaDecodeError.
+type SchemaDecodeErrorFunc func(ctx context.Context, msg *sarama.ConsumerMessage, err error) error
+
+// SchemaDecodeError is the error returned when the value of a message is not
+// in the schema registry wire format, or fails to decode with its schema.
+type SchemaDecodeError struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	// The schema ID of the message, 0 when it's not in the wire format.
+	SchemaID int32
+
+	Cause error
+}
+
+func (e SchemaDecodeError) Error() string {
+	return fmt.Sprintf(
+		"kafkabp: failed to decode schema registry message: topic=%q partition=%d offset=%d schema=%d: %v",
+		e.Topic,
+		e.Partition,
+		e.Offset,
+		e.SchemaID,
+		e.Cause,
+	)
+}
+
+// Unwrap returns the underlying decode error.
+func (e SchemaDecodeError) Unwrap() error {
+	return e.Cause
+}
+
+// SchemaRegistryConsumer returns a ConsumeMessageFunc that decodes the value
+// of every message in the Confluent schema registry wire format, then passes
+// it to fn.
+//
+// The schema of every message is looked up from registry by its schema ID,
+// and passed to newDecoder to create the SchemaDecoder decoding the messages of
+// that schema. The SchemaDecoders are cached by schema ID, so both happen once
+// per schema. For example, with Avro:
+//
+//     kafkabp.SchemaRegistryConsumer(
+//         registry,
+//         func(schema string) (kafkabp.SchemaDecoder, error) {
+//             codec, err := goavro.NewCodec(schema)
+//             if err != nil {
+//                 return nil, err
+//             }
+//             return func(data []byte) (interface{}, error) {
+//                 v, _, err := codec.NativeFromBinary(data)
+//                 return v, err
+//             }, nil
+//         },
+//         func(ctx context.Context, msg *sarama.ConsumerMessage, v interface{}) error {
+//             record := v.(map[string]interface{})
+//             // ...
+//         },
+//         nil, // decodeErrorFunc
+//     )
+//
+// When the value is not in the wire format, or fails to decode, fn is not
+// called and the SchemaDecodeError is passed to decodeErrorFunc, and its
+// result is returned. When decodeErrorFunc is nil, the SchemaDecodeError is
+// returned directly, so it can be handled by the wrappers like WithDeadLetter.
+//
+// The errors looking up the schema from registry (e.g. the registry is
+// unavailable) are returned as-is, as the message could succeed when retried.
+func SchemaRegistryConsumer(
+	registry *SchemaRegistry,
+	newDecoder func(schema string) (SchemaDecoder, error),
+	fn SchemaMessageFunc,
+	decodeErrorFunc SchemaDecodeErrorFunc,
+) ConsumeMessageFunc {
+	var lock sync.Mutex
+	decoders := make(map[int32]SchemaDecoder)
+
+	return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		decodeError := func(schemaID int32, err error) error {
+			err = SchemaDecodeError{
+				Topic:     msg.Topic,
+				Partition: msg.Partition,
+				Offset:    msg.Offset,
+				SchemaID:  schemaID,
+				Cause:     err,
+			}
+			if decodeErrorFunc != nil {
+				return decodeErrorFunc(ctx, msg, err)
+			}
+			return err
+		}
+
+		schemaID, data, err := DecodeSchemaRegistryPayload(msg.Value)
+		if err != nil {
+			return decodeError(0, err)
+		}
+
+		lock.Lock()
+		decoder, ok := decoders[schemaID]
+		lock.Unlock()
+		if !ok {
+			schema, err := registry.Schema(ctx, schemaID)
+			if err != nil {
+				return err
+			}
+			decoder, err = newDecoder(schema)
+			if err != nil {
+				return decodeError(schemaID, err)
+			}
+			lock.Lock()
+			decoders[schemaID] = decoder
+			lock.Unlock()
+		}
+
+		v, err := decoder(data)
+		if err != nil {
+			return decodeError(schemaID, err)
+		}
+		return fn(ctx, msg, v)
+	}
+}
@@ -0,0 +1,183 @@
+package kafkabp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+const testSchema = `{"type":"string"}`
+
+// newTestSchemaRegistry starts a fake schema registry with a single schema of
+// ID 1 registered under subject "test-value", counting the requests.
+func newTestSchemaRegistry(t *testing.T) (*SchemaRegistry, *int64) {
+	t.Helper()
+
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/schemas/ids/1":
+			json.NewEncoder(w).Encode(map[string]string{"schema": testSchema})
+		case r.Method == http.MethodPost && r.URL.Path == "/subjects/test-value/versions":
+			var req struct {
+				Schema string `json:"schema"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Schema != testSchema {
+				http.Error(w, `{"error_code":42201}`, http.StatusUnprocessableEntity)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]int32{"id": 1})
+		default:
+			http.Error(w, `{"error_code":40403}`, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return NewSchemaRegistry(server.URL+"/", server.Client()), &requests
+}
+
+func TestSchemaRegistry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("schema", func(t *testing.T) {
+		registry, requests := newTestSchemaRegistry(t)
+		for i := 0; i < 3; i++ {
+			schema, err := registry.Schema(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if schema != testSchema {
+				t.Errorf("expected schema %q, got %q", testSchema, schema)
+			}
+		}
+		if n := atomic.LoadInt64(requests); n != 1 {
+			t.Errorf("expected the schema to be cached after 1 request, got %d requests", n)
+		}
+
+		if _, err := registry.Schema(ctx, 2); !errors.Is(err, ErrSchemaRegistryRequestFailed) {
+			t.Errorf("expected error %v, got %v", ErrSchemaRegistryRequestFailed, err)
+		}
+	})
+
+	t.Run("register", func(t *testing.T) {
+		registry, requests := newTestSchemaRegistry(t)
+		for i := 0; i < 3; i++ {
+			id, err := registry.Register(ctx, "test-value", testSchema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != 1 {
+				t.Errorf("expected schema id 1, got %d", id)
+			}
+		}
+		// The registered schema is cached by ID as well.
+		if _, err := registry.Schema(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		if n := atomic.LoadInt64(requests); n != 1 {
+			t.Errorf("expected the schema id to be cached after 1 request, got %d requests", n)
+		}
+
+		if _, err := registry.Register(ctx, "test-value", `{"type":"int"}`); !errors.Is(err, ErrSchemaRegistryRequestFailed) {
+			t.Errorf("expected error %v, got %v", ErrSchemaRegistryRequestFailed, err)
+		}
+	})
+}
+
+func TestSchemaRegistryConsumer(t *testing.T) {
+	registry, requests := newTestSchemaRegistry(t)
+
+	var newDecoders int64
+	newDecoder := func(schema string) (SchemaDecoder, error) {
+		atomic.AddInt64(&newDecoders, 1)
+		if schema != testSchema {
+			t.Errorf("expected schema %q, got %q", testSchema, schema)
+		}
+		return func(data []byte) (interface{}, error) {
+			if len(data) == 0 {
+				return nil, errors.New("empty data")
+			}
+			return string(data), nil
+		}, nil
+	}
+
+	var got []interface{}
+	consume := SchemaRegistryConsumer(
+		registry,
+		newDecoder,
+		func(_ context.Context, _ *sarama.ConsumerMessage, v interface{}) error {
+			got = append(got, v)
+			return nil
+		},
+		nil,
+	)
+
+	ctx := context.Background()
+	for _, value := range []string{"foo", "bar"} {
+		msg := &sarama.ConsumerMessage{Value: EncodeSchemaRegistryPayload(1, []byte(value))}
+		if err := consume(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(got) != 2 || got[0] != "foo" || got[1] != "bar" {
+		t.Errorf("expected [foo bar] to be handled, got %v", got)
+	}
+	if n := atomic.LoadInt64(requests); n != 1 {
+		t.Errorf("expected 1 request to the registry, got %d", n)
+	}
+	if n := atomic.LoadInt64(&newDecoders); n != 1 {
+		t.Errorf("expected the decoder to be cached after 1 creation, got %d", n)
+	}
+
+	for _, c := range []struct {
+		label    string
+		value    []byte
+		schemaID int32
+		cause    error
+	}{
+		{
+			label:    "not-wire-format",
+			value:    []byte("foo"),
+			schemaID: 0,
+			cause:    ErrSchemaRegistryPayloadInvalid,
+		},
+		{
+			label:    "decode-failure",
+			value:    EncodeSchemaRegistryPayload(1, nil),
+			schemaID: 1,
+		},
+	} {
+		t.Run(c.label, func(t *testing.T) {
+			msg := &sarama.ConsumerMessage{Topic: "topic", Offset: 3, Value: c.value}
+			err := consume(ctx, msg)
+			var decodeErr SchemaDecodeError
+			if !errors.As(err, &decodeErr) {
+				t.Fatalf("expected SchemaDecodeError, got %v", err)
+			}
+			if decodeErr.SchemaID != c.schemaID || decodeErr.Offset != 3 {
+				t.Errorf("unexpected SchemaDecodeError %#v", decodeErr)
+			}
+			if c.cause != nil && !errors.Is(err, c.cause) {
+				t.Errorf("expected error to wrap %v, got %v", c.cause, err)
+			}
+		})
+	}
+
+	t.Run("unknown-schema", func(t *testing.T) {
+		msg := &sarama.ConsumerMessage{Value: EncodeSchemaRegistryPayload(2, []byte("foo"))}
+		err := consume(ctx, msg)
+		if !errors.Is(err, ErrSchemaRegistryRequestFailed) {
+			t.Errorf("expected error %v, got %v", ErrSchemaRegistryRequestFailed, err)
+		}
+		var decodeErr SchemaDecodeError
+		if errors.As(err, &decodeErr) {
+			t.Errorf("expected the registry error not to be a SchemaDecodeError, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package kafkabp
+
+import (
+	"sync"
+)
+
+// offsetSequencer tracks the completion of messages from a single partition
+// that are processed out of order, and only advances the commit point through
+// the contiguous prefix of completed messages.
+//
+// Offsets are tracked in the order they are dispatched instead of assuming
+// they are contiguous integers, as offsets within a partition could have gaps
+// (e.g. compacted topics or transaction markers).
+type offsetSequencer struct {
+	lock sync.Mutex
+
+	// dispatched but not yet committed offsets, in dispatch order.
+	inflight  []int64
+	completed map[int64]struct{}
+}
+
+func newOffsetSequencer() *offsetSequencer {
+	return &offsetSequencer{
+		completed: make(map[int64]struct{}),
+	}
+}
+
+// dispatch records that the message at offset is being processed.
+//
+// It must be called in the order the messages are delivered by the partition
+// consumer, before the corresponding complete call.
+func (s *offsetSequencer) dispatch(offset int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.inflight = append(s.inflight, offset)
+}
+
+// idle returns true if all the messages dispatched are done processing.
+func (s *offsetSequencer) idle() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.inflight) == 0
+}
+
+// complete records that the message at offset is done processing.
+//
+// If that advances the commit point, it returns the offset of the next message
+// to be consumed after the committed prefix (the "committed offset" in Kafka
+// terms), and true.
+func (s *offsetSequencer) complete(offset int64) (next int64, advanced bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.completed[offset] = struct{}{}
+	for len(s.inflight) > 0 {
+		head := s.inflight[0]
+		if _, ok := s.completed[head]; !ok {
+			break
+		}
+		delete(s.completed, head)
+		s.inflight = s.inflight[1:]
+		next = head + 1
+		advanced = true
+	}
+	return next, advanced
+}
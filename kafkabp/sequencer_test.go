@@ -0,0 +1,50 @@
+package kafkabp
+
+import (
+	"testing"
+)
+
+func TestOffsetSequencer(t *testing.T) {
+	seq := newOffsetSequencer()
+	// Offsets with a gap between 2 and 5.
+	for _, offset := range []int64{1, 2, 5, 6} {
+		seq.dispatch(offset)
+	}
+
+	for _, c := range []struct {
+		offset   int64
+		next     int64
+		advanced bool
+	}{
+		{
+			offset:   2,
+			advanced: false,
+		},
+		{
+			offset:   6,
+			advanced: false,
+		},
+		{
+			offset:   1,
+			next:     3,
+			advanced: true,
+		},
+		{
+			offset:   5,
+			next:     7,
+			advanced: true,
+		},
+	} {
+		next, advanced := seq.complete(c.offset)
+		if advanced != c.advanced {
+			t.Errorf("complete(%d): expected advanced %v, got %v", c.offset, c.advanced, advanced)
+		}
+		if advanced && next != c.next {
+			t.Errorf("complete(%d): expected next %d, got %d", c.offset, c.next, next)
+		}
+	}
+
+	if len(seq.inflight) != 0 || len(seq.completed) != 0 {
+		t.Errorf("expected sequencer to be drained, got %+v", seq)
+	}
+}
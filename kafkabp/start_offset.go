@@ -0,0 +1,111 @@
+package kafkabp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/reddit/baseplate.go/log"
+	"github.com/reddit/baseplate.go/timebp"
+)
+
+// offsetClient is the subset of sarama.Client used to resolve the start
+// offsets.
+type offsetClient interface {
+	Partitions(topic string) ([]int32, error)
+	GetOffset(topic string, partition int32, time int64) (int64, error)
+}
+
+var _ offsetClient = (sarama.Client)(nil)
+
+// initStartOffsets resolves cfg.StartOffsets and cfg.StartTime, if set, into
+// the offsets the partition consumers start from.
+func (kc *consumer) initStartOffsets() error {
+	if len(kc.cfg.StartOffsets) == 0 && kc.cfg.StartTime.IsZero() {
+		return nil
+	}
+
+	client, release, err := kc.newClient("initStartOffsets")
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	offsets, err := resolveStartOffsets(client, kc.cfg, kc.cfg.Logger)
+	if err != nil {
+		return err
+	}
+
+	kc.partitionsLock.Lock()
+	defer kc.partitionsLock.Unlock()
+	kc.offsets = offsets
+	return nil
+}
+
+// resolveStartOffsets returns the offset each partition of cfg.Topic should
+// start from according to cfg.StartOffsets and cfg.StartTime.
+//
+// The partitions not in the returned map start from cfg.Offset.
+func resolveStartOffsets(client offsetClient, cfg ConsumerConfig, logger log.Wrapper) (map[int32]int64, error) {
+	partitions, err := client.Partitions(cfg.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[int32]int64, len(partitions))
+	for _, p := range partitions {
+		if offset, ok := cfg.StartOffsets[p]; ok {
+			offset, err = clampOffset(client, cfg.Topic, p, offset, logger)
+			if err != nil {
+				return nil, err
+			}
+			offsets[p] = offset
+			continue
+		}
+
+		if !cfg.StartTime.IsZero() {
+			// When there's no message at or after StartTime, kafka returns -1,
+			// which is the same as sarama.OffsetNewest.
+			offset, err := client.GetOffset(cfg.Topic, p, timebp.TimeToMilliseconds(cfg.StartTime))
+			if err != nil {
+				return nil, err
+			}
+			offsets[p] = offset
+		}
+	}
+	return offsets, nil
+}
+
+// clampOffset returns offset clamped into the range of the offsets currently
+// available in the partition.
+func clampOffset(client offsetClient, topic string, partition int32, offset int64, logger log.Wrapper) (int64, error) {
+	oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, err
+	}
+	newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, err
+	}
+
+	clamped := offset
+	if clamped < oldest {
+		clamped = oldest
+	}
+	if clamped > newest {
+		clamped = newest
+	}
+	if clamped != offset {
+		logger.Log(context.Background(), fmt.Sprintf(
+			"kafkabp.consumer: StartOffsets: topic %q partition %d offset %d is out of range [%d, %d], starting at %d instead",
+			topic,
+			partition,
+			offset,
+			oldest,
+			newest,
+			clamped,
+		))
+	}
+	return clamped, nil
+}
@@ -0,0 +1,118 @@
+package kafkabp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/reddit/baseplate.go/timebp"
+)
+
+type fakeOffsetClient struct {
+	partitions []int32
+	oldest     int64
+	newest     int64
+	// The offsets returned for timestamps, by partition.
+	byTime map[int32]int64
+
+	err error
+}
+
+func (c fakeOffsetClient) Partitions(topic string) ([]int32, error) {
+	return c.partitions, c.err
+}
+
+func (c fakeOffsetClient) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	switch time {
+	case sarama.OffsetOldest:
+		return c.oldest, c.err
+	case sarama.OffsetNewest:
+		return c.newest, c.err
+	}
+	return c.byTime[partition], c.err
+}
+
+func TestResolveStartOffsets(t *testing.T) {
+	client := fakeOffsetClient{
+		partitions: []int32{0, 1, 2},
+		oldest:     10,
+		newest:     100,
+		byTime: map[int32]int64{
+			0: 20,
+			1: 30,
+			2: -1,
+		},
+	}
+
+	t.Run("offsets", func(t *testing.T) {
+		offsets, err := resolveStartOffsets(client, ConsumerConfig{
+			StartOffsets: map[int32]int64{
+				0: 50,
+				1: 5,
+				2: 500,
+			},
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[int32]int64{
+			0: 50,
+			1: 10,
+			2: 100,
+		}
+		for p, offset := range expected {
+			if offsets[p] != offset {
+				t.Errorf("partition %d: expected offset %d, got %d", p, offset, offsets[p])
+			}
+		}
+	})
+
+	t.Run("time", func(t *testing.T) {
+		offsets, err := resolveStartOffsets(client, ConsumerConfig{
+			StartOffsets: map[int32]int64{
+				0: 50,
+			},
+			StartTime: timebp.MillisecondsToTime(1000),
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[int32]int64{
+			0: 50,
+			1: 30,
+			2: sarama.OffsetNewest,
+		}
+		for p, offset := range expected {
+			if offsets[p] != offset {
+				t.Errorf("partition %d: expected offset %d, got %d", p, offset, offsets[p])
+			}
+		}
+	})
+
+	t.Run("partial", func(t *testing.T) {
+		offsets, err := resolveStartOffsets(client, ConsumerConfig{
+			StartOffsets: map[int32]int64{
+				1: 50,
+			},
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(offsets) != 1 {
+			t.Errorf("expected only partition 1 to be resolved, got %v", offsets)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		client := client
+		client.err = errors.New("foo")
+		_, err := resolveStartOffsets(client, ConsumerConfig{
+			StartTime: time.Now(),
+		}, nil)
+		if !errors.Is(err, client.err) {
+			t.Errorf("expected error %v, got %v", client.err, err)
+		}
+	})
+}
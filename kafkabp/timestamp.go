@@ -0,0 +1,45 @@
+package kafkabp
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Allowed timestamp types of MessageTimestamp, which are the values of the
+// message.timestamp.type config of the topic.
+const (
+	// The timestamps are set by the producers when the messages are created.
+	TimestampTypeCreate = "create"
+	// The timestamps are set by the brokers when the messages are appended to
+	// the log.
+	TimestampTypeLogAppend = "logAppend"
+)
+
+// MessageTimestamp returns the effective timestamp of m consumed from a topic
+// of timestampType, or zero time if m carries no timestamp (brokers older
+// than 0.10).
+//
+// sarama exposes two timestamps of a consumed message:
+//
+// - Timestamp is the timestamp of the message itself. It's the producer's
+// create time, or the broker's log append time when the broker marked the
+// message with it.
+//
+// - BlockTimestamp is the timestamp of the compressed message set wrapping the
+// message, only set with the message format before kafka 0.11. It's the
+// broker's log append time for TimestampTypeLogAppend topics, even when the
+// inner messages still carry the create time.
+//
+// For TimestampTypeLogAppend, BlockTimestamp is preferred when set, so the
+// broker's time is returned regardless of the message format. Otherwise
+// Timestamp is returned, falling back to BlockTimestamp when it's not set.
+func MessageTimestamp(m *sarama.ConsumerMessage, timestampType string) time.Time {
+	if timestampType == TimestampTypeLogAppend && !m.BlockTimestamp.IsZero() {
+		return m.BlockTimestamp
+	}
+	if !m.Timestamp.IsZero() {
+		return m.Timestamp
+	}
+	return m.BlockTimestamp
+}
@@ -0,0 +1,61 @@
+package kafkabp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestMessageTimestamp(t *testing.T) {
+	create := time.Unix(100, 0)
+	logAppend := time.Unix(200, 0)
+
+	for _, c := range []struct {
+		label         string
+		msg           *sarama.ConsumerMessage
+		timestampType string
+		expected      time.Time
+	}{
+		{
+			label:         "create",
+			msg:           &sarama.ConsumerMessage{Timestamp: create, BlockTimestamp: logAppend},
+			timestampType: TimestampTypeCreate,
+			expected:      create,
+		},
+		{
+			label:         "log-append-block",
+			msg:           &sarama.ConsumerMessage{Timestamp: create, BlockTimestamp: logAppend},
+			timestampType: TimestampTypeLogAppend,
+			expected:      logAppend,
+		},
+		{
+			label:         "log-append-record",
+			msg:           &sarama.ConsumerMessage{Timestamp: logAppend},
+			timestampType: TimestampTypeLogAppend,
+			expected:      logAppend,
+		},
+		{
+			label:    "unknown-type",
+			msg:      &sarama.ConsumerMessage{Timestamp: create, BlockTimestamp: logAppend},
+			expected: create,
+		},
+		{
+			label:         "block-only",
+			msg:           &sarama.ConsumerMessage{BlockTimestamp: logAppend},
+			timestampType: TimestampTypeCreate,
+			expected:      logAppend,
+		},
+		{
+			label:         "none",
+			msg:           &sarama.ConsumerMessage{},
+			timestampType: TimestampTypeCreate,
+		},
+	} {
+		t.Run(c.label, func(t *testing.T) {
+			if got := MessageTimestamp(c.msg, c.timestampType); !got.Equal(c.expected) {
+				t.Errorf("expected %v, got %v", c.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package kafkabp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Shopify/sarama"
+)
+
+// TLSConfig can be used to configure TLS connections to the brokers.
+//
+// Can be deserialized from YAML.
+//
+// Example:
+//
+// tls:
+//   enabled: true
+//   caCert: /var/run/kafka/ca.pem
+//   clientCert: /var/run/kafka/client.pem
+//   clientKey: /var/run/kafka/client-key.pem
+type TLSConfig struct {
+	// Optional. Defaults to false. When true, connections to the brokers use
+	// TLS.
+	Enabled bool `yaml:"enabled"`
+
+	// Optional. Path to a PEM encoded CA certificate used to verify the
+	// brokers, instead of the system's root CAs.
+	CACert string `yaml:"caCert"`
+
+	// Optional. Paths to a PEM encoded client certificate and its key, used
+	// to authenticate with the brokers. They must be set together.
+	ClientCert string `yaml:"clientCert"`
+	ClientKey  string `yaml:"clientKey"`
+
+	// Optional. Defaults to false. When true, the certificates of the brokers
+	// are not verified. It should only be used for testing.
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
+}
+
+// apply loads the files referenced by cfg and maps it onto c.Net.TLS.
+func (cfg TLSConfig) apply(c *sarama.Config) error {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	if (cfg.ClientCert == "") != (cfg.ClientKey == "") {
+		return ErrTLSClientCertInvalid
+	}
+
+	tc := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CACert != "" {
+		pem, err := ioutil.ReadFile(cfg.CACert)
+		if err != nil {
+			return fmt.Errorf("kafkabp: reading TLS CA cert: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("kafkabp: no PEM encoded certificate found in TLS CA cert %q", cfg.CACert)
+		}
+		tc.RootCAs = pool
+	}
+
+	if cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return fmt.Errorf("kafkabp: loading TLS client cert: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	c.Net.TLS.Enable = true
+	c.Net.TLS.Config = tc
+	return nil
+}
@@ -0,0 +1,148 @@
+package kafkabp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed PEM encoded certificate and its key into
+// dir, and returns their paths.
+func writeTestCert(t *testing.T, dir string) (certPath, keyPath string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kafkabp-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath = filepath.Join(dir, "cert.pem")
+	keyPath = filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafkabp-tls-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certPath, keyPath := writeTestCert(t, dir)
+
+	newConfig := func(tc TLSConfig) ConsumerConfig {
+		return ConsumerConfig{
+			Brokers:  []string{"127.0.0.1:9090"},
+			Topic:    "kafkabp-test",
+			ClientID: "test-tls",
+			TLS:      tc,
+		}
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg := newConfig(TLSConfig{CACert: certPath})
+		sc, err := cfg.NewSaramaConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sc.Net.TLS.Enable {
+			t.Error("expected TLS to be disabled")
+		}
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		cfg := newConfig(TLSConfig{
+			Enabled:            true,
+			CACert:             certPath,
+			ClientCert:         certPath,
+			ClientKey:          keyPath,
+			InsecureSkipVerify: true,
+		})
+		sc, err := cfg.NewSaramaConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sc.Net.TLS.Enable || sc.Net.TLS.Config == nil {
+			t.Fatal("expected TLS to be enabled")
+		}
+		if sc.Net.TLS.Config.RootCAs == nil {
+			t.Error("expected RootCAs to be set")
+		}
+		if len(sc.Net.TLS.Config.Certificates) != 1 {
+			t.Errorf("expected 1 client certificate, got %d", len(sc.Net.TLS.Config.Certificates))
+		}
+		if !sc.Net.TLS.Config.InsecureSkipVerify {
+			t.Error("expected InsecureSkipVerify to be true")
+		}
+	})
+
+	t.Run("missing-key", func(t *testing.T) {
+		cfg := newConfig(TLSConfig{
+			Enabled:    true,
+			ClientCert: certPath,
+		})
+		sc, err := cfg.NewSaramaConfig()
+		if sc != nil {
+			t.Errorf("expected config to be nil, got %v", sc)
+		}
+		if !errors.Is(err, ErrTLSClientCertInvalid) {
+			t.Errorf("expected error %v, got %v", ErrTLSClientCertInvalid, err)
+		}
+	})
+
+	t.Run("missing-file", func(t *testing.T) {
+		cfg := newConfig(TLSConfig{
+			Enabled: true,
+			CACert:  filepath.Join(dir, "missing.pem"),
+		})
+		sc, err := cfg.NewSaramaConfig()
+		if sc != nil {
+			t.Errorf("expected config to be nil, got %v", sc)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected error %v, got %v", os.ErrNotExist, err)
+		}
+	})
+
+	t.Run("invalid-ca", func(t *testing.T) {
+		cfg := newConfig(TLSConfig{
+			Enabled: true,
+			CACert:  keyPath,
+		})
+		if _, err := cfg.NewSaramaConfig(); err == nil {
+			t.Error("expected error for a CA cert without certificates, got nil")
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package kafkabp
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	opentracing "github.com/opentracing/opentracing-go"
+
+	"github.com/reddit/baseplate.go/tracing"
+)
+
+// Kafka record headers used to propagate the tracing context from the
+// producer to the consumer.
+const (
+	HeaderTracingTrace   = "X-Trace-ID"
+	HeaderTracingSpan    = "X-Span-ID"
+	HeaderTracingFlags   = "X-Flags"
+	HeaderTracingSampled = "X-Sampled"
+)
+
+// HeaderTracingSampledTrue is the header value to indicate that this trace
+// should be sampled.
+const HeaderTracingSampledTrue = "1"
+
+// TraceHeaderKeys are the names of the Kafka record headers used to propagate
+// the tracing context, for interoperability with producers following other
+// conventions.
+//
+// The empty fields default to the baseplate conventions (HeaderTracingTrace,
+// HeaderTracingSpan, HeaderTracingFlags and HeaderTracingSampled).
+type TraceHeaderKeys struct {
+	TraceID string `yaml:"traceID"`
+	SpanID  string `yaml:"spanID"`
+	Flags   string `yaml:"flags"`
+	Sampled string `yaml:"sampled"`
+}
+
+// withDefaults returns keys with the empty fields set to the baseplate
+// conventions.
+func (keys TraceHeaderKeys) withDefaults() TraceHeaderKeys {
+	if keys.TraceID == "" {
+		keys.TraceID = HeaderTracingTrace
+	}
+	if keys.SpanID == "" {
+		keys.SpanID = HeaderTracingSpan
+	}
+	if keys.Flags == "" {
+		keys.Flags = HeaderTracingFlags
+	}
+	if keys.Sampled == "" {
+		keys.Sampled = HeaderTracingSampled
+	}
+	return keys
+}
+
+// SpanStarter starts the span every message is handled within.
+//
+// It allows the consumers to use tracing implementations other than the
+// baseplate tracing package, for example OpenTelemetry, by setting
+// ConsumerConfig.SpanStarter.
+type SpanStarter interface {
+	// StartSpan starts the span of m, and returns ctx with the span attached,
+	// and the function to finish the span with the error returned by the
+	// ConsumeMessageFunc.
+	StartSpan(ctx context.Context, m *sarama.ConsumerMessage) (context.Context, func(err error))
+}
+
+// BaseplateSpanStarter is the default SpanStarter, which starts a server span
+// of the baseplate tracing package named "consumer.<topic>".
+//
+// The span continues the trace from the tracing headers of the message set by
+// AttachTracingHeaders, or is a top level span when they are absent.
+//
+// The span is tagged with the topic, partition, offset, key and value size of
+// the message, as "kafka.topic", "kafka.partition", "kafka.offset",
+// "kafka.key" and "kafka.message.size", to find the message handled by a slow
+// or failed span.
+type BaseplateSpanStarter struct {
+	// The headers the tracing context is read from.
+	HeaderKeys TraceHeaderKeys
+}
+
+var _ SpanStarter = BaseplateSpanStarter{}
+
+// StartSpan implements SpanStarter.
+func (s BaseplateSpanStarter) StartSpan(ctx context.Context, m *sarama.ConsumerMessage) (context.Context, func(err error)) {
+	spanName := "consumer." + m.Topic
+	ctx, span := tracing.StartSpanFromHeaders(ctx, spanName, tracingHeaders(m, s.HeaderKeys))
+	span.SetTag("kafka.topic", m.Topic)
+	span.SetTag("kafka.partition", m.Partition)
+	span.SetTag("kafka.offset", m.Offset)
+	span.SetTag("kafka.key", string(m.Key))
+	span.SetTag("kafka.message.size", len(m.Value))
+	return ctx, func(err error) {
+		span.FinishWithOptions(tracing.FinishOptions{
+			Ctx: ctx,
+			Err: err,
+		}.Convert())
+	}
+}
+
+// AttachTracingHeaders sets the tracing headers of msg from the span attached
+// to ctx, if any, replacing any existing ones.
+//
+// The consumers in this package start the span of the message as a child of
+// that span, so the trace continues across kafka.
+func AttachTracingHeaders(ctx context.Context, msg *sarama.ProducerMessage) {
+	AttachTracingHeadersWithKeys(ctx, msg, TraceHeaderKeys{})
+}
+
+// AttachTracingHeadersWithKeys is AttachTracingHeaders with the header names
+// in keys, for the consumers configured with the same
+// ConsumerConfig.TraceHeaderKeys.
+func AttachTracingHeadersWithKeys(ctx context.Context, msg *sarama.ProducerMessage, keys TraceHeaderKeys) {
+	span, ok := opentracing.SpanFromContext(ctx).(*tracing.Span)
+	if !ok || span == nil {
+		return
+	}
+
+	keys = keys.withDefaults()
+	setProducerHeader(msg, keys.TraceID, strconv.FormatUint(span.TraceID(), 10))
+	setProducerHeader(msg, keys.SpanID, strconv.FormatUint(span.ID(), 10))
+	setProducerHeader(msg, keys.Flags, strconv.FormatInt(span.Flags(), 10))
+	sampled := "0"
+	if span.Sampled() {
+		sampled = HeaderTracingSampledTrue
+	}
+	setProducerHeader(msg, keys.Sampled, sampled)
+}
+
+// setProducerHeader sets the header key of msg to value, replacing the
+// existing one if any.
+func setProducerHeader(msg *sarama.ProducerMessage, key, value string) {
+	header := sarama.RecordHeader{
+		Key:   []byte(key),
+		Value: []byte(value),
+	}
+	for i, h := range msg.Headers {
+		if string(h.Key) == key {
+			msg.Headers[i] = header
+			return
+		}
+	}
+	msg.Headers = append(msg.Headers, header)
+}
+
+// tracingHeaders returns the tracing.Headers read from the tracing headers of
+// m named by keys.
+func tracingHeaders(m *sarama.ConsumerMessage, keys TraceHeaderKeys) tracing.Headers {
+	keys = keys.withDefaults()
+	var headers tracing.Headers
+	for _, h := range m.Headers {
+		if h == nil {
+			continue
+		}
+		switch string(h.Key) {
+		case keys.TraceID:
+			headers.TraceID = string(h.Value)
+		case keys.SpanID:
+			headers.SpanID = string(h.Value)
+		case keys.Flags:
+			headers.Flags = string(h.Value)
+		case keys.Sampled:
+			sampled := string(h.Value) == HeaderTracingSampledTrue
+			headers.Sampled = &sampled
+		}
+	}
+	return headers
+}
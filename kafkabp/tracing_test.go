@@ -0,0 +1,248 @@
+package kafkabp
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	opentracing "github.com/opentracing/opentracing-go"
+
+	"github.com/reddit/baseplate.go/tracing"
+)
+
+func TestTracingHeaders(t *testing.T) {
+	t.Run("no-span", func(t *testing.T) {
+		msg := &sarama.ProducerMessage{}
+		AttachTracingHeaders(context.Background(), msg)
+		if len(msg.Headers) != 0 {
+			t.Errorf("expected no headers, got %v", msg.Headers)
+		}
+	})
+
+	t.Run("round-trip", func(t *testing.T) {
+		ctx, producerSpan := tracing.StartTopLevelServerSpan(context.Background(), "producer")
+		defer producerSpan.Stop(ctx, nil)
+
+		msg := &sarama.ProducerMessage{
+			Headers: []sarama.RecordHeader{
+				{Key: []byte(HeaderTracingTrace), Value: []byte("stale")},
+			},
+		}
+		AttachTracingHeaders(ctx, msg)
+		if len(msg.Headers) != 4 {
+			t.Fatalf("expected 4 headers, got %v", msg.Headers)
+		}
+
+		m := getTestKafkaMessage("key", "value")
+		for i := range msg.Headers {
+			m.Headers = append(m.Headers, &msg.Headers[i])
+		}
+
+		var consumerSpan *tracing.Span
+		err := TracingMiddleware(func(ctx context.Context, _ *sarama.ConsumerMessage) error {
+			consumerSpan = opentracing.SpanFromContext(ctx).(*tracing.Span)
+			return nil
+		})(context.Background(), m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if consumerSpan.TraceID() != producerSpan.TraceID() {
+			t.Errorf("expected trace id %d, got %d", producerSpan.TraceID(), consumerSpan.TraceID())
+		}
+		if consumerSpan.ParentID() != producerSpan.ID() {
+			t.Errorf("expected parent id %d, got %d", producerSpan.ID(), consumerSpan.ParentID())
+		}
+		if consumerSpan.Sampled() != producerSpan.Sampled() {
+			t.Errorf("expected sampled %v, got %v", producerSpan.Sampled(), consumerSpan.Sampled())
+		}
+	})
+
+	t.Run("custom-keys", func(t *testing.T) {
+		ctx, producerSpan := tracing.StartTopLevelServerSpan(context.Background(), "producer")
+		defer producerSpan.Stop(ctx, nil)
+
+		keys := TraceHeaderKeys{TraceID: "trace-id", SpanID: "span-id"}
+		msg := &sarama.ProducerMessage{}
+		AttachTracingHeadersWithKeys(ctx, msg, keys)
+		headers := make(map[string]bool, len(msg.Headers))
+		for _, h := range msg.Headers {
+			headers[string(h.Key)] = true
+		}
+		for _, key := range []string{"trace-id", "span-id", HeaderTracingFlags, HeaderTracingSampled} {
+			if !headers[key] {
+				t.Errorf("expected header %q, got %v", key, msg.Headers)
+			}
+		}
+
+		m := getTestKafkaMessage("key", "value")
+		for i := range msg.Headers {
+			m.Headers = append(m.Headers, &msg.Headers[i])
+		}
+		var consumerSpan *tracing.Span
+		SpanMiddleware(BaseplateSpanStarter{HeaderKeys: keys})(func(ctx context.Context, _ *sarama.ConsumerMessage) error {
+			consumerSpan = opentracing.SpanFromContext(ctx).(*tracing.Span)
+			return nil
+		})(context.Background(), m)
+		if consumerSpan.TraceID() != producerSpan.TraceID() {
+			t.Errorf("expected trace id %d, got %d", producerSpan.TraceID(), consumerSpan.TraceID())
+		}
+
+		// The default keys don't match the custom ones.
+		TracingMiddleware(func(ctx context.Context, _ *sarama.ConsumerMessage) error {
+			consumerSpan = opentracing.SpanFromContext(ctx).(*tracing.Span)
+			return nil
+		})(context.Background(), m)
+		if consumerSpan.TraceID() == producerSpan.TraceID() {
+			t.Error("expected a new trace with the default keys")
+		}
+	})
+
+	t.Run("no-headers", func(t *testing.T) {
+		var consumerSpan *tracing.Span
+		TracingMiddleware(func(ctx context.Context, _ *sarama.ConsumerMessage) error {
+			consumerSpan = opentracing.SpanFromContext(ctx).(*tracing.Span)
+			return nil
+		})(context.Background(), getTestKafkaMessage("key", "value"))
+		if consumerSpan.ParentID() != 0 {
+			t.Errorf("expected a top level span, got parent id %d", consumerSpan.ParentID())
+		}
+	})
+}
+
+type spanStarterKey struct{}
+
+// fakeSpanStarter is a SpanStarter recording the spans started and finished.
+type fakeSpanStarter struct {
+	started  []string
+	finished []error
+}
+
+func (s *fakeSpanStarter) StartSpan(ctx context.Context, m *sarama.ConsumerMessage) (context.Context, func(err error)) {
+	s.started = append(s.started, m.Topic)
+	return context.WithValue(ctx, spanStarterKey{}, m.Topic), func(err error) {
+		s.finished = append(s.finished, err)
+	}
+}
+
+func TestSpanStarter(t *testing.T) {
+	starter := &fakeSpanStarter{}
+	kc := getTestConsumer(t)
+	kc.cfg.SpanStarter = starter
+
+	handlerErr := errors.New("handler error")
+	var topic interface{}
+	kc.handleMessage(
+		getTestKafkaMessage("key", "value"),
+		func(ctx context.Context, _ *sarama.ConsumerMessage) error {
+			topic = ctx.Value(spanStarterKey{})
+			return handlerErr
+		},
+		func(context.Context, error) {},
+	)
+
+	msg := getTestKafkaMessage("key", "value")
+	if len(starter.started) != 1 || starter.started[0] != msg.Topic {
+		t.Errorf("expected a span started for topic %q, got %v", msg.Topic, starter.started)
+	}
+	if topic != msg.Topic {
+		t.Errorf("expected the handler context to come from the SpanStarter, got %v", topic)
+	}
+	if len(starter.finished) != 1 || !errors.Is(starter.finished[0], handlerErr) {
+		t.Errorf("expected the span finished with %v, got %v", handlerErr, starter.finished)
+	}
+}
+
+func TestTracingConfig(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		starter := &fakeSpanStarter{}
+		kc := getTestConsumer(t)
+		kc.cfg.Tracing = nil
+		kc.cfg.SpanStarter = starter
+
+		var handled bool
+		kc.handleMessage(
+			getTestKafkaMessage("key", "value"),
+			func(context.Context, *sarama.ConsumerMessage) error {
+				handled = true
+				return nil
+			},
+			func(context.Context, error) {},
+		)
+		if !handled {
+			t.Error("expected the message to be handled")
+		}
+		if len(starter.started) != 1 {
+			t.Errorf("expected tracing enabled by default, got %d spans", len(starter.started))
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		starter := &fakeSpanStarter{}
+		kc := getTestConsumer(t)
+		disabled := false
+		kc.cfg.Tracing = &disabled
+		kc.cfg.SpanStarter = starter
+
+		var handled bool
+		kc.handleMessage(
+			getTestKafkaMessage("key", "value"),
+			func(ctx context.Context, _ *sarama.ConsumerMessage) error {
+				handled = true
+				if span := opentracing.SpanFromContext(ctx); span != nil {
+					t.Errorf("expected no span, got %v", span)
+				}
+				return nil
+			},
+			func(context.Context, error) {},
+		)
+		if !handled {
+			t.Error("expected the message to be handled")
+		}
+		if len(starter.started) != 0 {
+			t.Errorf("expected no span started, got %v", starter.started)
+		}
+	})
+}
+
+// spanTagRecorder is a tracing hook recording the tags set on the server
+// spans.
+type spanTagRecorder struct {
+	tags map[string]interface{}
+}
+
+func (r *spanTagRecorder) OnCreateServerSpan(span *tracing.Span) error {
+	span.AddHooks(r)
+	return nil
+}
+
+func (r *spanTagRecorder) OnSetTag(_ *tracing.Span, key string, value interface{}) error {
+	r.tags[key] = value
+	return nil
+}
+
+func TestBaseplateSpanStarterTags(t *testing.T) {
+	recorder := &spanTagRecorder{tags: make(map[string]interface{})}
+	tracing.RegisterCreateServerSpanHooks(recorder)
+	defer tracing.ResetHooks()
+
+	m := getTestKafkaMessage("key", "value")
+	m.Partition = 2
+	m.Offset = 42
+	_, finish := BaseplateSpanStarter{}.StartSpan(context.Background(), m)
+	finish(nil)
+
+	expected := map[string]interface{}{
+		"kafka.topic":        m.Topic,
+		"kafka.partition":    int32(2),
+		"kafka.offset":       int64(42),
+		"kafka.key":          "key",
+		"kafka.message.size": len("value"),
+	}
+	for key, value := range expected {
+		if recorder.tags[key] != value {
+			t.Errorf("expected tag %q to be %v, got %v", key, value, recorder.tags[key])
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package kafkabp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// schemaRegistryMagicByte is the first byte of the payloads in the Confluent
+// schema registry wire format.
+const schemaRegistryMagicByte = 0
+
+// schemaRegistryHeaderSize is the size of the magic byte plus the 4-byte
+// schema ID.
+const schemaRegistryHeaderSize = 5
+
+// ErrSchemaRegistryPayloadInvalid is returned by DecodeSchemaRegistryPayload
+// when the payload is not in the Confluent schema registry wire format.
+var ErrSchemaRegistryPayloadInvalid = errors.New("kafkabp: payload is not in the schema registry wire format")
+
+// EncodeSchemaRegistryPayload frames data in the Confluent schema registry wire
+// format, by prepending the magic byte and the big endian schemaID.
+//
+// data is the payload already encoded with the schema (e.g. Avro binary
+// encoding), and schemaID is the ID of that schema in the schema registry.
+func EncodeSchemaRegistryPayload(schemaID int32, data []byte) []byte {
+	payload := make([]byte, schemaRegistryHeaderSize+len(data))
+	payload[0] = schemaRegistryMagicByte
+	binary.BigEndian.PutUint32(payload[1:schemaRegistryHeaderSize], uint32(schemaID))
+	copy(payload[schemaRegistryHeaderSize:], data)
+	return payload
+}
+
+// DecodeSchemaRegistryPayload strips the Confluent schema registry wire format
+// framing from payload, and returns the schema ID and the data encoded with
+// that schema.
+//
+// It returns ErrSchemaRegistryPayloadInvalid if payload is too short or doesn't
+// start with the magic byte. The returned data shares the underlying array
+// with payload.
+func DecodeSchemaRegistryPayload(payload []byte) (schemaID int32, data []byte, err error) {
+	if len(payload) < schemaRegistryHeaderSize || payload[0] != schemaRegistryMagicByte {
+		return 0, nil, ErrSchemaRegistryPayloadInvalid
+	}
+	schemaID = int32(binary.BigEndian.Uint32(payload[1:schemaRegistryHeaderSize]))
+	return schemaID, payload[schemaRegistryHeaderSize:], nil
+}
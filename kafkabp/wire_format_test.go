@@ -0,0 +1,37 @@
+package kafkabp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSchemaRegistryPayload(t *testing.T) {
+	data := []byte("avro data")
+	payload := EncodeSchemaRegistryPayload(42, data)
+	expected := append([]byte{0, 0, 0, 0, 42}, data...)
+	if !bytes.Equal(payload, expected) {
+		t.Errorf("expected payload %x, got %x", expected, payload)
+	}
+
+	schemaID, decoded, err := DecodeSchemaRegistryPayload(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schemaID != 42 {
+		t.Errorf("expected schema ID 42, got %d", schemaID)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Errorf("expected data %q, got %q", data, decoded)
+	}
+
+	for _, invalid := range [][]byte{
+		nil,
+		{0, 0, 0},
+		{1, 0, 0, 0, 42, 'a'},
+	} {
+		if _, _, err := DecodeSchemaRegistryPayload(invalid); !errors.Is(err, ErrSchemaRegistryPayloadInvalid) {
+			t.Errorf("expected error %v for %x, got %v", ErrSchemaRegistryPayloadInvalid, invalid, err)
+		}
+	}
+}